@@ -1,8 +1,13 @@
 package cmd
 
 import (
+	"context"
 	"fmt"
+	"os"
+	"os/signal"
 	"os/user"
+	"strconv"
+	"time"
 
 	magellan "github.com/OpenCHAMI/magellan/internal"
 	"github.com/OpenCHAMI/magellan/internal/api/smd"
@@ -15,7 +20,94 @@ import (
 )
 
 var (
-	forceUpdate bool
+	forceUpdate         bool
+	busyRetries         int
+	smdCsvPath          string
+	syncPower           bool
+	xnameMap            string
+	collectPower        bool
+	junitPath           string
+	collectOem          bool
+	cooldownPath        string
+	cooldown            time.Duration
+	forceRetry          bool
+	collectRedundancy   bool
+	envelope            bool
+	envelopeKey         string
+	collectTelemetry    bool
+	maxMetricValues     int
+	collectIpmiLan      bool
+	ipmiLanChannel      int
+	resetBMC            string
+	deterministic       bool
+	biosProfile         string
+	encrypt             bool
+	encryptionKeyPath   string
+	xnameGenerator      string
+	resultBuffer        int
+	collectCertificates bool
+	collectStorage      bool
+	collectDrives       bool
+	ipmiPort            int
+	smdEndpoint         string
+	dryRun              bool
+	collectFirmware     bool
+	collectPowerState   bool
+	retries             int
+	retryDelay          time.Duration
+	outputFormat        string
+	inventoryCsvPath    string
+	credentialsPath     string
+	vaultAddr           string
+	vaultMount          string
+	vaultPath           string
+	secureTLS           bool
+	collectSEL          bool
+	maxLogEntries       int
+	collectThermal      bool
+	collectPowerSensors bool
+	collectServiceRoot  bool
+	expandQuery         bool
+	probeStatesPath     string
+	summaryPath         string
+	proxy               string
+	rateLimit           float64
+	metricsAddr         string
+	sections            []string
+	outputFileMode      string
+	outputDirMode       string
+	redactCredentials   bool
+	globalTimeout       time.Duration
+	hostsFilePath       string
+	collectBoot         bool
+	dedupe              bool
+	skipUnchanged       bool
+	stateCachePath      string
+	touchUnchanged      bool
+	vendorQuirks        bool
+	preferredProviders  map[string]string
+	connectTimeout      time.Duration
+	queryTimeout        time.Duration
+	outboxPath          string
+	recordsDbPath       string
+	collectManagers     bool
+	maxClockSkew        time.Duration
+	collectEthernet     bool
+	ethernetPaths       []string
+	macMapPath          string
+	smdHeaders          map[string]string
+	smdTokenUrl         string
+	smdClientID         string
+	smdClientSecret     string
+	compact             bool
+	resolveFQDN         bool
+	drivers             []string
+	kafkaBrokers        []string
+	kafkaTopic          string
+	kafkaSASLMechanism  string
+	kafkaUser           string
+	kafkaPass           string
+	kafkaTLS            bool
 )
 
 var collectCmd = &cobra.Command{
@@ -25,8 +117,18 @@ var collectCmd = &cobra.Command{
 		// make application logger
 		l := log.NewLogger(logrus.New(), logrus.DebugLevel)
 
-		// get probe states stored in db from scan
-		probeStates, err := sqlite.GetProbeResults(cachePath)
+		// get probe states stored in db from scan, saved to a file, or listed in a hosts file
+		var (
+			probeStates []magellan.ScannedResult
+			err         error
+		)
+		if probeStatesPath != "" {
+			probeStates, err = magellan.LoadProbeStates(probeStatesPath)
+		} else if hostsFilePath != "" {
+			probeStates, err = magellan.LoadHostsFile(hostsFilePath)
+		} else {
+			probeStates, err = sqlite.GetProbeResults(cachePath)
+		}
 		if err != nil {
 			l.Log.Errorf("failed toget states: %v", err)
 		}
@@ -59,8 +161,188 @@ var collectCmd = &cobra.Command{
 			OutputPath:  outputPath,
 			ForceUpdate: forceUpdate,
 			AccessToken: accessToken,
+			BusyRetries: busyRetries,
+			SmdCsvPath:  smdCsvPath,
+
+			CollectPowerSubsystem: collectPower,
+			JUnitPath:             junitPath,
+			CollectOem:            collectOem,
+			CooldownPath:          cooldownPath,
+			Cooldown:              cooldown,
+			ForceRetry:            forceRetry,
+			CollectRedundancy:     collectRedundancy,
+			Envelope:              envelope,
+			EnvelopeKey:           envelopeKey,
+			CollectTelemetry:      collectTelemetry,
+			MaxMetricValues:       maxMetricValues,
+			CollectIpmiLan:        collectIpmiLan,
+			IpmiLanChannel:        ipmiLanChannel,
+			ResetBMC:              resetBMC,
+			Deterministic:         deterministic,
+			ResultBuffer:          resultBuffer,
+			CollectCertificates:   collectCertificates,
+			CollectStorage:        collectStorage,
+			CollectDrives:         collectDrives,
+			IpmiPort:              ipmiPort,
+			SmdEndpoint:           smdEndpoint,
+			DryRun:                dryRun,
+			CollectFirmware:       collectFirmware,
+			CollectPowerState:     collectPowerState,
+			Retries:               retries,
+			RetryDelay:            retryDelay,
+			OutputFormat:          outputFormat,
+			InventoryCsvPath:      inventoryCsvPath,
+			SecureTLS:             secureTLS,
+			CollectSEL:            collectSEL,
+			MaxLogEntries:         maxLogEntries,
+			CollectThermal:        collectThermal,
+			CollectPowerSensors:   collectPowerSensors,
+			CollectServiceRoot:    collectServiceRoot,
+			DisableExpand:         !expandQuery,
+			SummaryPath:           summaryPath,
+			Proxy:                 proxy,
+			RateLimit:             rateLimit,
+			MetricsAddr:           metricsAddr,
+			Sections:              sections,
+			IncludePassword:       !redactCredentials,
+			GlobalTimeout:         globalTimeout,
+			CollectBoot:           collectBoot,
+			Dedupe:                dedupe,
+			SkipUnchanged:         skipUnchanged,
+			StateCachePath:        stateCachePath,
+			TouchUnchanged:        touchUnchanged,
+			VendorQuirks:          vendorQuirks,
+			PreferredProviders:    preferredProviders,
+			ConnectTimeout:        connectTimeout,
+			QueryTimeout:          queryTimeout,
+			OutboxPath:            outboxPath,
+			CollectManagers:       collectManagers,
+			MaxClockSkew:          maxClockSkew,
+			CollectEthernet:       collectEthernet,
+			EthernetPaths:         ethernetPaths,
+			MACMapPath:            macMapPath,
+			SmdHeaders:            smdHeaders,
+			SmdTokenUrl:           smdTokenUrl,
+			SmdClientID:           smdClientID,
+			SmdClientSecret:       smdClientSecret,
+			Compact:               compact,
+			ResolveFQDN:           resolveFQDN,
+			Drivers:               drivers,
+		}
+
+		// load the static host to xname mapping if provided
+		if xnameMap != "" {
+			q.XnameMap, err = magellan.LoadXnameMap(xnameMap)
+			if err != nil {
+				l.Log.Errorf("failed to load xname map: %v", err)
+			}
+		}
+
+		// load per host credentials if provided
+		if credentialsPath != "" {
+			q.Credentials, err = magellan.LoadCredentialMap(credentialsPath)
+			if err != nil {
+				l.Log.Errorf("failed to load credentials: %v", err)
+				return
+			}
+		}
+
+		// read credentials from Vault if a path is given
+		if vaultPath != "" {
+			q.CredentialProvider, err = magellan.NewVaultCredentialProvider(vaultAddr, vaultMount, vaultPath)
+			if err != nil {
+				l.Log.Errorf("failed to set up Vault: %v", err)
+				return
+			}
+		}
+
+		// keep a history of what was collected from each host
+		if recordsDbPath != "" {
+			q.Sinks = append(q.Sinks, &sqlite.Sink{Path: recordsDbPath})
+		}
+
+		// publish what was collected from each host to Kafka
+		if len(kafkaBrokers) > 0 {
+			producer, err := magellan.NewKafkaProducer(magellan.KafkaConfig{
+				Brokers:       kafkaBrokers,
+				SASLMechanism: kafkaSASLMechanism,
+				Username:      kafkaUser,
+				Password:      kafkaPass,
+				TLS:           kafkaTLS,
+				CaCertPath:    q.CaCertPath,
+			})
+			if err != nil {
+				l.Log.Errorf("failed to set up Kafka: %v", err)
+				return
+			}
+			defer producer.Close()
+			q.Sinks = append(q.Sinks, &magellan.KafkaSink{Producer: producer, Topic: kafkaTopic})
+		}
+
+		// refuse to write unencrypted output if encryption was requested
+		if encrypt {
+			q.EncryptionKey, err = magellan.LoadEncryptionKey(encryptionKeyPath)
+			if err != nil {
+				l.Log.Errorf("failed to load encryption key: %v", err)
+				return
+			}
+		}
+
+		// parse the permissions of the output files and directory
+		mode, err := strconv.ParseUint(outputFileMode, 8, 32)
+		if err != nil {
+			l.Log.Errorf("invalid output file mode '%s': %v", outputFileMode, err)
+			return
+		}
+		q.FileMode = os.FileMode(mode)
+		mode, err = strconv.ParseUint(outputDirMode, 8, 32)
+		if err != nil {
+			l.Log.Errorf("invalid output directory mode '%s': %v", outputDirMode, err)
+			return
+		}
+		q.DirMode = os.FileMode(mode)
+
+		// pick how BMCs are named
+		q.XnameGenerator, err = magellan.NewXnameGenerator(xnameGenerator, q.XnameMap, l)
+		if err != nil {
+			l.Log.Errorf("failed to make xname generator: %v", err)
+			return
+		}
+
+		// load the golden BIOS profile to check for drift if provided
+		if biosProfile != "" {
+			q.BiosProfile, err = magellan.LoadBiosProfile(biosProfile)
+			if err != nil {
+				l.Log.Errorf("failed to load BIOS profile: %v", err)
+			}
+		}
+
+		// stop collecting cleanly on ctrl+c
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+		defer stop()
+
+		// collect from all targets defined in the config file instead
+		var targets []magellan.ScanTarget
+		err = viper.UnmarshalKey("targets", &targets)
+		if err != nil {
+			l.Log.Errorf("failed to read targets from config: %v", err)
+		}
+		if len(targets) > 0 {
+			err = magellan.CollectTargets(ctx, targets, l, q)
+			if err != nil {
+				l.Log.Errorf("failed to collect targets: %v", err)
+			}
+			return
+		}
+
+		if syncPower {
+			err = magellan.SyncPowerStates(ctx, &probeStates, l, q)
+			if err != nil {
+				l.Log.Errorf("failed to sync power states: %v", err)
+			}
+			return
 		}
-		err = magellan.CollectAll(&probeStates, l, q)
+		_, err = magellan.CollectAll(ctx, &probeStates, l, q)
 		if err != nil {
 			l.Log.Errorf("failed to collect data: %v", err)
 		}
@@ -83,6 +365,93 @@ func init() {
 	collectCmd.PersistentFlags().StringVarP(&outputPath, "output", "o", fmt.Sprintf("/tmp/%smagellan/data/", currentUser.Username+"/"), "set the path to store collection data")
 	collectCmd.PersistentFlags().BoolVar(&forceUpdate, "force-update", false, "set flag to force update data sent to SMD")
 	collectCmd.PersistentFlags().StringVar(&cacertPath, "ca-cert", "", "path to CA cert. (defaults to system CAs)")
+	collectCmd.PersistentFlags().IntVar(&busyRetries, "busy-retries", 3, "set the number of retries when a BMC responds with 429/503")
+	collectCmd.PersistentFlags().StringVar(&smdCsvPath, "smd-csv", "", "set the path to write a CSV for SMD bulk import")
+	collectCmd.PersistentFlags().BoolVar(&syncPower, "sync-power", false, "only update power states of nodes already known to SMD")
+	collectCmd.PersistentFlags().StringVar(&xnameMap, "xname-map", "", "set the path to a host to xname mapping file (JSON or CSV)")
+	collectCmd.PersistentFlags().BoolVar(&collectPower, "collect-power", false, "set flag to collect power supplies from each chassis")
+	collectCmd.PersistentFlags().StringVar(&junitPath, "junit", "", "set the path to write a JUnit XML report of the results")
+	collectCmd.PersistentFlags().BoolVar(&collectOem, "collect-oem", false, "set flag to collect vendor OEM sections from systems, chassis, and managers")
+	collectCmd.PersistentFlags().StringVar(&cooldownPath, "cooldown-path", "", "set the path to store hosts that failed to skip on later runs")
+	collectCmd.PersistentFlags().DurationVar(&cooldown, "cooldown", time.Hour, "set how long to skip hosts that previously failed")
+	collectCmd.PersistentFlags().BoolVar(&forceRetry, "force-retry", false, "set flag to retry hosts that are in cooldown")
+	collectCmd.PersistentFlags().BoolVar(&collectRedundancy, "collect-redundancy", false, "set flag to collect fan and power supply redundancy status")
+	collectCmd.PersistentFlags().BoolVar(&envelope, "envelope", false, "set flag to wrap output with collection metadata")
+	collectCmd.PersistentFlags().StringVar(&envelopeKey, "envelope-key", "data", "set the key used for the data inside the envelope")
+	collectCmd.PersistentFlags().BoolVar(&collectTelemetry, "collect-telemetry", false, "set flag to collect metric reports from the telemetry service")
+	collectCmd.PersistentFlags().IntVar(&maxMetricValues, "max-metric-values", 100, "set the max number of values kept per metric report (0 keeps all)")
+	collectCmd.PersistentFlags().BoolVar(&collectIpmiLan, "collect-ipmi-lan", false, "set flag to collect the IPMI LAN channel config")
+	collectCmd.PersistentFlags().IntVar(&ipmiLanChannel, "ipmi-lan-channel", 1, "set the IPMI LAN channel to read")
+	collectCmd.PersistentFlags().StringVar(&resetBMC, "reset-bmc", "", "reset BMCs that cannot be connected to ('cold' or 'warm')")
+	collectCmd.PersistentFlags().BoolVar(&deterministic, "deterministic", false, "set flag to collect one host at a time in sorted order for reproducible logs")
+	collectCmd.PersistentFlags().StringVar(&biosProfile, "bios-profile", "", "set the path to a golden BIOS profile (JSON) to report attribute drift against")
+	collectCmd.PersistentFlags().BoolVar(&encrypt, "encrypt", false, "set flag to encrypt output files (key from MAGELLAN_ENCRYPTION_KEY or --encryption-key)")
+	collectCmd.PersistentFlags().StringVar(&encryptionKeyPath, "encryption-key", "", "set the path to the key used to encrypt output files")
+	collectCmd.PersistentFlags().StringVar(&xnameGenerator, "xname-generator", "", "set how xnames are generated ('sequential', 'ip', or 'map')")
+	collectCmd.PersistentFlags().IntVar(&resultBuffer, "result-buffer", 0, "set the number of collected hosts buffered before workers wait on output (defaults to concurrency)")
+	collectCmd.PersistentFlags().BoolVar(&collectCertificates, "collect-certificates", false, "set flag to collect certificates installed on the BMC")
+	collectCmd.PersistentFlags().BoolVar(&collectStorage, "collect-storage", false, "set flag to collect storage systems and services")
+	collectCmd.PersistentFlags().BoolVar(&collectDrives, "collect-drives", false, "set flag to collect the drives of each system")
+	collectCmd.PersistentFlags().IntVar(&ipmiPort, "ipmi-port", magellan.IPMI_PORT, "set the port used for IPMI")
+	collectCmd.PersistentFlags().StringVar(&smdEndpoint, "smd-url", "", "set the base URL of the SMD API (overrides --host and --port)")
+	collectCmd.PersistentFlags().BoolVar(&dryRun, "dry-run", false, "set flag to write output files without adding endpoints to SMD")
+	collectCmd.PersistentFlags().BoolVar(&collectFirmware, "collect-firmware", false, "set flag to collect the firmware inventory")
+	collectCmd.PersistentFlags().BoolVar(&collectPowerState, "collect-power-state", false, "set flag to collect the power state of the system")
+	collectCmd.PersistentFlags().IntVar(&retries, "retries", 2, "set the number of retries when connecting to a BMC fails")
+	collectCmd.PersistentFlags().DurationVar(&retryDelay, "retry-delay", time.Second, "set the delay before retrying to connect (doubles for each retry)")
+	collectCmd.PersistentFlags().StringVar(&outputFormat, "output-format", magellan.OUTPUT_FILES, "set the output format ('files' or 'ndjson' to append to a single file at --output)")
+	collectCmd.PersistentFlags().StringVar(&inventoryCsvPath, "inventory-csv", "", "set the path to write a summary inventory CSV")
+	collectCmd.PersistentFlags().StringVar(&credentialsPath, "credentials", "", "set the path to a per host/subnet credentials file (YAML or JSON)")
+	collectCmd.PersistentFlags().StringVar(&vaultAddr, "vault-addr", "", "set the Vault address to read BMC credentials from (uses VAULT_ADDR when empty)")
+	collectCmd.PersistentFlags().StringVar(&vaultMount, "vault-mount", "secret", "set the Vault KV v2 mount with BMC credentials")
+	collectCmd.PersistentFlags().StringVar(&vaultPath, "vault-path", "", "set the path under the Vault mount to read BMC credentials from (enables Vault)")
+	collectCmd.PersistentFlags().BoolVar(&secureTLS, "secure-tls", false, "set flag to verify BMC certificates against --ca-cert (or the system roots)")
+	collectCmd.PersistentFlags().BoolVar(&collectSEL, "collect-sel", false, "set flag to collect the system event log (SEL)")
+	collectCmd.PersistentFlags().IntVar(&maxLogEntries, "max-log-entries", 1000, "set the max number of entries kept per log (0 keeps all)")
+	collectCmd.PersistentFlags().BoolVar(&collectThermal, "collect-thermal", false, "set flag to collect temperature and fan readings")
+	collectCmd.PersistentFlags().BoolVar(&collectPowerSensors, "collect-power-sensors", false, "set flag to collect power supply and voltage readings")
+	collectCmd.PersistentFlags().BoolVar(&collectServiceRoot, "collect-service-root", false, "set flag to collect the Redfish service root")
+	collectCmd.PersistentFlags().BoolVar(&expandQuery, "expand-query", true, "set flag to use $expand when supported by the BMC")
+	collectCmd.PersistentFlags().StringVar(&probeStatesPath, "probe-states", "", "set the path to probe states saved by scan to use instead of the cache")
+	collectCmd.PersistentFlags().StringVar(&summaryPath, "summary", "", "set the path to write a JSON summary of the collection to")
+	collectCmd.PersistentFlags().StringVar(&proxy, "proxy", "", "set an HTTP(S) or SOCKS5 proxy URL for Redfish requests (i.e. socks5://bastion:1080)")
+	collectCmd.PersistentFlags().Float64Var(&rateLimit, "rate-limit", 0, "set the max number of connections made to BMCs per second (0 is unlimited)")
+	collectCmd.PersistentFlags().StringVar(&metricsAddr, "metrics-addr", "", "set an address to serve Prometheus metrics on while collecting (i.e. :9090)")
+	collectCmd.PersistentFlags().StringSliceVar(&sections, "sections", []string{}, "set the only sections to collect (i.e. chassis,systems,sel)")
+	collectCmd.PersistentFlags().StringVar(&outputFileMode, "output-file-mode", "0600", "set the permissions of output files (octal)")
+	collectCmd.PersistentFlags().StringVar(&outputDirMode, "output-dir-mode", "0700", "set the permissions of the output directory (octal)")
+	collectCmd.PersistentFlags().BoolVar(&redactCredentials, "redact-credentials", true, "set flag to leave BMC passwords out of output files (SMD always gets them)")
+	collectCmd.PersistentFlags().DurationVar(&globalTimeout, "global-timeout", 0, "set the max time for the whole collection (0 is unlimited)")
+	collectCmd.PersistentFlags().StringVar(&hostsFilePath, "hosts-file", "", "set the path to a file with a host[:port] per line to collect from instead of the cache")
+	collectCmd.PersistentFlags().BoolVar(&collectBoot, "collect-boot", false, "set flag to collect the boot order and boot options")
+	collectCmd.PersistentFlags().BoolVar(&dedupe, "dedupe", false, "set flag to collect from BMCs found at multiple hosts only once")
+	collectCmd.PersistentFlags().BoolVar(&skipUnchanged, "skip-unchanged", false, "set flag to skip adding hosts to SMD when their data has not changed since the last run")
+	collectCmd.PersistentFlags().StringVar(&stateCachePath, "state-cache", "", "set the path to store the hash of the data collected from each host")
+	collectCmd.PersistentFlags().BoolVar(&touchUnchanged, "touch-unchanged", false, "set flag to still rewrite the output files of hosts that have not changed")
+	collectCmd.PersistentFlags().BoolVar(&vendorQuirks, "vendor-quirks", false, "set flag to detect the vendor of each BMC and adjust how it is queried")
+	collectCmd.PersistentFlags().StringToStringVar(&preferredProviders, "preferred-providers", nil, "set the provider preferred for each section (i.e. Inventory=gofish,PowerState=ipmitool)")
+	collectCmd.PersistentFlags().DurationVar(&connectTimeout, "connect-timeout", 0, "set the max time to connect to each BMC (uses --timeout when not set)")
+	collectCmd.PersistentFlags().DurationVar(&queryTimeout, "query-timeout", 0, "set the max time to wait on queries to each BMC (uses --timeout when not set)")
+	collectCmd.PersistentFlags().StringVar(&outboxPath, "outbox", "", "set the directory to queue data that could not be added to SMD to send on the next run")
+	collectCmd.PersistentFlags().StringVar(&recordsDbPath, "records-db", "", "set the path of a sqlite database to also store the data collected from each host")
+	collectCmd.PersistentFlags().BoolVar(&collectManagers, "collect-managers", false, "set flag to collect the details and network protocols of the BMC managers")
+	collectCmd.PersistentFlags().DurationVar(&maxClockSkew, "max-clock-skew", 0, "set the max difference between the BMC and local clocks before flagging the host in the summary (requires --collect-managers)")
+	collectCmd.PersistentFlags().BoolVar(&collectEthernet, "collect-ethernet-interfaces", false, "set flag to collect the ethernet interfaces of the managers and systems")
+	collectCmd.PersistentFlags().StringSliceVar(&ethernetPaths, "ethernet-interface-paths", nil, "set the collections of ethernet interfaces to read instead of the ones of each manager and system")
+	collectCmd.PersistentFlags().StringVar(&macMapPath, "mac-map", "", "set the path to write the MAC address of each BMC to (as .json or .csv)")
+	collectCmd.PersistentFlags().StringToStringVar(&smdHeaders, "smd-header", nil, "set extra headers sent to SMD (i.e. X-Tenant=abc)")
+	collectCmd.PersistentFlags().StringVar(&smdTokenUrl, "smd-token-url", "", "set the OAuth2 token URL used to get an access token for SMD with client credentials")
+	collectCmd.PersistentFlags().StringVar(&smdClientID, "smd-client-id", "", "set the OAuth2 client ID used to get an access token for SMD")
+	collectCmd.PersistentFlags().StringVar(&smdClientSecret, "smd-client-secret", "", "set the OAuth2 client secret used to get an access token for SMD")
+	collectCmd.PersistentFlags().BoolVar(&compact, "compact", false, "set flag to write the output without indentation")
+	collectCmd.PersistentFlags().BoolVar(&resolveFQDN, "resolve-fqdn", false, "set flag to use the name found with a reverse DNS lookup of each IP as the FQDN")
+	collectCmd.PersistentFlags().StringSliceVar(&drivers, "driver", []string{"redfish"}, "set the bmclib driver protocols to use (i.e. redfish,ipmi)")
+	collectCmd.PersistentFlags().StringSliceVar(&kafkaBrokers, "kafka-brokers", nil, "set the Kafka brokers to also publish the data collected from each host to")
+	collectCmd.PersistentFlags().StringVar(&kafkaTopic, "kafka-topic", "magellan", "set the Kafka topic to publish to")
+	collectCmd.PersistentFlags().StringVar(&kafkaSASLMechanism, "kafka-sasl-mechanism", "", "set the SASL mechanism used to log in to Kafka (plain, scram-sha-256, or scram-sha-512)")
+	collectCmd.PersistentFlags().StringVar(&kafkaUser, "kafka-user", "", "set the user to log in to Kafka with")
+	collectCmd.PersistentFlags().StringVar(&kafkaPass, "kafka-pass", "", "set the password to log in to Kafka with")
+	collectCmd.PersistentFlags().BoolVar(&kafkaTLS, "kafka-tls", false, "set flag to connect to Kafka with TLS (verified with --ca-cert if set)")
 	collectCmd.MarkFlagsRequiredTogether("user", "pass")
 
 	viper.BindPFlag("collect.driver", collectCmd.Flags().Lookup("driver"))
@@ -93,7 +462,93 @@ func init() {
 	viper.BindPFlag("collect.protocol", collectCmd.Flags().Lookup("protocol"))
 	viper.BindPFlag("collect.output", collectCmd.Flags().Lookup("output"))
 	viper.BindPFlag("collect.force-update", collectCmd.Flags().Lookup("force-update"))
-	viper.BindPFlag("collect.ca-cert", collectCmd.Flags().Lookup("secure-tls"))
+	viper.BindPFlag("collect.busy-retries", collectCmd.Flags().Lookup("busy-retries"))
+	viper.BindPFlag("collect.smd-csv", collectCmd.Flags().Lookup("smd-csv"))
+	viper.BindPFlag("collect.sync-power", collectCmd.Flags().Lookup("sync-power"))
+	viper.BindPFlag("collect.xname-map", collectCmd.Flags().Lookup("xname-map"))
+	viper.BindPFlag("collect.collect-power", collectCmd.Flags().Lookup("collect-power"))
+	viper.BindPFlag("collect.junit", collectCmd.Flags().Lookup("junit"))
+	viper.BindPFlag("collect.collect-oem", collectCmd.Flags().Lookup("collect-oem"))
+	viper.BindPFlag("collect.cooldown-path", collectCmd.Flags().Lookup("cooldown-path"))
+	viper.BindPFlag("collect.cooldown", collectCmd.Flags().Lookup("cooldown"))
+	viper.BindPFlag("collect.force-retry", collectCmd.Flags().Lookup("force-retry"))
+	viper.BindPFlag("collect.collect-redundancy", collectCmd.Flags().Lookup("collect-redundancy"))
+	viper.BindPFlag("collect.envelope", collectCmd.Flags().Lookup("envelope"))
+	viper.BindPFlag("collect.envelope-key", collectCmd.Flags().Lookup("envelope-key"))
+	viper.BindPFlag("collect.collect-telemetry", collectCmd.Flags().Lookup("collect-telemetry"))
+	viper.BindPFlag("collect.max-metric-values", collectCmd.Flags().Lookup("max-metric-values"))
+	viper.BindPFlag("collect.collect-ipmi-lan", collectCmd.Flags().Lookup("collect-ipmi-lan"))
+	viper.BindPFlag("collect.ipmi-lan-channel", collectCmd.Flags().Lookup("ipmi-lan-channel"))
+	viper.BindPFlag("collect.reset-bmc", collectCmd.Flags().Lookup("reset-bmc"))
+	viper.BindPFlag("collect.deterministic", collectCmd.Flags().Lookup("deterministic"))
+	viper.BindPFlag("collect.bios-profile", collectCmd.Flags().Lookup("bios-profile"))
+	viper.BindPFlag("collect.encrypt", collectCmd.Flags().Lookup("encrypt"))
+	viper.BindPFlag("collect.encryption-key", collectCmd.Flags().Lookup("encryption-key"))
+	viper.BindPFlag("collect.xname-generator", collectCmd.Flags().Lookup("xname-generator"))
+	viper.BindPFlag("collect.result-buffer", collectCmd.Flags().Lookup("result-buffer"))
+	viper.BindPFlag("collect.collect-certificates", collectCmd.Flags().Lookup("collect-certificates"))
+	viper.BindPFlag("collect.collect-storage", collectCmd.Flags().Lookup("collect-storage"))
+	viper.BindPFlag("collect.collect-drives", collectCmd.Flags().Lookup("collect-drives"))
+	viper.BindPFlag("collect.ipmi-port", collectCmd.Flags().Lookup("ipmi-port"))
+	viper.BindPFlag("collect.smd-url", collectCmd.Flags().Lookup("smd-url"))
+	viper.BindPFlag("collect.dry-run", collectCmd.Flags().Lookup("dry-run"))
+	viper.BindPFlag("collect.collect-firmware", collectCmd.Flags().Lookup("collect-firmware"))
+	viper.BindPFlag("collect.collect-power-state", collectCmd.Flags().Lookup("collect-power-state"))
+	viper.BindPFlag("collect.retries", collectCmd.Flags().Lookup("retries"))
+	viper.BindPFlag("collect.retry-delay", collectCmd.Flags().Lookup("retry-delay"))
+	viper.BindPFlag("collect.output-format", collectCmd.Flags().Lookup("output-format"))
+	viper.BindPFlag("collect.inventory-csv", collectCmd.Flags().Lookup("inventory-csv"))
+	viper.BindPFlag("collect.credentials", collectCmd.Flags().Lookup("credentials"))
+	viper.BindPFlag("collect.vault-addr", collectCmd.Flags().Lookup("vault-addr"))
+	viper.BindPFlag("collect.vault-mount", collectCmd.Flags().Lookup("vault-mount"))
+	viper.BindPFlag("collect.vault-path", collectCmd.Flags().Lookup("vault-path"))
+	viper.BindPFlag("collect.secure-tls", collectCmd.Flags().Lookup("secure-tls"))
+	viper.BindPFlag("collect.collect-sel", collectCmd.Flags().Lookup("collect-sel"))
+	viper.BindPFlag("collect.max-log-entries", collectCmd.Flags().Lookup("max-log-entries"))
+	viper.BindPFlag("collect.collect-thermal", collectCmd.Flags().Lookup("collect-thermal"))
+	viper.BindPFlag("collect.collect-power-sensors", collectCmd.Flags().Lookup("collect-power-sensors"))
+	viper.BindPFlag("collect.collect-service-root", collectCmd.Flags().Lookup("collect-service-root"))
+	viper.BindPFlag("collect.expand-query", collectCmd.Flags().Lookup("expand-query"))
+	viper.BindPFlag("collect.probe-states", collectCmd.Flags().Lookup("probe-states"))
+	viper.BindPFlag("collect.summary", collectCmd.Flags().Lookup("summary"))
+	viper.BindPFlag("collect.proxy", collectCmd.Flags().Lookup("proxy"))
+	viper.BindPFlag("collect.rate-limit", collectCmd.Flags().Lookup("rate-limit"))
+	viper.BindPFlag("collect.metrics-addr", collectCmd.Flags().Lookup("metrics-addr"))
+	viper.BindPFlag("collect.sections", collectCmd.Flags().Lookup("sections"))
+	viper.BindPFlag("collect.output-file-mode", collectCmd.Flags().Lookup("output-file-mode"))
+	viper.BindPFlag("collect.output-dir-mode", collectCmd.Flags().Lookup("output-dir-mode"))
+	viper.BindPFlag("collect.redact-credentials", collectCmd.Flags().Lookup("redact-credentials"))
+	viper.BindPFlag("collect.global-timeout", collectCmd.Flags().Lookup("global-timeout"))
+	viper.BindPFlag("collect.hosts-file", collectCmd.Flags().Lookup("hosts-file"))
+	viper.BindPFlag("collect.collect-boot", collectCmd.Flags().Lookup("collect-boot"))
+	viper.BindPFlag("collect.dedupe", collectCmd.Flags().Lookup("dedupe"))
+	viper.BindPFlag("collect.skip-unchanged", collectCmd.Flags().Lookup("skip-unchanged"))
+	viper.BindPFlag("collect.state-cache", collectCmd.Flags().Lookup("state-cache"))
+	viper.BindPFlag("collect.touch-unchanged", collectCmd.Flags().Lookup("touch-unchanged"))
+	viper.BindPFlag("collect.vendor-quirks", collectCmd.Flags().Lookup("vendor-quirks"))
+	viper.BindPFlag("collect.preferred-providers", collectCmd.Flags().Lookup("preferred-providers"))
+	viper.BindPFlag("collect.connect-timeout", collectCmd.Flags().Lookup("connect-timeout"))
+	viper.BindPFlag("collect.query-timeout", collectCmd.Flags().Lookup("query-timeout"))
+	viper.BindPFlag("collect.outbox", collectCmd.Flags().Lookup("outbox"))
+	viper.BindPFlag("collect.records-db", collectCmd.Flags().Lookup("records-db"))
+	viper.BindPFlag("collect.collect-managers", collectCmd.Flags().Lookup("collect-managers"))
+	viper.BindPFlag("collect.max-clock-skew", collectCmd.Flags().Lookup("max-clock-skew"))
+	viper.BindPFlag("collect.collect-ethernet-interfaces", collectCmd.Flags().Lookup("collect-ethernet-interfaces"))
+	viper.BindPFlag("collect.ethernet-interface-paths", collectCmd.Flags().Lookup("ethernet-interface-paths"))
+	viper.BindPFlag("collect.mac-map", collectCmd.Flags().Lookup("mac-map"))
+	viper.BindPFlag("collect.smd-header", collectCmd.Flags().Lookup("smd-header"))
+	viper.BindPFlag("collect.smd-token-url", collectCmd.Flags().Lookup("smd-token-url"))
+	viper.BindPFlag("collect.smd-client-id", collectCmd.Flags().Lookup("smd-client-id"))
+	viper.BindPFlag("collect.smd-client-secret", collectCmd.Flags().Lookup("smd-client-secret"))
+	viper.BindPFlag("collect.compact", collectCmd.Flags().Lookup("compact"))
+	viper.BindPFlag("collect.resolve-fqdn", collectCmd.Flags().Lookup("resolve-fqdn"))
+	viper.BindPFlag("collect.kafka-brokers", collectCmd.Flags().Lookup("kafka-brokers"))
+	viper.BindPFlag("collect.kafka-topic", collectCmd.Flags().Lookup("kafka-topic"))
+	viper.BindPFlag("collect.kafka-sasl-mechanism", collectCmd.Flags().Lookup("kafka-sasl-mechanism"))
+	viper.BindPFlag("collect.kafka-user", collectCmd.Flags().Lookup("kafka-user"))
+	viper.BindPFlag("collect.kafka-pass", collectCmd.Flags().Lookup("kafka-pass"))
+	viper.BindPFlag("collect.kafka-tls", collectCmd.Flags().Lookup("kafka-tls"))
+	viper.BindPFlag("collect.ca-cert", collectCmd.Flags().Lookup("ca-cert"))
 	viper.BindPFlags(collectCmd.Flags())
 
 	rootCmd.AddCommand(collectCmd)
@@ -0,0 +1,35 @@
+package cmd
+
+import (
+	"fmt"
+
+	magellan "github.com/OpenCHAMI/magellan/internal"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+var decryptCmd = &cobra.Command{
+	Use:   "decrypt [files...]",
+	Short: "Decrypt output files written with 'collect --encrypt'",
+	Args:  cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		key, err := magellan.LoadEncryptionKey(encryptionKeyPath)
+		if err != nil {
+			logrus.Errorf("failed to load encryption key: %v\n", err)
+			return
+		}
+		for _, path := range args {
+			b, err := magellan.ReadOutputFile(path, key)
+			if err != nil {
+				logrus.Errorf("failed to decrypt file: %v\n", err)
+				continue
+			}
+			fmt.Printf("%s\n", string(b))
+		}
+	},
+}
+
+func init() {
+	decryptCmd.Flags().StringVar(&encryptionKeyPath, "encryption-key", "", "set the path to the key used to encrypt output files")
+	rootCmd.AddCommand(decryptCmd)
+}
@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"strings"
+	"time"
 
 	"github.com/OpenCHAMI/magellan/internal/db/sqlite"
 
@@ -11,10 +12,29 @@ import (
 	"github.com/spf13/cobra"
 )
 
+var listCollected string
+
 var listCmd = &cobra.Command{
 	Use:   "list",
 	Short: "List information from scan",
 	Run: func(cmd *cobra.Command, args []string) {
+		// show the latest data collected from each host instead
+		if listCollected != "" {
+			records, err := sqlite.GetLatestCollectedRecords(listCollected)
+			if err != nil {
+				logrus.Errorf("failed to get collected records: %v\n", err)
+			}
+			if strings.ToLower(format) == "json" {
+				b, _ := json.Marshal(records)
+				fmt.Printf("%s\n", string(b))
+			} else {
+				for _, r := range records {
+					fmt.Printf("%s:%d %s success=%v (%s)\n", r.Host, r.Port, r.Xname, r.Success, r.CollectedAt.Format(time.RFC3339))
+				}
+			}
+			return
+		}
+
 		probeResults, err := sqlite.GetProbeResults(cachePath)
 		if err != nil {
 			logrus.Errorf("failed toget probe results: %v\n", err)
@@ -33,5 +53,6 @@ var listCmd = &cobra.Command{
 
 func init() {
 	listCmd.Flags().StringVar(&format, "format", "", "set the output format")
+	listCmd.Flags().StringVar(&listCollected, "collected", "", "list the latest data collected from each host in this records database")
 	rootCmd.AddCommand(listCmd)
 }
@@ -0,0 +1,64 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+
+	magellan "github.com/OpenCHAMI/magellan/internal"
+	"github.com/OpenCHAMI/magellan/internal/log"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var (
+	powerAction  string
+	powerConfirm bool
+)
+
+var powerCmd = &cobra.Command{
+	Use:   "power",
+	Short: "Change the power state of a BMC node",
+	Run: func(cmd *cobra.Command, args []string) {
+		l := log.NewLogger(logrus.New(), logrus.DebugLevel)
+		q := &magellan.QueryParams{
+			Protocol: protocol,
+			Host:     host,
+			Port:     port,
+			User:     username,
+			Pass:     password,
+			Timeout:  timeout,
+		}
+
+		// check if required params are set
+		if host == "" || username == "" || password == "" || powerAction == "" {
+			l.Log.Fatal("requires bmc-host, user, pass, and action to be set")
+		}
+
+		// changing the power state is disruptive so make sure it was meant
+		if !powerConfirm {
+			l.Log.Fatalf("refusing to send '%s' to %s without --confirm", powerAction, host)
+		}
+
+		state, err := magellan.SetHostPowerState(context.Background(), l, q, powerAction)
+		if err != nil {
+			l.Log.Errorf("failed to set power state: %v", err)
+			return
+		}
+		fmt.Printf("%s\n", state)
+	},
+}
+
+func init() {
+	powerCmd.Flags().StringVar(&host, "bmc-host", "", "set the BMC host")
+	powerCmd.Flags().IntVar(&port, "bmc-port", 443, "set the BMC port")
+	powerCmd.Flags().StringVar(&username, "user", "", "set the BMC user")
+	powerCmd.Flags().StringVar(&password, "pass", "", "set the BMC password")
+	powerCmd.Flags().StringVar(&protocol, "protocol", "https", "set the Redfish protocol")
+	powerCmd.Flags().StringVar(&powerAction, "action", "", "set the power action ('On', 'ForceOff', 'GracefulShutdown', or 'ForceRestart')")
+	powerCmd.Flags().BoolVar(&powerConfirm, "confirm", false, "set flag to confirm changing the power state")
+
+	viper.BindPFlag("power.action", powerCmd.Flags().Lookup("action"))
+
+	rootCmd.AddCommand(powerCmd)
+}
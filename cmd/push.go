@@ -0,0 +1,70 @@
+package cmd
+
+import (
+	"fmt"
+
+	magellan "github.com/OpenCHAMI/magellan/internal"
+	"github.com/OpenCHAMI/magellan/internal/log"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+var pushCmd = &cobra.Command{
+	Use:   "push [dir]",
+	Short: "Add previously collected output files to SMD",
+	Long:  "Add the output files written by 'collect' in a directory to SMD without querying the BMCs again (i.e. after SMD was wiped).",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		l := log.NewLogger(logrus.New(), logrus.InfoLevel)
+
+		// try to load access token either from env var, file, or config if var not set
+		if accessToken == "" {
+			var err error
+			accessToken, err = LoadAccessToken()
+			if err != nil {
+				l.Log.Errorf("failed to load access token: %v", err)
+			}
+		}
+
+		q := &magellan.QueryParams{
+			AccessToken: accessToken,
+			CaCertPath:  cacertPath,
+			ForceUpdate: forceUpdate,
+			SmdEndpoint: smdEndpoint,
+			EnvelopeKey: envelopeKey,
+		}
+		if encryptionKeyPath != "" {
+			var err error
+			q.EncryptionKey, err = magellan.LoadEncryptionKey(encryptionKeyPath)
+			if err != nil {
+				l.Log.Errorf("failed to load encryption key: %v", err)
+				return
+			}
+		}
+
+		results, err := magellan.PushOutputFiles(args[0], l, q)
+		if err != nil {
+			l.Log.Errorf("failed to push output files: %v", err)
+			return
+		}
+		var failed int
+		for _, result := range results {
+			if result.Success {
+				fmt.Printf("ok     %s (%s)\n", result.Path, result.ID)
+			} else {
+				failed += 1
+				fmt.Printf("failed %s (%s): %s\n", result.Path, result.ID, result.Error)
+			}
+		}
+		l.Log.Infof("pushed %d of %d file(s) to SMD", len(results)-failed, len(results))
+	},
+}
+
+func init() {
+	pushCmd.Flags().StringVar(&smdEndpoint, "smd-url", "", "set the base URL of the SMD API")
+	pushCmd.Flags().StringVar(&cacertPath, "ca-cert", "", "path to CA cert. (defaults to system CAs)")
+	pushCmd.Flags().BoolVar(&forceUpdate, "force-update", false, "set flag to force update data sent to SMD")
+	pushCmd.Flags().StringVar(&encryptionKeyPath, "encryption-key", "", "set the path to the key used to decrypt encrypted output files")
+	pushCmd.Flags().StringVar(&envelopeKey, "envelope-key", "data", "set the key the data is under in output files with an envelope")
+	rootCmd.AddCommand(pushCmd)
+}
@@ -7,6 +7,7 @@ import (
 	"os"
 	"path"
 	"strings"
+	"time"
 
 	magellan "github.com/OpenCHAMI/magellan/internal"
 	"github.com/OpenCHAMI/magellan/internal/db/sqlite"
@@ -22,6 +23,9 @@ var (
 	subnets        []string
 	subnetMasks    []net.IP
 	disableProbing bool
+	hostRanges     []string
+	probeProtocols bool
+	saveStatesPath string
 )
 
 var scanCmd = &cobra.Command{
@@ -56,6 +60,14 @@ var scanCmd = &cobra.Command{
 			hostsToScan = append(hostsToScan, magellan.GenerateHosts(subnet, &subnetMasks[i])...)
 		}
 
+		// add hosts from `--range` (CIDR blocks or IP ranges)
+		rangeHosts, err := magellan.ExpandHostRanges(hostRanges)
+		if err != nil {
+			fmt.Printf("failed to expand ranges: %v\n", err)
+			os.Exit(1)
+		}
+		hostsToScan = append(hostsToScan, rangeHosts...)
+
 		// add ports to use for scanning
 		if len(ports) > 0 {
 			portsToScan = ports
@@ -68,7 +80,12 @@ var scanCmd = &cobra.Command{
 		if concurrency <= 0 {
 			concurrency = mathutil.Clamp(len(hostsToScan), 1, 255)
 		}
-		probeStates := magellan.ScanForAssets(hostsToScan, portsToScan, concurrency, timeout, disableProbing, verbose)
+		var probeStates []magellan.ScannedResult
+		if probeProtocols {
+			probeStates = magellan.ProbeHosts(hostsToScan, concurrency, time.Second*time.Duration(timeout))
+		} else {
+			probeStates = magellan.ScanForAssets(hostsToScan, portsToScan, concurrency, timeout, disableProbing, verbose)
+		}
 		if verbose {
 			format = strings.ToLower(format)
 			if format == "json" {
@@ -82,12 +99,20 @@ var scanCmd = &cobra.Command{
 		}
 
 		// make the dbpath dir if needed
-		err := os.MkdirAll(path.Dir(cachePath), 0766)
+		err = os.MkdirAll(path.Dir(cachePath), 0766)
 		if err != nil {
 			fmt.Printf("failed tomake database directory: %v", err)
 		}
 
 		sqlite.InsertProbeResults(cachePath, &probeStates)
+
+		// save a copy that can be collected from later with `--probe-states`
+		if saveStatesPath != "" {
+			err = magellan.SaveProbeStates(saveStatesPath, probeStates)
+			if err != nil {
+				fmt.Printf("failed to save probe states: %v\n", err)
+			}
+		}
 	},
 }
 
@@ -98,12 +123,18 @@ func init() {
 	scanCmd.Flags().StringSliceVar(&subnets, "subnet", []string{}, "set additional subnets")
 	scanCmd.Flags().IPSliceVar(&subnetMasks, "subnet-mask", []net.IP{}, "set the subnet masks to use for network (must match number of subnets)")
 	scanCmd.Flags().BoolVar(&disableProbing, "disable-probing", false, "disable probing scanned results for BMC nodes")
+	scanCmd.Flags().StringSliceVar(&hostRanges, "range", []string{}, "set CIDR blocks or IP ranges to scan (i.e. 10.0.0.0/24 or 10.0.0.1-10.0.0.20)")
+	scanCmd.Flags().StringVar(&saveStatesPath, "save-probe-states", "", "set a path to also save the probe states to as JSON")
+	scanCmd.Flags().BoolVar(&probeProtocols, "probe-protocols", false, "probe for IPMI, SSH, and Redfish instead of scanning ports")
 
 	viper.BindPFlag("scan.hosts", scanCmd.Flags().Lookup("host"))
 	viper.BindPFlag("scan.ports", scanCmd.Flags().Lookup("port"))
 	viper.BindPFlag("scan.subnets", scanCmd.Flags().Lookup("subnet"))
 	viper.BindPFlag("scan.subnet-masks", scanCmd.Flags().Lookup("subnet-mask"))
 	viper.BindPFlag("scan.disable-probing", scanCmd.Flags().Lookup("disable-probing"))
+	viper.BindPFlag("scan.ranges", scanCmd.Flags().Lookup("range"))
+	viper.BindPFlag("scan.probe-protocols", scanCmd.Flags().Lookup("probe-protocols"))
+	viper.BindPFlag("scan.save-probe-states", scanCmd.Flags().Lookup("save-probe-states"))
 
 	rootCmd.AddCommand(scanCmd)
 }
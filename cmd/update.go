@@ -58,7 +58,7 @@ var updateCmd = &cobra.Command{
 		// if err != nil {
 		// 	l.Log.Errorf("failed tomake client: %v", err)
 		// }
-		// err = magellan.UpdateFirmware(client, l, q)
+		// err = magellan.UpdateFirmware(cmd.Context(), client, l, q)
 		err := magellan.UpdateFirmwareRemote(q)
 		if err != nil {
 			l.Log.Errorf("failed toupdate firmware: %v", err)
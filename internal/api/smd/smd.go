@@ -4,14 +4,20 @@ package smd
 //	https://github.com/OpenCHAMI/hms-smd/blob/master/docs/examples.adoc
 //	https://github.com/OpenCHAMI/hms-smd
 import (
+	"bytes"
 	"crypto/tls"
 	"crypto/x509"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"net"
 	"net/http"
+	"net/url"
 	"os"
+	"strings"
 	"time"
 
+	"github.com/Cray-HPE/hms-xname/xnametypes"
 	"github.com/OpenCHAMI/magellan/internal/util"
 )
 
@@ -21,11 +27,20 @@ var (
 	Port         = 27779
 )
 
+// ErrEndpointExists is returned by AddRedfishEndpoint when SMD responds with
+// a conflict because the endpoint was already added.
+var ErrEndpointExists = errors.New("redfish endpoint already exists")
+
+// ErrUnauthorized is returned when SMD rejects the access token (i.e. it
+// expired or was revoked) so a new one can be requested.
+var ErrUnauthorized = errors.New("unauthorized")
+
 type Option func(*Client)
 
 type Client struct {
 	*http.Client
 	CACertPool *x509.CertPool
+	BaseUrl    string
 }
 
 func NewClient(opts ...Option) *Client {
@@ -38,6 +53,75 @@ func NewClient(opts ...Option) *Client {
 	return client
 }
 
+// WithBaseUrl overrides the scheme, host, and port used to reach SMD. The
+// package level Host and Port are used when the URL is empty.
+func WithBaseUrl(baseUrl string) Option {
+	return func(c *Client) {
+		c.BaseUrl = strings.TrimSuffix(baseUrl, "/")
+	}
+}
+
+// ValidateBaseUrl checks that the URL given to WithBaseUrl has an http or
+// https scheme and a host. An empty URL is valid since it uses the defaults.
+func ValidateBaseUrl(baseUrl string) error {
+	if baseUrl == "" {
+		return nil
+	}
+	u, err := url.Parse(baseUrl)
+	if err != nil {
+		return fmt.Errorf("invalid SMD URL '%s': %v", baseUrl, err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("invalid SMD URL '%s': scheme must be http or https", baseUrl)
+	}
+	if u.Host == "" {
+		return fmt.Errorf("invalid SMD URL '%s': missing host", baseUrl)
+	}
+	return nil
+}
+
+// ValidateRedfishEndpoint checks the fields of a RedfishEndpoint required by
+// SMD before it is added so that mistakes are reported with the field that
+// is wrong instead of the opaque error returned by SMD.
+func ValidateRedfishEndpoint(data []byte) error {
+	var endpoint map[string]any
+	err := json.Unmarshal(data, &endpoint)
+	if err != nil {
+		return fmt.Errorf("invalid redfish endpoint: %v", err)
+	}
+
+	var errList []error
+	for _, key := range []string{"ID", "FQDN", "User"} {
+		value, ok := endpoint[key]
+		if !ok {
+			errList = append(errList, fmt.Errorf("missing '%s'", key))
+			continue
+		}
+		s, ok := value.(string)
+		if !ok {
+			errList = append(errList, fmt.Errorf("'%s' must be a string", key))
+			continue
+		}
+		if s == "" && key != "User" {
+			errList = append(errList, fmt.Errorf("'%s' cannot be empty", key))
+		}
+	}
+	if id, ok := endpoint["ID"].(string); ok && id != "" && !xnametypes.IsHMSCompIDValid(id) {
+		errList = append(errList, fmt.Errorf("'ID' is not a valid xname: %s", id))
+	}
+	for _, key := range []string{"MACRequired", "RediscoverOnUpdate", "Enabled"} {
+		if value, ok := endpoint[key]; ok {
+			if _, ok := value.(bool); !ok {
+				errList = append(errList, fmt.Errorf("'%s' must be a boolean", key))
+			}
+		}
+	}
+	if len(errList) > 0 {
+		return fmt.Errorf("invalid redfish endpoint: %w", errors.Join(errList...))
+	}
+	return nil
+}
+
 func WithHttpClient(httpClient *http.Client) Option {
 	return func(c *Client) {
 		c.Client = httpClient
@@ -76,7 +160,7 @@ func WithSecureTLS(certPath string) Option {
 }
 
 func (c *Client) GetRedfishEndpoints(headers map[string]string, opts ...Option) error {
-	url := makeEndpointUrl("/Inventory/RedfishEndpoints")
+	url := c.makeEndpointUrl("/Inventory/RedfishEndpoints")
 	_, body, err := c.MakeRequest(url, "GET", nil, headers)
 	if err != nil {
 		return fmt.Errorf("failed toget endpoint: %v", err)
@@ -86,8 +170,38 @@ func (c *Client) GetRedfishEndpoints(headers map[string]string, opts ...Option)
 	return nil
 }
 
+// GetRedfishEndpointIDs returns the IDs (xnames) of the redfish endpoints
+// already known to SMD keyed by their FQDN.
+func (c *Client) GetRedfishEndpointIDs(headers map[string]string) (map[string]string, error) {
+	url := c.makeEndpointUrl("/Inventory/RedfishEndpoints")
+	res, body, err := c.MakeRequest(url, "GET", nil, headers)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get endpoints: %v", err)
+	}
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("returned status code %d when getting endpoints", res.StatusCode)
+	}
+
+	var endpoints struct {
+		RedfishEndpoints []struct {
+			ID   string
+			FQDN string
+		}
+	}
+	err = json.Unmarshal(body, &endpoints)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal endpoints: %v", err)
+	}
+
+	ids := make(map[string]string, len(endpoints.RedfishEndpoints))
+	for _, endpoint := range endpoints.RedfishEndpoints {
+		ids[endpoint.FQDN] = endpoint.ID
+	}
+	return ids, nil
+}
+
 func (c *Client) GetComponentEndpoint(xname string) error {
-	url := makeEndpointUrl("/Inventory/ComponentsEndpoints/" + xname)
+	url := c.makeEndpointUrl("/Inventory/ComponentsEndpoints/" + xname)
 	res, body, err := c.MakeRequest(url, "GET", nil, nil)
 	if err != nil {
 		return fmt.Errorf("failed toget endpoint: %v", err)
@@ -103,14 +217,19 @@ func (c *Client) AddRedfishEndpoint(data []byte, headers map[string]string) erro
 	}
 
 	// Add redfish endpoint via POST `/hsm/v2/Inventory/RedfishEndpoints` endpoint
-	url := makeEndpointUrl("/Inventory/RedfishEndpoints")
+	url := c.makeEndpointUrl("/Inventory/RedfishEndpoints")
 	res, body, err := c.MakeRequest(url, "POST", data, headers)
 	if res != nil {
 		statusOk := res.StatusCode >= 200 && res.StatusCode < 300
+		if res.StatusCode == http.StatusConflict {
+			return fmt.Errorf("failed to add endpoint: %w", ErrEndpointExists)
+		}
+		if res.StatusCode == http.StatusUnauthorized {
+			return fmt.Errorf("failed to add endpoint: %w", ErrUnauthorized)
+		}
 		if !statusOk {
-			return fmt.Errorf("returned status code %d when adding endpoint", res.StatusCode)
+			return fmt.Errorf("returned status code %d when adding endpoint%s", res.StatusCode, describeBody(body))
 		}
-		fmt.Printf("%v (%v)\n%s\n", url, res.Status, string(body))
 	}
 	return err
 }
@@ -120,18 +239,56 @@ func (c *Client) UpdateRedfishEndpoint(xname string, data []byte, headers map[st
 		return fmt.Errorf("failed to add redfish endpoint: no data found")
 	}
 	// Update redfish endpoint via PUT `/hsm/v2/Inventory/RedfishEndpoints` endpoint
-	url := makeEndpointUrl("/Inventory/RedfishEndpoints/" + xname)
+	url := c.makeEndpointUrl("/Inventory/RedfishEndpoints/" + xname)
 	res, body, err := c.MakeRequest(url, "PUT", data, headers)
-	fmt.Printf("%v (%v)\n%s\n", url, res.Status, string(body))
 	if res != nil {
+		if res.StatusCode == http.StatusUnauthorized {
+			return fmt.Errorf("failed to update redfish endpoint: %w", ErrUnauthorized)
+		}
 		statusOk := res.StatusCode >= 200 && res.StatusCode < 300
 		if !statusOk {
-			return fmt.Errorf("failed to update redfish endpoint (returned %s)", res.Status)
+			return fmt.Errorf("failed to update redfish endpoint (returned %s)%s", res.Status, describeBody(body))
 		}
 	}
 	return err
 }
 
+// describeBody returns the body of an error response from SMD (which says
+// what was wrong with the request) to append to the error or an empty
+// string when there is none.
+func describeBody(body []byte) string {
+	body = bytes.TrimSpace(body)
+	if len(body) == 0 {
+		return ""
+	}
+	return ": " + string(body)
+}
+
+// PatchComponentState updates only the state of an existing component via
+// PATCH `/hsm/v2/State/Components/{xname}/StateData` endpoint
+func (c *Client) PatchComponentState(xname string, data []byte, headers map[string]string) error {
+	if data == nil {
+		return fmt.Errorf("failed to patch component state: no data found")
+	}
+	url := c.makeEndpointUrl("/State/Components/" + xname + "/StateData")
+	res, _, err := c.MakeRequest(url, "PATCH", data, headers)
+	if err != nil {
+		return fmt.Errorf("failed to patch component state: %v", err)
+	}
+	statusOk := res.StatusCode >= 200 && res.StatusCode < 300
+	if !statusOk {
+		return fmt.Errorf("returned status code %d when patching component state", res.StatusCode)
+	}
+	return nil
+}
+
+func (c *Client) makeEndpointUrl(endpoint string) string {
+	if c.BaseUrl != "" {
+		return c.BaseUrl + BaseEndpoint + endpoint
+	}
+	return makeEndpointUrl(endpoint)
+}
+
 func makeEndpointUrl(endpoint string) string {
 	return Host + ":" + fmt.Sprint(Port) + BaseEndpoint + endpoint
 }
@@ -0,0 +1,58 @@
+package smd
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestAddRedfishEndpointError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"title":"Bad Request","detail":"invalid xname ID"}`))
+	}))
+	defer server.Close()
+	client := NewClient(WithBaseUrl(server.URL))
+
+	// the problem details returned by SMD explain what was wrong
+	err := client.AddRedfishEndpoint([]byte(`{"ID":"x"}`), nil)
+	if err == nil || !strings.Contains(err.Error(), "invalid xname ID") {
+		t.Errorf("expected the response body in the error, got %v", err)
+	}
+	err = client.UpdateRedfishEndpoint("x", []byte(`{"ID":"x"}`), nil)
+	if err == nil || !strings.Contains(err.Error(), "invalid xname ID") {
+		t.Errorf("expected the response body in the error, got %v", err)
+	}
+}
+
+func TestValidateRedfishEndpoint(t *testing.T) {
+	tests := []struct {
+		name  string
+		data  string
+		field string // expected in the error (valid when empty)
+	}{
+		{"valid", `{"ID":"x1000c1s7b0","FQDN":"10.0.0.1","User":"root","MACRequired":true}`, ""},
+		{"empty user", `{"ID":"x1000c1s7b0","FQDN":"10.0.0.1","User":""}`, ""},
+		{"not JSON", `ID=x1000c1s7b0`, "invalid redfish endpoint"},
+		{"missing ID", `{"FQDN":"10.0.0.1","User":"root"}`, "'ID'"},
+		{"empty FQDN", `{"ID":"x1000c1s7b0","FQDN":"","User":"root"}`, "'FQDN'"},
+		{"user not a string", `{"ID":"x1000c1s7b0","FQDN":"10.0.0.1","User":42}`, "'User'"},
+		{"invalid xname", `{"ID":"bmc01","FQDN":"10.0.0.1","User":"root"}`, "not a valid xname"},
+		{"flag not a boolean", `{"ID":"x1000c1s7b0","FQDN":"10.0.0.1","User":"root","Enabled":"yes"}`, "'Enabled'"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := ValidateRedfishEndpoint([]byte(test.data))
+			if test.field == "" {
+				if err != nil {
+					t.Errorf("expected the endpoint to be valid, got %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), test.field) {
+				t.Errorf("expected an error about %s, got %v", test.field, err)
+			}
+		})
+	}
+}
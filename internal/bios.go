@@ -0,0 +1,93 @@
+package magellan
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/OpenCHAMI/magellan/internal/util"
+	"github.com/stmcginnis/gofish"
+)
+
+// BiosDeviation is a BIOS attribute that does not match the golden profile.
+// Actual is nil when the attribute is missing from the system.
+type BiosDeviation struct {
+	Attribute string `json:"Attribute"`
+	Expected  any    `json:"Expected"`
+	Actual    any    `json:"Actual"`
+}
+
+// LoadBiosProfile reads a golden BIOS profile from a JSON file containing a
+// single object of attribute names to expected values.
+func LoadBiosProfile(path string) (map[string]any, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read BIOS profile: %v", err)
+	}
+
+	profile := map[string]any{}
+	err = json.Unmarshal(b, &profile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal BIOS profile: %v", err)
+	}
+	return profile, nil
+}
+
+// CollectBiosDrift compares the BIOS attributes of each system against
+// q.BiosProfile and returns the deviations keyed by system ID. Only the
+// attributes found in the profile are compared.
+func CollectBiosDrift(c *gofish.APIClient, q *QueryParams) ([]byte, error) {
+	value, err := collectBiosDrift(c, q)
+	if err != nil {
+		return nil, err
+	}
+	return marshalSection("BiosDrift", value)
+}
+
+func collectBiosDrift(c *gofish.APIClient, q *QueryParams) (any, error) {
+	systems, err := c.Service.Systems()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get systems: (%v:%v): %v", q.Host, q.Port, err)
+	}
+
+	var (
+		drift   = map[string][]BiosDeviation{}
+		errList []error
+	)
+	for _, system := range systems {
+		bios, err := system.Bios()
+		if err != nil {
+			errList = append(errList, fmt.Errorf("failed to get BIOS for system '%s': %v", system.ID, err))
+			continue
+		}
+		drift[system.ID] = compareBiosAttributes(bios.Attributes, q.BiosProfile)
+	}
+
+	// print any report errors
+	err = util.FormatErrorList(errList)
+	if util.HasErrors(errList) {
+		return nil, fmt.Errorf("failed to get BIOS attributes with %d error(s): \n%v", len(errList), err)
+	}
+
+	return drift, nil
+}
+
+// compareBiosAttributes returns the attributes in the profile whose values
+// differ from the actual attributes in sorted order. Values are compared by
+// their string form since BMCs are not consistent about types (i.e. 1 vs "1").
+func compareBiosAttributes(actual map[string]any, profile map[string]any) []BiosDeviation {
+	deviations := []BiosDeviation{}
+	for _, name := range util.SortedKeys(profile) {
+		expected := profile[name]
+		value, ok := actual[name]
+		if ok && fmt.Sprint(value) == fmt.Sprint(expected) {
+			continue
+		}
+		deviations = append(deviations, BiosDeviation{
+			Attribute: name,
+			Expected:  expected,
+			Actual:    value,
+		})
+	}
+	return deviations
+}
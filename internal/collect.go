@@ -3,12 +3,20 @@ package magellan
 import (
 	"context"
 	"crypto/tls"
+	"crypto/x509"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"net"
 	"net/http"
+	"net/url"
 	"os"
 	"path"
+	"runtime"
+	"strings"
 	"sync"
+	"syscall"
 	"time"
 
 	"github.com/OpenCHAMI/magellan/internal/log"
@@ -16,21 +24,89 @@ import (
 	"github.com/OpenCHAMI/magellan/internal/api/smd"
 	"github.com/OpenCHAMI/magellan/internal/util"
 
-	"github.com/Cray-HPE/hms-xname/xnames"
 	bmclib "github.com/bmc-toolbox/bmclib/v2"
 	_ "github.com/mattn/go-sqlite3"
 	"github.com/stmcginnis/gofish"
 	_ "github.com/stmcginnis/gofish"
+	"github.com/stmcginnis/gofish/common"
 	"github.com/stmcginnis/gofish/redfish"
-	"golang.org/x/exp/slices"
+	"golang.org/x/time/rate"
 )
 
 const (
 	IPMI_PORT  = 623
 	SSH_PORT   = 22
 	HTTPS_PORT = 443
+
+	SCHEMA_VERSION = "v1"
+
+	OUTPUT_FILES  = "files"  // one JSON file per host in OutputPath
+	OUTPUT_NDJSON = "ndjson" // one line of JSON per host appended to OutputPath
 )
 
+// Version of the collector set at build time with:
+//
+//	-ldflags "-X github.com/OpenCHAMI/magellan/internal.Version=..."
+var Version = "dev"
+
+// CollectResult is the outcome of collecting from a single host. Only the
+// first stage that failed is recorded.
+type CollectResult struct {
+	Host     string
+	Port     int
+	Xname    string
+	Success  bool
+	NotBMC   bool   // host responded but does not look like a Redfish service
+	Provider string // driver/provider that served the host (i.e. gofish)
+	Stage    string
+	Error    string
+	Elapsed  time.Duration
+	Errors   map[string]string // errors of the sections that could not be collected
+	Payload  json.RawMessage   // data written to file and sent to SMD (without the password)
+
+	// difference between the clock of the BMC and the collector (positive
+	// when the BMC is ahead) which is only set when managers are collected
+	// and report their time
+	ClockSkewSeconds *float64 `json:",omitempty"`
+
+	// Payload with the password SMD needs to reach the BMC which is only
+	// given to the SMD sink and never kept in the returned results
+	smdPayload json.RawMessage
+}
+
+// Clock tells the current time.
+type Clock interface {
+	Now() time.Time
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+// CountProviders returns the number of hosts served by each provider. Hosts
+// that were not served by any provider are counted under "none".
+func CountProviders(results []CollectResult) map[string]int {
+	counts := map[string]int{}
+	for _, result := range results {
+		if result.Provider == "" {
+			counts["none"] += 1
+			continue
+		}
+		counts[result.Provider] += 1
+	}
+	return counts
+}
+
+func (r *CollectResult) fail(stage string, err error) {
+	if r.Success {
+		r.Stage = stage
+		r.Error = fmt.Sprint(err)
+	}
+	r.Success = false
+}
+
 // NOTE: ...params were getting too long...
 type QueryParams struct {
 	Host         string
@@ -39,569 +115,2559 @@ type QueryParams struct {
 	User         string
 	Pass         string
 	Drivers      []string
-	Concurrency  int
+	Concurrency  int // number of workers (uses runtime.NumCPU() when not positive)
 	Preferred    string
 	Timeout      int
 	CaCertPath   string
+	SecureTLS    bool   // verify BMC certificates against CaCertPath (or the system roots)
+	Proxy        string // HTTP(S) or SOCKS5 proxy URL used for Redfish requests (not IPMI)
 	Verbose      bool
 	IpmitoolPath string
 	OutputPath   string
+	OutputFormat string // OUTPUT_FILES (default) or OUTPUT_NDJSON
+	Compact      bool   // write the output without indentation to save space
 	ForceUpdate  bool
 	AccessToken  string
+	BusyRetries  int               // number of retries when a BMC responds with 429/503
+	Retries      int               // number of retries when connecting to a BMC fails
+	RetryDelay   time.Duration     // delay before the first retry (doubles for each retry)
+	SmdCsvPath   string            // write an SMD bulk import CSV to this path if set
+	XnameMap     map[string]string // static host to xname mapping used before generating
+	Credentials  CredentialMap     // per host/subnet credentials used instead of User and Pass
+
+	// external store of credentials used instead of User, Pass, and Credentials
+	CredentialProvider CredentialProvider
+
+	// generates the xname for each BMC (see NewXnameGenerator for the default)
+	XnameGenerator XnameGenerator
+
+	CollectStorage        bool
+	CollectDrives         bool
+	CollectServiceRoot    bool
+	CollectPowerState     bool
+	CollectSEL            bool
+	CollectThermal        bool
+	CollectPowerSensors   bool
+	MaxLogEntries         int // max number of entries kept per log (0 keeps all)
+	CollectPowerSubsystem bool
+	CollectOem            bool
+	CollectRedundancy     bool
+	CollectTelemetry      bool
+	MaxMetricValues       int // max number of values kept per metric report (0 keeps all)
+	CollectIpmiLan        bool
+	IpmiLanChannel        int
+	IpmiPort              int // port used for IPMI (uses IPMI_PORT when not set)
+	CollectCertificates   bool
+	CollectFirmware       bool
+	CollectBoot           bool
+	CollectManagers       bool
+	CollectEthernet       bool
+	BiosProfile           map[string]any // compare BIOS attributes against this golden profile if set
+
+	// reset ("cold" or "warm") BMCs that cannot be connected to (nothing is done when empty)
+	ResetBMC    string
+	SmdEndpoint string            // base URL of SMD (uses smd.Host and smd.Port when empty)
+	SmdHeaders  map[string]string // added to (or replacing) the headers of requests to SMD
+
+	// get the access token for SMD using the OAuth2 client credentials grant
+	// instead of using AccessToken if set
+	SmdTokenUrl     string
+	SmdClientID     string
+	SmdClientSecret string
+	JUnitPath       string // write a JUnit XML report of the results to this path if set
+	SummaryPath     string // write a JSON summary of the results to this path if set
+	DryRun          bool   // write output files without adding anything to SMD
+
+	// queue what could not be added to SMD in this directory and send it
+	// again at the start of the next run (see FlushOutbox)
+	OutboxPath string
+
+	// hosts that failed within the cooldown are skipped unless forced to retry
+	CooldownPath string
+	Cooldown     time.Duration
+	ForceRetry   bool
+
+	// collections of ethernet interfaces to read instead of the ones of each
+	// manager and system (i.e. "/redfish/v1/Managers/1/EthernetInterfaces")
+	EthernetPaths []string
+
+	// provider preferred by the bmclib queries by section (i.e. "Inventory"
+	// or "PowerState") which falls back to Preferred for the others
+	PreferredProviders map[string]string
+
+	// skip adding hosts to SMD when their data has not changed since the
+	// last run (hashes are kept in the state cache at StateCachePath); the
+	// output files of unchanged hosts are only rewritten with TouchUnchanged
+	SkipUnchanged  bool
+	StateCachePath string
+	TouchUnchanged bool
+
+	// wrap the output in an envelope with metadata under EnvelopeKey ("data" by default)
+	Envelope    bool
+	EnvelopeKey string
+
+	// write a flat summary of the inventory to this path if set
+	InventoryCsvPath string
+
+	// write the MAC address of each BMC to this path (as .json or .csv) if set
+	MACMapPath string
+
+	// collect from the probe states saved to this path (see SaveProbeStates)
+	// instead of the ones passed to CollectAll if set
+	ProbeStatesPath string
+
+	// encrypt the files written to OutputPath with this key if set
+	EncryptionKey *[32]byte
+
+	// add the BMC password to the output files and other sinks (left out by
+	// default so credentials do not end up in artifacts); SMD always gets it
+	// since it needs it to reach the BMC
+	IncludePassword bool
+
+	// set FQDN to the name found with a reverse DNS lookup of the host when
+	// it is an IP address (the address is used when nothing is found)
+	ResolveFQDN bool
+
+	// permissions of the output files (0600 by default) and directory (0700
+	// by default) since they contain BMC credentials
+	FileMode os.FileMode
+	DirMode  os.FileMode
+
+	// number of collected hosts waiting to be written and sent to SMD
+	// before workers block (defaults to Concurrency)
+	ResultBuffer int
+
+	// collect from one host at a time in sorted order so logs can be compared between runs
+	Deterministic bool
+
+	// collect from BMCs found at multiple hosts once using the UUID of their
+	// service root (the other hosts are added as "Aliases")
+	Dedupe bool
+
+	// collect only these sections (i.e. "Chassis", "Systems", or any in
+	// Sections) instead of the ones enabled above if set
+	Sections []string
+
+	// max number of connections made to BMCs per second (0 is unlimited)
+	RateLimit float64
+
+	// max time to connect to a BMC (including the TLS handshake) and to wait
+	// on the queries made to it which both use Timeout (in seconds) when not set
+	ConnectTimeout time.Duration
+	QueryTimeout   time.Duration
+
+	// hosts whose clock is off by more than this are listed in the summary
+	// (requires CollectManagers to get the time of the BMC)
+	MaxClockSkew time.Duration
+
+	// max time for the whole run after which hosts still being collected
+	// are given up on and marked as timed out (0 is unlimited)
+	GlobalTimeout time.Duration
+
+	// also write each result to these after the output files and SMD
+	Sinks []OutputSink
+
+	// serve Prometheus metrics at "/metrics" on this address while collecting if set
+	MetricsAddr string
+
+	// called when a host is started, each section is collected, and a host
+	// is done (see CollectEvent); it is called from multiple workers at once
+	// so it must be safe to use between goroutines
+	Progress func(CollectEvent)
+
+	// never use $expand, even when the BMC advertises support for it (when
+	// used, requests rejected because of it are retried without it)
+	DisableExpand bool
+
+	// detect the vendor of each BMC and apply its quirks (see VendorQuirks)
+	VendorQuirks bool
+
+	// Transport replaces the default transport used for requests made to BMCs
+	// (by gofish and bmclib) which is useful for testing and adding
+	// middleware. See makeTransport.
+	Transport http.RoundTripper
+
+	// Clock replaces the system clock used for the times recorded in the
+	// output (i.e. the envelope and clock skew) which is useful for testing.
+	Clock Clock
+
+	// Resolver replaces the DNS resolver used when ResolveFQDN is set.
+	Resolver Resolver
+
+	// slots shared with other collections running at the same time
+	sem chan struct{}
+
+	// limits the rate of connections across every worker (see RateLimit)
+	limiter *rate.Limiter
+
+	// metrics shared with other collections (see MetricsAddr)
+	metrics *Metrics
+
+	// stores, output files, and results shared with the collections of other
+	// targets which are saved and reported once they are all done
+	shared *sharedRun
+
+	// called when a request is retried without $expand
+	onExpandFallback func(uri string)
+
+	// vendor of the BMC detected when VendorQuirks is set
+	vendor string
 }
 
-func CollectAll(probeStates *[]ScannedResult, l *log.Logger, q *QueryParams) error {
-	// check for available probe states
+func (q *QueryParams) ipmiPort() int {
+	if q.IpmiPort <= 0 {
+		return IPMI_PORT
+	}
+	return q.IpmiPort
+}
+
+func (q *QueryParams) fileMode() os.FileMode {
+	if q.FileMode == 0 {
+		return 0o600
+	}
+	return q.FileMode
+}
+
+func (q *QueryParams) dirMode() os.FileMode {
+	if q.DirMode == 0 {
+		return 0o700
+	}
+	return q.DirMode
+}
+
+// now returns the time of q.Clock or the system clock when not set.
+func (q *QueryParams) now() time.Time {
+	if q.Clock == nil {
+		return systemClock{}.Now()
+	}
+	return q.Clock.Now()
+}
+
+// marshalOutput marshals the output of a host with indentation unless
+// q.Compact is set. Sections are re-indented (or compacted) along with the
+// rest of the output so they do not need to follow the setting.
+func (q *QueryParams) marshalOutput(v any) ([]byte, error) {
+	if q.Compact {
+		return json.Marshal(v)
+	}
+	return json.MarshalIndent(v, "", "    ")
+}
+
+// connectTimeout returns ConnectTimeout or Timeout when not set.
+func (q *QueryParams) connectTimeout() time.Duration {
+	if q.ConnectTimeout > 0 {
+		return q.ConnectTimeout
+	}
+	return time.Second * time.Duration(q.Timeout)
+}
+
+// queryTimeout returns QueryTimeout or Timeout when not set.
+func (q *QueryParams) queryTimeout() time.Duration {
+	if q.QueryTimeout > 0 {
+		return q.QueryTimeout
+	}
+	return time.Second * time.Duration(q.Timeout)
+}
+
+// preferredProvider returns the bmclib provider to prefer for the section.
+func (q *QueryParams) preferredProvider(section string) string {
+	for key, provider := range q.PreferredProviders {
+		if strings.EqualFold(key, section) {
+			return provider
+		}
+	}
+	return q.Preferred
+}
+
+// Validate checks the params used for collecting and returns an error
+// listing every problem found. The port is only checked when a host is set
+// since CollectAll sets both for each host.
+func (q *QueryParams) Validate() error {
+	var errList []error
+	if q.Timeout <= 0 {
+		errList = append(errList, fmt.Errorf("timeout must be greater than 0"))
+	}
+	if q.ConnectTimeout < 0 || q.QueryTimeout < 0 {
+		errList = append(errList, fmt.Errorf("connect and query timeouts cannot be negative"))
+	}
+	if q.Host != "" && (q.Port < 1 || q.Port > 65535) {
+		errList = append(errList, fmt.Errorf("port %d must be between 1 and 65535", q.Port))
+	}
+	if q.IpmiPort < 0 || q.IpmiPort > 65535 {
+		errList = append(errList, fmt.Errorf("IPMI port %d must be between 1 and 65535", q.IpmiPort))
+	}
+	if len(q.Drivers) == 0 {
+		errList = append(errList, fmt.Errorf("at least one driver is required"))
+	}
+	for _, driver := range q.Drivers {
+		if driver == "" {
+			errList = append(errList, fmt.Errorf("driver names cannot be empty"))
+			break
+		}
+	}
+	if q.CaCertPath != "" {
+		exists, err := util.PathExists(q.CaCertPath)
+		if err != nil || !exists {
+			errList = append(errList, fmt.Errorf("CA certificate '%s' not found", q.CaCertPath))
+		}
+	}
+	switch q.OutputFormat {
+	case "", OUTPUT_FILES:
+	case OUTPUT_NDJSON:
+		if q.EncryptionKey != nil {
+			errList = append(errList, fmt.Errorf("encrypting output is not supported with ndjson"))
+		}
+	default:
+		errList = append(errList, fmt.Errorf("invalid output format '%s' (must be '%s' or '%s')", q.OutputFormat, OUTPUT_FILES, OUTPUT_NDJSON))
+	}
+	if ext := strings.ToLower(path.Ext(q.MACMapPath)); q.MACMapPath != "" && ext != ".json" && ext != ".csv" {
+		errList = append(errList, fmt.Errorf("MAC mapping '%s' must be a .json or .csv file", q.MACMapPath))
+	}
+	if q.SmdTokenUrl != "" && q.SmdClientID == "" {
+		errList = append(errList, fmt.Errorf("getting an SMD access token requires a client ID"))
+	}
+	if q.SkipUnchanged && q.StateCachePath == "" {
+		errList = append(errList, fmt.Errorf("skipping unchanged hosts requires a state cache path"))
+	}
+	err := smd.ValidateBaseUrl(q.SmdEndpoint)
+	if err != nil {
+		errList = append(errList, err)
+	}
+	_, err = makeProxy(q)
+	if err != nil {
+		errList = append(errList, err)
+	}
+	for _, name := range q.Sections {
+		_, ok := sectionsByName[strings.ToLower(name)]
+		if !ok && !strings.EqualFold(name, "Chassis") && !strings.EqualFold(name, "Systems") {
+			errList = append(errList, fmt.Errorf("unknown section '%s'", name))
+		}
+	}
+
+	if len(errList) > 0 {
+		return fmt.Errorf("invalid query params: %w", errors.Join(errList...))
+	}
+	return nil
+}
+
+// Section is an optional part of the data collected from each host after
+// the chassis and systems.
+type Section struct {
+	Key     string                    // key of the data in the output
+	Enabled func(q *QueryParams) bool // whether collected when Sections is empty
+	Collect ValueFunc                 // returns the data under Key
+}
+
+// ValueFunc collects a section from the BMC in q using the gofish client. The
+// data is returned as is so it is only marshalled once with the rest of the
+// output. A nil value leaves the section out.
+type ValueFunc func(ctx context.Context, c *gofish.APIClient, l *log.Logger, q *QueryParams) (any, error)
+
+// marshalSection returns JSON with value under key as returned by the
+// exported collect functions. A nil value returns nil so the section is left
+// out.
+func marshalSection(key string, value any) ([]byte, error) {
+	if value == nil {
+		return nil, nil
+	}
+	b, err := json.MarshalIndent(map[string]any{key: value}, "", "    ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal JSON: %v", err)
+	}
+	return b, nil
+}
+
+// ignoreLogger adapts collect functions that only use the gofish client to a
+// ValueFunc.
+func ignoreLogger(fn func(c *gofish.APIClient, q *QueryParams) (any, error)) ValueFunc {
+	return func(ctx context.Context, c *gofish.APIClient, l *log.Logger, q *QueryParams) (any, error) {
+		return fn(c, q)
+	}
+}
+
+// ignoreContext adapts collect functions that log to a ValueFunc. The gofish
+// client is already bound to the context of the collection.
+func ignoreContext(fn func(c *gofish.APIClient, l *log.Logger, q *QueryParams) (any, error)) ValueFunc {
+	return func(ctx context.Context, c *gofish.APIClient, l *log.Logger, q *QueryParams) (any, error) {
+		return fn(c, l, q)
+	}
+}
+
+// Sections lists the optional sections in the order they are collected by
+// default.
+var Sections = []Section{
+	// redfish version and supported features
+	{"ServiceRoot", func(q *QueryParams) bool { return q.CollectServiceRoot }, ignoreLogger(collectServiceRoot)},
+	// current power state
+	{"PowerState", func(q *QueryParams) bool { return q.CollectPowerState }, ignoreLogger(collectSystemPowerState)},
+	// temperature, fan, and power supply readings
+	{"Sensors", func(q *QueryParams) bool { return q.CollectThermal || q.CollectPowerSensors }, ignoreLogger(collectSensors)},
+	// system event log
+	{"SEL", func(q *QueryParams) bool { return q.CollectSEL }, ignoreLogger(collectSEL)},
+	// storage systems and services
+	{"Storage", func(q *QueryParams) bool { return q.CollectStorage }, ignoreContext(collectStorage)},
+	// drives of each system's storage subsystems
+	{"Drives", func(q *QueryParams) bool { return q.CollectDrives }, ignoreContext(collectDrives)},
+	// power supplies
+	{"PowerSubsystem", func(q *QueryParams) bool { return q.CollectPowerSubsystem }, ignoreLogger(collectPowerSubsystem)},
+	// fan and power supply redundancy
+	{"Redundancy", func(q *QueryParams) bool { return q.CollectRedundancy }, ignoreLogger(collectRedundancy)},
+	// metric reports
+	{"Telemetry", func(q *QueryParams) bool { return q.CollectTelemetry }, ignoreLogger(collectTelemetry)},
+	// IPMI LAN channel config
+	{"IpmiLan", func(q *QueryParams) bool { return q.CollectIpmiLan }, func(ctx context.Context, c *gofish.APIClient, l *log.Logger, q *QueryParams) (any, error) {
+		return collectIpmiLan(ctx, q)
+	}},
+	// BIOS attributes that differ from the golden profile
+	{"BiosDrift", func(q *QueryParams) bool { return len(q.BiosProfile) > 0 }, ignoreLogger(collectBiosDrift)},
+	// installed certificates (skipped when there is no certificate service)
+	{"Certificates", func(q *QueryParams) bool { return q.CollectCertificates }, ignoreLogger(collectCertificates)},
+	// boot source override and boot order
+	{"Boot", func(q *QueryParams) bool { return q.CollectBoot }, ignoreContext(collectBootOptions)},
+	// the BMC itself and its network protocols
+	{"Managers", func(q *QueryParams) bool { return q.CollectManagers }, ignoreContext(collectManagers)},
+	// ethernet interfaces of the managers and systems
+	{"EthernetInterfaces", func(q *QueryParams) bool { return q.CollectEthernet }, func(ctx context.Context, c *gofish.APIClient, l *log.Logger, q *QueryParams) (any, error) {
+		return collectEthernetInterfaces(c, l, q, "")
+	}},
+	// firmware versions of each component
+	{"Firmware", func(q *QueryParams) bool { return q.CollectFirmware }, ignoreLogger(collectFirmwareInventory)},
+	// vendor specific sections
+	{"Oem", func(q *QueryParams) bool { return q.CollectOem }, ignoreLogger(collectOem)},
+}
+
+// optional sections by lowercase key
+var sectionsByName = func() map[string]Section {
+	m := make(map[string]Section, len(Sections))
+	for _, section := range Sections {
+		m[strings.ToLower(section.Key)] = section
+	}
+	return m
+}()
+
+// wantsSection returns whether the chassis or systems should be collected
+// which is always unless other sections were requested instead.
+func (q *QueryParams) wantsSection(key string) bool {
+	if len(q.Sections) <= 0 {
+		return true
+	}
+	for _, name := range q.Sections {
+		if strings.EqualFold(name, key) {
+			return true
+		}
+	}
+	return false
+}
+
+// sectionNames returns the optional sections to collect which are the ones
+// in q.Sections if any or the ones enabled by the other fields otherwise.
+func (q *QueryParams) sectionNames() []string {
+	var names []string
+	if len(q.Sections) > 0 {
+		for _, name := range q.Sections {
+			if _, ok := sectionsByName[strings.ToLower(name)]; ok {
+				names = append(names, name)
+			}
+		}
+		return names
+	}
+	for _, section := range Sections {
+		if section.Enabled(q) {
+			names = append(names, section.Key)
+		}
+	}
+	return names
+}
+
+// globalDeadline returns a channel closed once the q.GlobalTimeout set on
+// ctx has passed or nil (blocks forever) when there is no global timeout. It
+// is not closed when ctx is cancelled for any other reason (i.e. ctrl+c) so
+// hosts are only reported as timed out at the deadline.
+func globalDeadline(ctx context.Context, q *QueryParams) <-chan struct{} {
+	if q.GlobalTimeout <= 0 {
+		return nil
+	}
+	expired := make(chan struct{})
+	go func() {
+		<-ctx.Done()
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			close(expired)
+		}
+	}()
+	return expired
+}
+
+// collectedHost is the data collected from a host waiting to be written and
+// sent to SMD. Data is nil when nothing was collected.
+type collectedHost struct {
+	ps     ScannedResult
+	start  time.Time
+	result CollectResult
+	data   map[string]any
+	pass   string // password of the BMC which is only sent to SMD
+}
+
+// CollectAll collects from every BMC found in the probe states, writes the
+// data to OutputPath, and adds it to SMD. The outcome of each host is
+// returned so callers can inspect what was collected. Cancelling ctx stops
+// handing out hosts and aborts the requests in progress.
+func CollectAll(ctx context.Context, probeStates *[]ScannedResult, l *log.Logger, q *QueryParams) ([]CollectResult, error) {
+	return collectAll(ctx, probeStates, nil, l, q)
+}
+
+// CollectStream is like CollectAll but collects from the probe states as they
+// are received until the channel is closed so huge fleets do not have to be
+// loaded up front. Each host is written to the sinks as soon as it is done
+// and the results are returned without their payload so only a small record
+// of each host (its result and the set used to skip duplicates) is kept in
+// memory instead of its data. Since the fleet is not known up front, hosts are
+// not sorted with Deterministic or deduplicated with Dedupe, and exporting
+// CSVs (which need every host's data at the end) is not supported.
+func CollectStream(ctx context.Context, probeStates <-chan ScannedResult, l *log.Logger, q *QueryParams) ([]CollectResult, error) {
 	if probeStates == nil {
-		return fmt.Errorf("no probe states found")
+		return nil, fmt.Errorf("no probe states found")
 	}
-	if len(*probeStates) <= 0 {
-		return fmt.Errorf("no probe states found")
+	if q.SmdCsvPath != "" || q.InventoryCsvPath != "" || q.MACMapPath != "" {
+		return nil, fmt.Errorf("exporting CSVs or MAC mappings is not supported when streaming probe states")
 	}
+	return collectAll(ctx, nil, probeStates, l, q)
+}
 
-	// make the output directory to store files
-	outputPath := path.Clean(q.OutputPath)
-	outputPath, err := util.MakeOutputDirectory(outputPath)
+// collectAll collects from either the probe states or the stream if not nil.
+func collectAll(ctx context.Context, probeStates *[]ScannedResult, stream <-chan ScannedResult, l *log.Logger, q *QueryParams) ([]CollectResult, error) {
+	start := time.Now()
+
+	// catch misconfigurations before doing any work
+	err := q.Validate()
 	if err != nil {
-		l.Log.Errorf("failed to make output directory: %v", err)
+		return nil, err
+	}
+
+	// stop the whole run at the deadline regardless of the per-host timeouts
+	// (cancelled on return which also ends the wait in globalDeadline)
+	if q.GlobalTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, q.GlobalTimeout)
+		defer cancel()
+	}
+
+	// check for available probe states
+	if q.ProbeStatesPath != "" && stream == nil {
+		states, err := LoadProbeStates(q.ProbeStatesPath)
+		if err != nil {
+			return nil, err
+		}
+		probeStates = &states
+	}
+	if stream == nil && (probeStates == nil || len(*probeStates) <= 0) {
+		return nil, fmt.Errorf("no probe states found")
+	}
+
+	// make the output directory to store files or open the single file
+	// that every host is appended to
+	var (
+		outputPath string
+		ndjson     *lineWriter
+	)
+	switch q.OutputFormat {
+	case "", OUTPUT_FILES:
+		outputPath, err = util.MakeOutputDirectory(path.Clean(q.OutputPath), q.dirMode())
+		if err != nil {
+			return nil, fmt.Errorf("failed to make output directory: %v", err)
+		}
+	case OUTPUT_NDJSON:
+		if q.shared != nil {
+			ndjson, err = q.shared.lineWriter(path.Clean(q.OutputPath), q.fileMode())
+			if err != nil {
+				return nil, err
+			}
+			break
+		}
+		file, err := os.OpenFile(path.Clean(q.OutputPath), os.O_APPEND|os.O_CREATE|os.O_WRONLY, q.fileMode())
+		if err != nil {
+			return nil, fmt.Errorf("failed to open output file: %v", err)
+		}
+		defer file.Close()
+		ndjson = &lineWriter{w: file}
+	default:
+		return nil, fmt.Errorf("invalid output format '%s' (must be '%s' or '%s')", q.OutputFormat, OUTPUT_FILES, OUTPUT_NDJSON)
+	}
+
+	// load the provided CA to check which BMCs present certs signed by it
+	var caPool *x509.CertPool
+	if q.CaCertPath != "" {
+		caPool, err = loadCertPool(q.CaCertPath)
+		if err != nil {
+			l.Log.Warnf("failed to load CA cert: %v", err)
+		}
+	}
+
+	// skip hosts that failed recently unless forced to retry
+	var cooldown *CooldownStore
+	if q.shared != nil {
+		cooldown = q.shared.cooldown
+	} else if q.CooldownPath != "" {
+		cooldown, err = LoadCooldownStore(q.CooldownPath)
+		if err != nil {
+			l.Log.Warnf("failed to load cooldown store: %v", err)
+		}
+	}
+
+	// remember what was collected last time to skip hosts that did not change
+	var stateCache *StateCache
+	if q.shared != nil {
+		stateCache = q.shared.stateCache
+	} else if q.SkipUnchanged {
+		stateCache, err = LoadStateCache(q.StateCachePath)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// process hosts one at a time in sorted order for reproducible logs
+	var (
+		states      []ScannedResult
+		concurrency = q.Concurrency
+	)
+	if stream == nil {
+		states = *probeStates
+	}
+	if q.Deterministic {
+		states = SortScannedResults(states)
+		concurrency = 1
+	}
+
+	// use one worker per CPU by default but no more than there are hosts
+	if concurrency <= 0 {
+		concurrency = runtime.NumCPU()
+	}
+	if stream == nil && concurrency > len(states) {
+		concurrency = len(states)
+	}
+
+	// share the rate limit between workers (and other collections if set)
+	limiter := q.limiter
+	if limiter == nil {
+		limiter = newLimiter(q.RateLimit)
+	}
+
+	// collect from each BMC once even if it answers at multiple addresses
+	// (keeping the service roots read to tell them apart for collecting)
+	var (
+		aliases map[string][]string
+		roots   map[string]*serviceRoot
+	)
+	if q.Dedupe && stream == nil {
+		params := *q
+		params.limiter = limiter
+		states, aliases, roots = dedupeByUUID(ctx, states, l, &params, concurrency)
+	}
+
+	// hand out the probe states from the same channel either way
+	if stream == nil {
+		buffered := make(chan ScannedResult, len(states))
+		for _, ps := range states {
+			buffered <- ps
+		}
+		close(buffered)
+		stream = buffered
+	}
+
+	// pick the default way to name BMCs if one was not given
+	xnameGenerator := q.XnameGenerator
+	if xnameGenerator == nil {
+		xnameGenerator, _ = NewXnameGenerator("", q.XnameMap, l)
+	}
+
+	resultBuffer := q.ResultBuffer
+	if resultBuffer <= 0 {
+		resultBuffer = concurrency
+	}
+
+	// serve metrics while collecting unless already served by the caller
+	metrics := q.metrics
+	if metrics == nil && q.MetricsAddr != "" {
+		metrics = NewMetrics()
+		metricsCtx, metricsCancel := context.WithCancel(ctx)
+		defer metricsCancel()
+		err = ServeMetrics(metricsCtx, q.MetricsAddr, metrics)
+		if err != nil {
+			return nil, err
+		}
 	}
 
 	// collect bmc information asynchronously
 	var (
-		offset         = 0
 		wg             sync.WaitGroup
-		found          = make([]string, 0, len(*probeStates))
-		done           = make(chan struct{}, q.Concurrency+1)
-		chanProbeState = make(chan ScannedResult, q.Concurrency+1)
+		dispatched     = make(map[string]bool, len(states))
+		records        = make([]map[string]any, 0, len(states))
+		results        = make([]CollectResult, 0, len(states))
+		mu             sync.Mutex
+		done           = make(chan struct{}, concurrency+1)
+		chanProbeState = make(chan ScannedResult, concurrency+1)
+		chanResults    = make(chan collectedHost, resultBuffer)
+		sinkDone       = make(chan struct{})
+		pending        = map[string]ScannedResult{} // dispatched hosts not written yet
+		abandoned      bool
+		submitting     sync.Mutex // held while a host is written to the sinks
 		client         = smd.NewClient(
 			smd.WithSecureTLS(q.CaCertPath),
+			smd.WithBaseUrl(q.SmdEndpoint),
 		)
 	)
-	wg.Add(q.Concurrency)
-	for i := 0; i < q.Concurrency; i++ {
-		go func() {
-			for {
-				ps, ok := <-chanProbeState
-				if !ok {
-					wg.Done()
-					return
+
+	// write to the log, the output files, SMD, then any other sinks in order
+	// (failures are recorded as the stage of the sink)
+	type stagedSink struct {
+		OutputSink
+		stage string
+	}
+	var sinks []stagedSink
+	if q.Verbose {
+		sinks = append(sinks, stagedSink{&logSink{q: q, l: l}, "write"})
+	}
+	if ndjson != nil || outputPath != "" {
+		sinks = append(sinks, stagedSink{&fileSink{q: q, dir: outputPath, ndjson: ndjson}, "write"})
+	}
+	sinks = append(sinks, stagedSink{&smdSink{q: q, l: l, client: client}, "smd"})
+	for _, sink := range q.Sinks {
+		sinks = append(sinks, stagedSink{sink, "sink"})
+	}
+
+	// send what could not be added to SMD last time before anything new
+	if q.OutboxPath != "" && !q.DryRun {
+		var sent int
+		headers, err := q.smdHeaders()
+		if err == nil {
+			sent, err = FlushOutbox(q.OutboxPath, client, headers)
+		}
+		if err != nil {
+			l.Log.Errorf("%v", err)
+		}
+		if sent > 0 {
+			l.Log.Infof("sent %d queued endpoint(s) from outbox to SMD", sent)
+		}
+	}
+
+	collectHost := func(ps ScannedResult) (c collectedHost) {
+		// copy params so each worker has its own host, port, and credentials
+		params := *q
+		params.Host = ps.Host
+		params.Port = ps.Port
+		params.limiter = limiter
+		params.onExpandFallback = func(uri string) {
+			l.Log.Warnf("BMC (%v) rejected $expand for '%s', retrying without it", ps.Host, uri)
+		}
+		q := &params
+
+		// the outcome of the host is recorded by the sink once done
+		c = collectedHost{
+			ps:     ps,
+			start:  time.Now(),
+			result: CollectResult{Host: ps.Host, Port: ps.Port, Success: true},
+		}
+		result := &c.result
+		q.progress(CollectEvent{Type: EVENT_HOST_START, Host: ps.Host, Port: ps.Port})
+
+		var err error
+
+		// get the credentials of the host from the provider or credential map
+		q.User, q.Pass, err = q.credentials(ps.Host)
+		if err != nil {
+			l.Log.Errorf("failed to get credentials (%v:%v): %v", q.Host, q.Port, err)
+			result.fail("credentials", err)
+			return
+		}
+
+		// every check and section shares this session so the service root is
+		// only requested and the BMC is only logged in to once per host
+		session := NewSession(l, q)
+		session.root = roots[net.JoinHostPort(ps.Host, fmt.Sprint(ps.Port))]
+		defer session.Close(ctx)
+
+		// make sure the host is a BMC before trying to collect from it
+		metrics.probed()
+		isBMC, err := checkServiceRoot(ctx, session)
+		if ctx.Err() != nil {
+			result.fail("connect", ctx.Err())
+			return
+		}
+		if err == nil && !isBMC {
+			l.Log.Warnf("host responded but is not a BMC (%v:%v)", q.Host, q.Port)
+			result.Success = false
+			result.NotBMC = true
+			return
+		}
+
+		// the vendor decides how the BMC is connected to
+		if q.VendorQuirks {
+			q.vendor, err = DetectVendor(ctx, session)
+			if err != nil {
+				l.Log.Warnf("failed to detect vendor (%v:%v): %v", q.Host, q.Port, err)
+			}
+		}
+
+		gofishClient, err := session.Redfish(ctx)
+		if err != nil {
+			var certErr *tls.CertificateVerificationError
+			if errors.As(err, &certErr) {
+				err = fmt.Errorf("TLS verification failed (set a CA with --ca-cert or disable --secure-tls): %v", certErr)
+			}
+			l.Log.Errorf("failed to connect to BMC (%v:%v): %v", q.Host, q.Port, err)
+			result.fail("connect", err)
+
+			// try to recover the BMC only when explicitly asked to and it did
+			// not respond at all (a reset does not help with bad credentials)
+			if q.ResetBMC != "" && ctx.Err() == nil && isUnreachable(err) {
+				bmcClient, err := NewClient(l, &QueryParams{
+					Host:           q.Host,
+					Port:           q.Port,
+					User:           q.User,
+					Pass:           q.Pass,
+					Drivers:        []string{"ipmi"},
+					Timeout:        q.Timeout,
+					ConnectTimeout: q.ConnectTimeout,
+					QueryTimeout:   q.QueryTimeout,
+					Transport:      q.Transport,
+					IpmitoolPath:   q.IpmitoolPath,
+					IpmiPort:       q.IpmiPort,
+				})
+				if err == nil {
+					err = ResetBMC(ctx, bmcClient, l, q, q.ResetBMC)
+				}
+				if err != nil {
+					l.Log.Errorf("failed to reset BMC (%v): %v", q.Host, err)
 				}
-				q.Host = ps.Host
-				q.Port = ps.Port
-
-				// generate custom xnames for bmcs
-				node := xnames.Node{
-					Cabinet:       1000,
-					Chassis:       1,
-					ComputeModule: 7,
-					NodeBMC:       offset,
+			}
+		}
+
+		fqdn := ps.Host
+		if q.ResolveFQDN {
+			fqdn = resolveFQDN(ctx, ps.Host, l, q)
+		}
+
+		// data to be sent to smd
+		data := map[string]any{
+			"Type":               "",
+			"Name":               "",
+			"FQDN":               fqdn,
+			"User":               q.User,
+			"MACRequired":        true,
+			"RediscoverOnUpdate": false,
+			"TLS": map[string]any{
+				"Trust": ClassifyCertTrust(session.capture.Chain(), caPool),
+			},
+		}
+
+		// vendor used to pick the quirks (empty when it is not known)
+		if q.VendorQuirks {
+			data["Vendor"] = q.vendor
+		}
+
+		// other addresses the same BMC was found at
+		if len(aliases[ps.Host]) > 0 {
+			data["Aliases"] = aliases[ps.Host]
+		}
+
+		// only share the password when asked to since it ends up on disk too
+		if q.IncludePassword {
+			data["Password"] = q.Pass
+		}
+
+		// name the BMC using the configured generator
+		xname, err := xnameGenerator.Generate(ps, data)
+		if err != nil {
+			l.Log.Errorf("failed to generate xname (%v:%v): %v", q.Host, q.Port, err)
+			result.fail("xname", err)
+			return
+		}
+		data["ID"] = xname
+		result.Xname = xname
+
+		// collect each section into the data keeping track of the ones that
+		// failed instead of giving up on the whole host
+		errs := map[string]string{}
+		collectSection := func(key string, collect func() (any, error)) (err error) {
+			start := time.Now()
+			defer func() {
+				metrics.sectionDone(key, time.Since(start), err)
+				event := CollectEvent{Type: EVENT_SECTION_DONE, Host: ps.Host, Port: ps.Port, Section: key}
+				if err != nil {
+					event.Error = err.Error()
 				}
-				offset += 1
+				q.progress(event)
+			}()
+
+			value, err := collect()
+			if err == nil && value != nil {
+				data[key] = value
+			}
+			if err != nil {
+				l.Log.Errorf("failed to collect %s (%v:%v): %v", key, q.Host, q.Port, err)
+				errs[key] = err.Error()
+				data[key] = nil
+			}
+			return err
+		}
 
-				gofishClient, err := connectGofish(q)
+		if gofishClient != nil {
+			result.Provider = "gofish"
+
+			// chassis
+			if q.wantsSection("Chassis") {
+				err = collectSection("Chassis", func() (any, error) { return collectChassis(gofishClient, l, q) })
 				if err != nil {
-					l.Log.Errorf("failed to connect to BMC (%v:%v): %v", q.Host, q.Port, err)
+					result.fail("chassis", err)
 				}
+			}
 
-				// data to be sent to smd
-				data := map[string]any{
-					"ID":   fmt.Sprintf("%v", node.String()[:len(node.String())-2]),
-					"Type": "",
-					"Name": "",
-					"FQDN": ps.Host,
-					"User": q.User,
-					// "Password":           q.Pass,
-					"MACRequired":        true,
-					"RediscoverOnUpdate": false,
+			// systems
+			if q.wantsSection("Systems") {
+				err = collectSection("Systems", func() (any, error) { return collectSystems(gofishClient, l, q) })
+				if err != nil {
+					result.fail("systems", err)
 				}
+			}
 
-				// unmarshal json to send in correct format
-				var rm map[string]json.RawMessage
+			// add other fields from the first system
+			if systems, ok := data["Systems"].([]map[string]any); ok && len(systems) > 0 {
+				if system, ok := systems[0]["Data"].(*redfish.ComputerSystem); ok {
+					data["Name"] = system.Name
+				}
+			}
 
-				// chassis
-				if gofishClient != nil {
-					chassis, err := CollectChassis(gofishClient, q)
-					if err != nil {
-						l.Log.Errorf("failed to collect chassis: %v", err)
-						continue
-					}
-					err = json.Unmarshal(chassis, &rm)
-					if err != nil {
-						l.Log.Errorf("failed to unmarshal chassis JSON: %v", err)
-					}
-					data["Chassis"] = rm["Chassis"]
+			// optional sections in the order requested or the default order
+			for _, name := range q.sectionNames() {
+				section := sectionsByName[strings.ToLower(name)]
+				collectSection(section.Key, func() (any, error) { return section.Collect(ctx, gofishClient, l, q) })
 
-					// systems
-					systems, err := CollectSystems(gofishClient, q)
+				// compare the clock of the BMC right after reading it
+				if managers, ok := data["Managers"].([]map[string]any); ok && section.Key == "Managers" {
+					skew, err := ManagersClockSkew(managers, q.now())
 					if err != nil {
-						l.Log.Errorf("failed to collect systems: %v", err)
+						l.Log.Debugf("failed to get clock skew (%v:%v): %v", q.Host, q.Port, err)
+					} else {
+						data["ClockSkewSeconds"] = skew
+						result.ClockSkewSeconds = &skew
 					}
-					err = json.Unmarshal(systems, &rm)
-					if err != nil {
-						l.Log.Errorf("failed to unmarshal system JSON after collect: %v", err)
-					}
-					data["Systems"] = rm["Systems"]
-
-					// add other fields from systems
-					if len(rm["Systems"]) > 0 {
-						var s map[string][]any
-						fmt.Printf("Systems before unmarshaling: %v\n", string(rm["Systems"]))
-						err = json.Unmarshal(rm["Systems"], &s)
-						if err != nil {
-							l.Log.Errorf("failed to unmarshal systems JSON: %v", err)
-						}
-						data["Name"] = s["Name"]
-					}
-				} else {
-					l.Log.Errorf("invalid client (client is nil)")
-					continue
 				}
+			}
+
+			if len(errs) > 0 {
+				data["Errors"] = errs
+				result.Errors = errs
+			}
+		} else {
+			l.Log.Errorf("invalid client (client is nil)")
+			return
+		}
+
+		c.data = data
+		c.pass = q.Pass
+		return
+	}
+
+	// write and submit the collected data one host at a time; workers block
+	// once the buffer is full so a slow sink cannot pile up data in memory
+	submitHost := func(c collectedHost) {
+		// the hosts still running past the deadline were already reported
+		// as timed out so nothing more is written or reported for them
+		mu.Lock()
+		skip := abandoned
+		mu.Unlock()
+		if skip {
+			return
+		}
+
+		var (
+			ps     = c.ps
+			data   = c.data
+			result = &c.result
+		)
+		result.Elapsed = time.Since(c.start)
+		defer func() {
+			// only keep the payload when the whole fleet was loaded anyway
+			kept := *result
+			kept.smdPayload = nil
+			if probeStates == nil {
+				kept.Payload = nil
+			}
+			mu.Lock()
+			if !abandoned {
+				results = append(results, kept)
+			}
+			delete(pending, ps.Host)
+			mu.Unlock()
+			metrics.hostDone(*result)
+			done := *result
+			q.progress(CollectEvent{Type: EVENT_HOST_DONE, Host: ps.Host, Port: ps.Port, Error: result.Error, Result: &done})
+		}()
+		if data == nil {
+			return
+		}
+
+		body, err := q.marshalOutput(data)
+		if err != nil {
+			l.Log.Errorf("failed to marshal output to JSON: %v", err)
+			result.fail("marshal", err)
+			return
+		}
+		result.Payload = body
+
+		// SMD needs the password to reach the BMC even when it is left out of
+		// everything else
+		result.smdPayload = body
+		if _, ok := data["Password"]; !ok && c.pass != "" {
+			data["Password"] = c.pass
+			result.smdPayload, err = q.marshalOutput(data)
+			delete(data, "Password")
+			if err != nil {
+				l.Log.Errorf("failed to marshal SMD payload to JSON: %v", err)
+				result.fail("marshal", err)
+				return
+			}
+		}
+
+		// only write the output files (if touching) and other sinks when the
+		// data is the same as the last run
+		stateKey := net.JoinHostPort(ps.Host, fmt.Sprint(ps.Port))
+		unchanged := stateCache != nil && !stateCache.Changed(stateKey, body)
+		if unchanged {
+			l.Log.Infof("data has not changed since the last run (%v), skipped adding to SMD", ps.Host)
+		}
+
+		for _, sink := range sinks {
+			if unchanged && (sink.stage == "smd" || sink.stage == "write" && !q.TouchUnchanged) {
+				continue
+			}
+			err = sink.Write(*result)
+			if err != nil {
+				l.Log.Errorf("failed to write %s output (%v): %v", sink.stage, ps.Host, err)
+				result.fail(sink.stage, err)
+			}
+		}
+
+		// make sure a host that failed to be submitted is retried next time
+		if stateCache != nil && !result.Success {
+			stateCache.Forget(stateKey)
+		}
+
+		// keep the data around to export as CSV after collecting
+		if q.SmdCsvPath != "" {
+			mu.Lock()
+			records = append(records, data)
+			mu.Unlock()
+		}
+	}
+	go func() {
+		for c := range chanResults {
+			submitting.Lock()
+			submitHost(c)
+			submitting.Unlock()
+		}
+		close(sinkDone)
+	}()
+
+	wg.Add(concurrency)
+	for i := 0; i < concurrency; i++ {
+		go func() {
+			for {
+				ps, ok := <-chanProbeState
+				if !ok {
+					wg.Done()
+					return
+				}
+
+				// drain the remaining hosts without collecting once cancelled
+				if ctx.Err() != nil {
+					continue
+				}
+
+				// share the available slots with other collections if needed
+				if q.sem != nil {
+					q.sem <- struct{}{}
+				}
+				metrics.workerStarted()
+				c := collectHost(ps)
+				metrics.workerDone()
+				chanResults <- c
+				if q.sem != nil {
+					<-q.sem
+				}
+			}
+		}()
+	}
+
+	// use the found results to query bmc information
+	for {
+		// stop handing out hosts once cancelled (even while waiting on the stream)
+		var (
+			ps ScannedResult
+			ok bool
+		)
+		select {
+		case ps, ok = <-stream:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			l.Log.Warnf("collection cancelled: %v", ctx.Err())
+			break
+		}
+		if !ok {
+			break
+		}
+
+		// only collect from each host once even if found on multiple ports
+		// (only this goroutine touches the map so no lock is needed)
+		if !ps.State || dispatched[ps.Host] {
+			continue
+		}
+		if cooldown != nil && !q.ForceRetry && cooldown.InCooldown(ps.Host, q.Cooldown) {
+			l.Log.Debugf("skipping host '%s' in cooldown (%s)", ps.Host, cooldown.Entries[ps.Host].Reason)
+			continue
+		}
+		dispatched[ps.Host] = true
+		if reserver, ok := xnameGenerator.(XnameReserver); ok {
+			reserver.Reserve(ps.Host)
+		}
+		mu.Lock()
+		pending[ps.Host] = ps
+		mu.Unlock()
+		select {
+		case chanProbeState <- ps:
+		case <-ctx.Done():
+			mu.Lock()
+			delete(pending, ps.Host)
+			mu.Unlock()
+		}
+	}
+
+	// handle goroutine paths
+	go func() {
+		select {
+		case <-done:
+			wg.Done()
+			break
+		default:
+			time.Sleep(1000)
+		}
+	}()
+
+	close(chanProbeState)
+	finished := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+		close(chanResults)
+		<-sinkDone
+		close(finished)
+	}()
+
+	// wait for every host unless past the deadline in which case the hosts
+	// still being collected are given up on and marked as timed out
+	select {
+	case <-finished:
+	case <-globalDeadline(ctx, q):
+		// let the host being written finish so the sinks are not used while
+		// they are flushed below
+		submitting.Lock()
+		defer submitting.Unlock()
+		mu.Lock()
+		abandoned = true
+		for _, host := range util.SortedKeys(pending) {
+			ps := pending[host]
+			l.Log.Errorf("timed out collecting from BMC (%v:%v)", ps.Host, ps.Port)
+			results = append(results, CollectResult{
+				Host:    ps.Host,
+				Port:    ps.Port,
+				Stage:   "timeout",
+				Error:   fmt.Sprintf("did not finish within %v", q.GlobalTimeout),
+				Elapsed: time.Since(start),
+			})
+			done := results[len(results)-1]
+			q.progress(CollectEvent{Type: EVENT_HOST_DONE, Host: ps.Host, Port: ps.Port, Error: done.Error, Result: &done})
+		}
+		mu.Unlock()
+	}
+
+	// send anything the sinks are still holding on to
+	for _, sink := range sinks {
+		if flusher, ok := sink.OutputSink.(OutputFlusher); ok {
+			err = flusher.Flush()
+			if err != nil {
+				l.Log.Errorf("failed to flush %s output: %v", sink.stage, err)
+			}
+		}
+	}
+
+	// the stores are saved and the reports written once every target is done
+	if q.shared != nil {
+		q.shared.add(results, records)
+	} else {
+		err = finishCollection(l, q, cooldown, stateCache, results, records, time.Since(start))
+		if err != nil {
+			return results, err
+		}
+	}
+
+	if ctx.Err() != nil {
+		return results, fmt.Errorf("collection cancelled: %v", ctx.Err())
+	}
+	return results, nil
+}
+
+// finishCollection saves the cooldown store and state cache (if any) and
+// writes the reports of every host collected once a run is done.
+func finishCollection(l *log.Logger, q *QueryParams, cooldown *CooldownStore, stateCache *StateCache, results []CollectResult, records []map[string]any, elapsed time.Duration) error {
+	var err error
+
+	// remember which hosts failed for the next run
+	if cooldown != nil {
+		cooldown.Update(results)
+		err = cooldown.Save()
+		if err != nil {
+			l.Log.Errorf("failed to save cooldown store: %v", err)
+		}
+	}
+
+	// remember what was collected for the next run (unless nothing was sent
+	// to SMD so the next real run does not skip the hosts)
+	if stateCache != nil && !q.DryRun {
+		err = stateCache.Save()
+		if err != nil {
+			l.Log.Errorf("failed to save state cache: %v", err)
+		}
+	}
+
+	// show which drivers were used to collect across the fleet
+	providers := CountProviders(results)
+	for _, name := range util.SortedKeys(providers) {
+		l.Log.Infof("driver usage: %s=%d", name, providers[name])
+	}
+
+	// show how the run went overall
+	summary := Summarize(results, elapsed)
+	l.Log.Infof("summary: attempted=%d succeeded=%d failed=%d not_bmc=%d elapsed=%v",
+		summary.Attempted, summary.Succeeded, summary.Failed, summary.NotBMC, summary.Elapsed.Round(time.Millisecond))
+	for _, stage := range util.SortedKeys(summary.FailedStages) {
+		l.Log.Infof("summary: failed stage %s=%d", stage, summary.FailedStages[stage])
+	}
+	if q.MaxClockSkew > 0 {
+		summary.ClockSkewed = ClockSkewed(results, q.MaxClockSkew)
+		if len(summary.ClockSkewed) > 0 {
+			l.Log.Warnf("summary: clock skewed by more than %v: %s", q.MaxClockSkew, strings.Join(summary.ClockSkewed, ", "))
+		}
+	}
+	for _, section := range util.SortedKeys(summary.FailedSections) {
+		l.Log.Infof("summary: failed section %s=%d", section, summary.FailedSections[section])
+	}
+	if len(summary.Unreachable) > 0 {
+		l.Log.Infof("summary: unreachable hosts: %s", strings.Join(summary.Unreachable, ", "))
+	}
+	if q.SummaryPath != "" {
+		err = WriteSummaryFile(q.SummaryPath, summary)
+		if err != nil {
+			return err
+		}
+	}
+
+	// write a report of every host for CI pipelines
+	if q.JUnitPath != "" {
+		err = WriteJUnitReportFile(q.JUnitPath, results)
+		if err != nil {
+			return fmt.Errorf("failed to write JUnit report: %v", err)
+		}
+	}
+
+	// export data in format for SMD bulk import
+	if q.SmdCsvPath != "" {
+		missing, err := WriteSmdCsvFile(q.SmdCsvPath, records)
+		if err != nil {
+			return fmt.Errorf("failed to write SMD CSV: %v", err)
+		}
+		for _, host := range missing {
+			l.Log.Warnf("host '%s' is missing mandatory fields for SMD import", host)
+		}
+	}
+
+	// map of the BMC MAC addresses to hosts for DHCP
+	if q.MACMapPath != "" {
+		err = WriteMACMappingFile(q.MACMapPath, results)
+		if err != nil {
+			return err
+		}
+	}
+
+	// flat summary of every host for spreadsheets
+	if q.InventoryCsvPath != "" {
+		err = WriteInventoryCsvFile(q.InventoryCsvPath, results)
+		if err != nil {
+			return fmt.Errorf("failed to write inventory CSV: %v", err)
+		}
+	}
+	return nil
+}
+
+// CollectMetadata returns the metadata of the bmclib providers opened for
+// the session (i.e. which ones connected successfully).
+func CollectMetadata(ctx context.Context, s *Session) ([]byte, error) {
+	client, err := s.BMC(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// retrieve inventory data
+	b, err := json.MarshalIndent(client.GetMetadata(), "", "    ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal JSON: %v", err)
+	}
+
+	return b, nil
+}
+
+func CollectInventory(ctx context.Context, s *Session) ([]byte, error) {
+	client, err := s.BMC(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, ctxCancel := context.WithTimeout(ctx, s.q.queryTimeout())
+	defer ctxCancel()
+	inventory, err := client.PreferProvider(s.q.preferredProvider("Inventory")).Inventory(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get inventory: %v", err)
+	}
+
+	// retrieve inventory data
+	data := map[string]any{"Inventory": inventory}
+	b, err := json.MarshalIndent(data, "", "    ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal JSON: %v", err)
+	}
+
+	return b, nil
+}
+
+func CollectPowerState(ctx context.Context, s *Session) ([]byte, error) {
+	client, err := s.BMC(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, ctxCancel := context.WithTimeout(ctx, s.q.queryTimeout())
+	defer ctxCancel()
+	powerState, err := client.PreferProvider(s.q.preferredProvider("PowerState")).GetPowerState(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get power state: %v", err)
+	}
+
+	// retrieve inventory data
+	data := map[string]any{"PowerState": powerState}
+	b, err := json.MarshalIndent(data, "", "    ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal JSON: %v", err)
+	}
+
+	return b, nil
+}
+
+func CollectUsers(ctx context.Context, s *Session) ([]byte, error) {
+	client, err := s.BMC(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, ctxCancel := context.WithTimeout(ctx, s.q.queryTimeout())
+	defer ctxCancel()
+	users, err := client.PreferProvider(s.q.preferredProvider("Users")).ReadUsers(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get users: %v", err)
+	}
+
+	// retrieve inventory data
+	data := map[string]any{"Users": users}
+	b, err := json.MarshalIndent(data, "", "    ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal JSON: %v", err)
+	}
+
+	return b, nil
+}
+
+func CollectBios(ctx context.Context, s *Session) ([]byte, error) {
+	client, err := s.BMC(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return makeRequest(ctx, client.PreferProvider(s.q.preferredProvider("Bios")).GetBiosConfiguration, s.q)
+}
+
+// CollectEthernetInterfaces returns the ethernet interfaces of the managers
+// (the BMC's own NICs) followed by the ones of each system. Only the
+// interfaces of the system with systemID are returned if set (without the
+// ones of the managers). Interfaces found in more than one place are only
+// listed once by MAC address. The collections to read can be replaced with
+// q.EthernetPaths for BMCs that keep them somewhere else.
+func CollectEthernetInterfaces(c *gofish.APIClient, l *log.Logger, q *QueryParams, systemID string) ([]byte, error) {
+	value, err := collectEthernetInterfaces(c, l, q, systemID)
+	if err != nil {
+		return nil, err
+	}
+	return marshalSection("EthernetInterfaces", value)
+}
+
+func collectEthernetInterfaces(c *gofish.APIClient, l *log.Logger, q *QueryParams, systemID string) (any, error) {
+	// TODO: add more endpoints to test for ethernet interfaces
+	// /redfish/v1/Chassis/{ChassisID}/NetworkAdapters/{NetworkAdapterId}/NetworkDeviceFunctions/{NetworkDeviceFunctionId}/EthernetInterfaces/{EthernetInterfaceId}
+	// /redfish/v1/Systems/{ComputerSystemId}/OperatingSystem/Containers/EthernetInterfaces/{EthernetInterfaceId}
+	l.Log.Debugf("querying ethernet interfaces (%v:%v)", q.Host, q.Port)
+	paths := q.EthernetPaths
+	if len(paths) <= 0 {
+		// the managers are not part of any one system
+		if systemID == "" {
+			managers, err := c.Service.Managers()
+			if err != nil {
+				return nil, fmt.Errorf("failed to get managers (%v:%v): %v", q.Host, q.Port, err)
+			}
+			for _, manager := range managers {
+				paths = append(paths, manager.ODataID+"/EthernetInterfaces")
+			}
+		}
+
+		systems, err := c.Service.Systems()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get systems (%v:%v): %v", q.Host, q.Port, err)
+		}
+		for _, system := range systems {
+			if systemID != "" && system.ID != systemID {
+				continue
+			}
+			paths = append(paths, system.ODataID+"/EthernetInterfaces")
+		}
+	}
+
+	var (
+		interfaces = []*redfish.EthernetInterface{}
+		macs       = map[string]bool{}
+		errList    []error
+	)
+	for _, path := range paths {
+		eths, err := redfish.ListReferencedEthernetInterfaces(c, path)
+		if err != nil {
+			l.Log.Debugf("failed to get ethernet interfaces at '%s' (%v:%v): %v", path, q.Host, q.Port, err)
+			errList = append(errList, err)
+		}
+		for _, eth := range eths {
+			mac := NormalizeMAC(eth.MACAddress)
+			if mac != "" {
+				if macs[mac] {
+					continue
+				}
+				macs[mac] = true
+			}
+			interfaces = append(interfaces, eth)
+		}
+	}
+
+	// only give up when nothing could be found at all
+	if len(interfaces) <= 0 && len(errList) > 0 {
+		return nil, fmt.Errorf("failed to get ethernet interfaces with %d error(s): %w", len(errList), errors.Join(errList...))
+	}
+
+	return interfaces, nil
+}
+
+// NormalizeMAC returns the MAC address in lowercase and separated by colons
+// (i.e. "AA-BB-CC-DD-EE-FF" -> "aa:bb:cc:dd:ee:ff") or an empty string when
+// it is not a valid MAC address.
+func NormalizeMAC(mac string) string {
+	hw, err := net.ParseMAC(strings.TrimSpace(mac))
+	if err != nil {
+		return ""
+	}
+	return hw.String()
+}
+
+func CollectChassis(c *gofish.APIClient, l *log.Logger, q *QueryParams) ([]byte, error) {
+	chassis, err := collectChassis(c, l, q)
+	if err != nil {
+		return nil, err
+	}
+	return marshalSection("Chassis", chassis)
+}
+
+func collectChassis(c *gofish.APIClient, l *log.Logger, q *QueryParams) (any, error) {
+	l.Log.Debugf("querying chassis (%v:%v)", q.Host, q.Port)
+	chassis, err := c.Service.Chassis()
+	if err != nil {
+		return nil, fmt.Errorf("failed to query chassis (%v:%v): %v", q.Host, q.Port, err)
+	}
+
+	// some BMCs have no chassis so write an empty list instead of null
+	if len(chassis) == 0 {
+		l.Log.Infof("BMC has no chassis (%v:%v)", q.Host, q.Port)
+		return []*redfish.Chassis{}, nil
+	}
+	return chassis, nil
+}
+
+func CollectStorage(c *gofish.APIClient, l *log.Logger, q *QueryParams) ([]byte, error) {
+	storage, err := collectStorage(c, l, q)
+	if err != nil {
+		return nil, err
+	}
+	return marshalSection("Storage", storage)
+}
+
+func collectStorage(c *gofish.APIClient, l *log.Logger, q *QueryParams) (any, error) {
+	l.Log.Debugf("querying storage (%v:%v)", q.Host, q.Port)
+	systems, err := c.Service.StorageSystems()
+	if err != nil {
+		return nil, fmt.Errorf("failed to query storage systems (%v:%v): %v", q.Host, q.Port, err)
+	}
+
+	services, err := c.Service.StorageServices()
+	if err != nil {
+		return nil, fmt.Errorf("failed to query storage services (%v:%v): %v", q.Host, q.Port, err)
+	}
+
+	return map[string]any{
+		"Systems":  systems,
+		"Services": services,
+	}, nil
+}
+
+func CollectDrives(c *gofish.APIClient, l *log.Logger, q *QueryParams) ([]byte, error) {
+	drives, err := collectDrives(c, l, q)
+	if err != nil {
+		return nil, err
+	}
+	return marshalSection("Drives", drives)
+}
+
+// collectDrives walks each system's storage subsystems and returns the
+// details of every drive found. BMCs that do not populate the storage or
+// drive collections simply produce an empty list instead of an error.
+func collectDrives(c *gofish.APIClient, l *log.Logger, q *QueryParams) (any, error) {
+	l.Log.Debugf("querying drives (%v:%v)", q.Host, q.Port)
+	systems, err := c.Service.Systems()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get systems (%v:%v): %v", q.Host, q.Port, err)
+	}
+
+	drives := []map[string]any{}
+	for _, system := range systems {
+		storages, err := system.Storage()
+		if err != nil {
+			l.Log.Warnf("skipping drives of system %s (%v:%v): %v", system.ID, q.Host, q.Port, err)
+			continue
+		}
+		for _, storage := range storages {
+			// some BMCs do not populate drives so skip instead of failing
+			ds, err := storage.Drives()
+			if err != nil {
+				l.Log.Debugf("skipping drives of storage %s (%v:%v): %v", storage.ID, q.Host, q.Port, err)
+				continue
+			}
+			for _, d := range ds {
+				if d == nil {
+					continue
+				}
+				drives = append(drives, map[string]any{
+					"System":        system.ID,
+					"Storage":       storage.ID,
+					"ID":            d.ID,
+					"Name":          d.Name,
+					"Manufacturer":  d.Manufacturer,
+					"Model":         d.Model,
+					"SerialNumber":  d.SerialNumber,
+					"Revision":      d.Revision,
+					"CapacityBytes": d.CapacityBytes,
+					"Protocol":      d.Protocol,
+					"MediaType":     d.MediaType,
+					"Health":        d.Status.Health,
+					"State":         d.Status.State,
+				})
+			}
+		}
+	}
+	return drives, nil
+}
+
+func CollectSystems(c *gofish.APIClient, l *log.Logger, q *QueryParams) ([]byte, error) {
+	value, err := collectSystems(c, l, q)
+	if err != nil {
+		return nil, err
+	}
+	return marshalSection("Systems", value)
+}
+
+func collectSystems(c *gofish.APIClient, l *log.Logger, q *QueryParams) (any, error) {
+	l.Log.Debugf("querying systems (%v:%v)", q.Host, q.Port)
+	systems, err := c.Service.Systems()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get systems (%v:%v): %v", q.Host, q.Port, err)
+	}
+
+	// an aggregating BMC gives access to the systems of other nodes so each
+	// of them is recorded on its own with the node it belongs to
+	nodes, uris, err := aggregationSources(c, l, q)
+	if err != nil {
+		return nil, err
+	}
+	listed := make(map[string]bool, len(systems))
+	for _, system := range systems {
+		listed[strings.TrimSuffix(system.ODataID, "/")] = true
+	}
+	for _, uri := range uris {
+		if listed[uri] {
+			continue
+		}
+		system, err := redfish.GetComputerSystem(c, uri)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get aggregated system '%s' (%v:%v): %v", uri, q.Host, q.Port, err)
+		}
+		systems = append(systems, system)
+	}
+	if len(systems) == 0 {
+		l.Log.Infof("BMC has no systems (%v:%v)", q.Host, q.Port)
+	}
+
+	// 1. check if system has ethernet interfaces
+	// 1.a. if yes, create system data and ethernet interfaces JSON
+	// 1.b. if no, try to get data using manager instead
+	// 2. check if manager has "ManagerForServices" and "EthernetInterfaces" properties
+	// 2.a. if yes, query both properties to use in next step
+	// 2.b. for each service, query its data and add the ethernet interfaces
+	// 2.c. add the system to list of systems to marshal and return
+	var (
+		temp = []map[string]any{} // empty instead of null when there are no systems
+		eths []*redfish.EthernetInterface
+	)
+
+	for _, system := range systems {
+		eths, err = system.EthernetInterfaces()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get system ethernet interfaces: %v", err)
+		}
+
+		// try and get ethernet interfaces through manager if empty
+		if len(eths) <= 0 {
+			l.Log.Debugf("no ethernet interfaces found for system '%s' (%v:%v), trying managers instead", system.ID, q.Host, q.Port)
+			for _, managerLink := range system.ManagedBy {
+				// try getting ethernet interface from all managers until one is found
+				eths, err = redfish.ListReferencedEthernetInterfaces(c, managerLink+"/EthernetInterfaces")
+				if err != nil {
+					return nil, fmt.Errorf("failed to get system manager ethernet interfaces: %v", err)
+				}
+				if len(eths) > 0 {
+					break
+				}
+			}
+		}
+
+		// add system to collection of systems
+		entry := map[string]any{
+			"Data":               system,
+			"EthernetInterfaces": eths,
+		}
+		if node, ok := nodes[strings.TrimSuffix(system.ODataID, "/")]; ok {
+			entry["AggregationSource"] = node
+		}
+		temp = append(temp, entry)
+	}
+
+	// do manual requests if systems is empty to only get necessary info as last resort
+	// /redfish/v1/Systems
+
+	// /redfish/v1/Systems/Members
+	// /redfish/v1/Systems/
+	// fmt.Printf("system count: %d\n", len(systems))
+	// if len(systems) == 0 {
+	// 	url := baseRedfishUrl(q) + "/Systems"
+	// 	if q.Verbose {
+	// 		fmt.Printf("%s\n", url)
+	// 	}
+	// 	res, body, err := util.MakeRequest(nil, url, "GET", nil, nil)
+	// 	if err != nil {
+	// 		return nil, fmt.Errorf("failed to make request: %v", err)
+	// 	} else if res.StatusCode != http.StatusOK {
+	// 		return nil, fmt.Errorf("request returned status code %d", res.StatusCode)
+	// 	}
+
+	// 	// sweet syntatic sugar type aliases
+	// 	type System = map[string]any
+	// 	type Member = map[string]string
+
+	// 	// get all the systems
+	// 	var (
+	// 		tempSystems System
+	// 		interfaces  []*redfish.EthernetInterface
+	// 		errList     []error
+	// 	)
+	// 	err = json.Unmarshal(body, &tempSystems)
+	// 	if err != nil {
+	// 		return nil, fmt.Errorf("failed to unmarshal systems: %v", err)
+	// 	}
+
+	// 	// then, get all the members within a system
+	// 	members, ok := tempSystems["Members"]
+	// 	if ok {
+	// 		for _, member := range members.([]Member) {
+	// 			id, ok := member["@odata.id"]
+	// 			if ok {
+	// 				// /redfish/v1/Systems/Self (or whatever)
+	// 				// memberEndpoint := fmt.Sprintf("%s%s", url, id)
+	// 				// res, body, err := util.MakeRequest(nil, baseRedfishUrl(q)+memberEndpoint, http.MethodGet, nil, nil)
+	// 				// if err != nil {
+	// 				// 	continue
+	// 				// } else if res.StatusCode != http.StatusOK {
+	// 				// 	continue
+	// 				// }
+	// 				// TODO: extract EthernetInterfaces from Systems then query
+
+	// 				// get all of the ethernet interfaces in our systems
+	// 				ethernetInterface, err := redfish.ListReferencedEthernetInterfaces(c, id+"/EthernetInterfaces/")
+	// 				if err != nil {
+	// 					errList = append(errList, err)
+	// 					continue
+	// 				}
+	// 				interfaces = append(interfaces, ethernetInterface...)
+	// 			} else {
+	// 				return nil, fmt.Errorf("no ID found for member")
+	// 			}
+	// 			if util.HasErrors(errList) {
+	// 				return nil, util.FormatErrorList(errList)
+	// 			}
+	// 		}
+	// 		i, err := json.Marshal(interfaces)
+	// 		if err != nil {
+	// 			return nil, fmt.Errorf("failed to unmarshal interface: %v", err)
+	// 		}
+	// 		temp = append(temp, map[string]any{
+	// 			"Data":               nil,
+	// 			"EthernetInterfaces": string(i),
+	// 		})
+	// 	} else {
+	// 		return nil, fmt.Errorf("no members found in systems")
+	// 	}
+
+	// } else {
+	// 	b, err := json.Marshal(systems)
+	// 	if err != nil {
+	// 		fmt.Printf("failed to marshal systems: %v", err)
+	// 	}
+	// 	fmt.Printf("systems: %v\n", string(b))
+
+	// 	// query the system's ethernet interfaces
+	// 	// var temp []map[string]any
+	// 	var errList []error
+	// 	for _, system := range systems {
+	// 		interfaces, err := CollectEthernetInterfaces(c, q, system.ID)
+	// 		if err != nil {
+	// 			errList = append(errList, fmt.Errorf("failed to collect ethernet interface: %v", err))
+	// 			continue
+	// 		}
+	// 		var i map[string]any
+	// 		err = json.Unmarshal(interfaces, &i)
+	// 		if err != nil {
+	// 			return nil, fmt.Errorf("failed to unmarshal interface: %v", err)
+	// 		}
+	// 		temp = append(temp, map[string]any{
+	// 			"Data":               system,
+	// 			"EthernetInterfaces": i["EthernetInterfaces"],
+	// 		})
+	// 	}
+	// 	if util.HasErrors(errList) {
+	// 		err = util.FormatErrorList(errList)
+	// 		if err != nil {
+	// 			return nil, fmt.Errorf("multiple errors occurred: %v", err)
+	// 		}
+	// 	}
+	// }
+
+	return temp, nil
+}
+
+// CollectPowerSubsystem reads the power supplies of each chassis from the
+// newer PowerSubsystem resource when the chassis links to one, otherwise the
+// deprecated Power resource is used. Both are normalized into the same shape.
+func CollectPowerSubsystem(c *gofish.APIClient, q *QueryParams) ([]byte, error) {
+	value, err := collectPowerSubsystem(c, q)
+	if err != nil {
+		return nil, err
+	}
+	return marshalSection("PowerSubsystem", value)
+}
+
+func collectPowerSubsystem(c *gofish.APIClient, q *QueryParams) (any, error) {
+	chassis, err := c.Service.Chassis()
+	if err != nil {
+		return nil, fmt.Errorf("failed to query chassis (%v:%v): %v", q.Host, q.Port, err)
+	}
+
+	subsystems := []map[string]any{}
+	for _, ch := range chassis {
+		// check which power resource the chassis links to
+		var links struct {
+			Power          common.Link
+			PowerSubsystem common.Link
+		}
+		err = getRaw(c, ch.ODataID, &links)
+		if err != nil {
+			return nil, err
+		}
+
+		var (
+			source   string
+			supplies []*redfish.PowerSupply
+		)
+		if links.PowerSubsystem.String() != "" {
+			subsystem, err := redfish.GetPowerSubsystem(c, links.PowerSubsystem.String())
+			if err != nil {
+				return nil, fmt.Errorf("failed to get power subsystem for chassis '%s': %v", ch.ID, err)
+			}
+			supplies, err = subsystem.PowerSupplies()
+			if err != nil {
+				return nil, fmt.Errorf("failed to get power supplies for chassis '%s': %v", ch.ID, err)
+			}
+			source = "PowerSubsystem"
+		} else if links.Power.String() != "" {
+			power, err := ch.Power()
+			if err != nil {
+				return nil, fmt.Errorf("failed to get power for chassis '%s': %v", ch.ID, err)
+			}
+			for i := range power.PowerSupplies {
+				supplies = append(supplies, &power.PowerSupplies[i])
+			}
+			source = "Power"
+		} else {
+			continue
+		}
+
+		psus := make([]map[string]any, 0, len(supplies))
+		for _, psu := range supplies {
+			psus = append(psus, map[string]any{
+				"Name":               psu.Name,
+				"Manufacturer":       psu.Manufacturer,
+				"Model":              psu.Model,
+				"SerialNumber":       psu.SerialNumber,
+				"FirmwareVersion":    psu.FirmwareVersion,
+				"PowerCapacityWatts": psu.PowerCapacityWatts,
+				"PowerOutputWatts":   psu.PowerOutputWatts,
+				"Health":             psu.Status.Health,
+				"State":              psu.Status.State,
+			})
+		}
+		subsystems = append(subsystems, map[string]any{
+			"Chassis":       ch.ID,
+			"Source":        source,
+			"Count":         len(psus),
+			"PowerSupplies": psus,
+		})
+	}
+
+	return subsystems, nil
+}
+
+// CollectOem captures the raw "Oem" sections of the systems, chassis, and
+// managers that gofish drops when unmarshalling into its typed structs. The
+// sections are keyed by the resource's "@odata.id".
+func CollectOem(c *gofish.APIClient, q *QueryParams) ([]byte, error) {
+	value, err := collectOem(c, q)
+	if err != nil {
+		return nil, err
+	}
+	return marshalSection("Oem", value)
+}
 
-				headers := make(map[string]string)
-				headers["Content-Type"] = "application/json"
+func collectOem(c *gofish.APIClient, q *QueryParams) (any, error) {
+	var resources []string
 
-				// use access token in authorization header if we have it
-				if q.AccessToken != "" {
-					headers["Authorization"] = "Bearer " + q.AccessToken
-				}
+	systems, err := c.Service.Systems()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get systems (%v:%v): %v", q.Host, q.Port, err)
+	}
+	for _, system := range systems {
+		resources = append(resources, system.ODataID)
+	}
 
-				body, err := json.MarshalIndent(data, "", "    ")
-				if err != nil {
-					l.Log.Errorf("failed to marshal output to JSON: %v", err)
-				}
+	chassis, err := c.Service.Chassis()
+	if err != nil {
+		return nil, fmt.Errorf("failed to query chassis (%v:%v): %v", q.Host, q.Port, err)
+	}
+	for _, ch := range chassis {
+		resources = append(resources, ch.ODataID)
+	}
 
-				if q.Verbose {
-					fmt.Printf("%v\n", string(body))
-				}
+	managers, err := c.Service.Managers()
+	if err != nil {
+		return nil, fmt.Errorf("failed to query managers (%v:%v): %v", q.Host, q.Port, err)
+	}
+	for _, manager := range managers {
+		resources = append(resources, manager.ODataID)
+	}
 
-				// write JSON data to file if output path is set
-				if outputPath != "" {
-					err = os.WriteFile(path.Clean(outputPath+"/"+q.Host+".json"), body, os.ModePerm)
-					if err != nil {
-						l.Log.Errorf("failed to write data to file: %v", err)
-					}
-				}
+	oem := map[string]json.RawMessage{}
+	for _, resource := range resources {
+		var raw map[string]json.RawMessage
+		err = getRaw(c, resource, &raw)
+		if err != nil {
+			return nil, err
+		}
+		if section, ok := raw["Oem"]; ok {
+			oem[resource] = section
+		}
+	}
 
-				// add all endpoints to smd
-				err = client.AddRedfishEndpoint(body, headers)
-				if err != nil {
-					l.Log.Error(err)
-
-					// try updating instead
-					if q.ForceUpdate {
-						err = client.UpdateRedfishEndpoint(data["ID"].(string), body, headers)
-						if err != nil {
-							l.Log.Error(err)
-						}
-					}
-				}
+	return oem, nil
+}
 
-				// got host information, so add to list of already probed hosts
-				found = append(found, ps.Host)
-			}
-		}()
+// CollectRedundancy summarizes the fan and power supply redundancy reported
+// in the Thermal and Power resources of each chassis into simple health flags.
+// Each domain is "OK" when all of its redundancy groups are healthy,
+// "Degraded" when any group is not, and "Unknown" when nothing is reported.
+func CollectRedundancy(c *gofish.APIClient, q *QueryParams) ([]byte, error) {
+	value, err := collectRedundancy(c, q)
+	if err != nil {
+		return nil, err
 	}
+	return marshalSection("Redundancy", value)
+}
 
-	// use the found results to query bmc information
-	for _, ps := range *probeStates {
-		// skip if found info from host
-		foundHost := slices.Index(found, ps.Host)
-		if !ps.State || foundHost >= 0 {
-			continue
-		}
-		chanProbeState <- ps
+func collectRedundancy(c *gofish.APIClient, q *QueryParams) (any, error) {
+	chassis, err := c.Service.Chassis()
+	if err != nil {
+		return nil, fmt.Errorf("failed to query chassis (%v:%v): %v", q.Host, q.Port, err)
 	}
 
-	// handle goroutine paths
-	go func() {
-		select {
-		case <-done:
-			wg.Done()
-			break
-		default:
-			time.Sleep(1000)
+	var fans, psus []redundancyGroup
+	for _, ch := range chassis {
+		var links struct {
+			Thermal common.Link
+			Power   common.Link
 		}
-	}()
+		err = getRaw(c, ch.ODataID, &links)
+		if err != nil {
+			return nil, err
+		}
+		if links.Thermal.String() != "" {
+			groups, err := getRedundancyGroups(c, links.Thermal.String())
+			if err != nil {
+				return nil, err
+			}
+			fans = append(fans, groups...)
+		}
+		if links.Power.String() != "" {
+			groups, err := getRedundancyGroups(c, links.Power.String())
+			if err != nil {
+				return nil, err
+			}
+			psus = append(psus, groups...)
+		}
+	}
 
-	close(chanProbeState)
-	wg.Wait()
-	close(done)
+	return map[string]any{
+		"Fans":          summarizeRedundancy(fans),
+		"PowerSupplies": summarizeRedundancy(psus),
+	}, nil
+}
 
-	return nil
+type redundancyGroup struct {
+	ODataID string `json:"@odata.id,omitempty"`
+	Name    string
+	Mode    string
+	Status  common.Status
 }
 
-func CollectMetadata(client *bmclib.Client, q *QueryParams) ([]byte, error) {
-	// open BMC session and update driver registry
-	ctx, ctxCancel := context.WithTimeout(context.Background(), time.Second*time.Duration(q.Timeout))
-	client.Registry.FilterForCompatible(ctx)
-	err := client.Open(ctx)
+// getRedundancyGroups returns the redundancy groups of a Thermal or Power
+// resource. Groups that are only included as links are requested separately.
+func getRedundancyGroups(c *gofish.APIClient, uri string) ([]redundancyGroup, error) {
+	var resource struct {
+		Redundancy []redundancyGroup
+	}
+	err := getRaw(c, uri, &resource)
 	if err != nil {
-		ctxCancel()
-		return nil, fmt.Errorf("failed to connect to bmc: %v", err)
+		return nil, err
+	}
+	for i, group := range resource.Redundancy {
+		if group.Status.Health == "" && group.ODataID != "" {
+			err = getRaw(c, group.ODataID, &resource.Redundancy[i])
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	return resource.Redundancy, nil
+}
+
+func summarizeRedundancy(groups []redundancyGroup) map[string]any {
+	status := "Unknown"
+	for _, group := range groups {
+		switch group.Status.Health {
+		case common.OKHealth:
+			if status == "Unknown" {
+				status = "OK"
+			}
+		case common.WarningHealth, common.CriticalHealth:
+			status = "Degraded"
+		}
 	}
+	return map[string]any{
+		"Status": status,
+		"Groups": groups,
+	}
+}
 
-	defer client.Close(ctx)
+// CollectTelemetry reads the latest metric reports from the telemetry service.
+// The number of metric values kept from each report is capped by
+// q.MaxMetricValues when set to a positive value.
+func CollectTelemetry(c *gofish.APIClient, q *QueryParams) ([]byte, error) {
+	value, err := collectTelemetry(c, q)
+	if err != nil {
+		return nil, err
+	}
+	return marshalSection("Telemetry", value)
+}
 
-	metadata := client.GetMetadata()
+func collectTelemetry(c *gofish.APIClient, q *QueryParams) (any, error) {
+	telemetry, err := c.Service.TelemetryService()
 	if err != nil {
-		ctxCancel()
-		return nil, fmt.Errorf("failed to get metadata: %v", err)
+		return nil, fmt.Errorf("failed to get telemetry service (%v:%v): %v", q.Host, q.Port, err)
 	}
 
-	// retrieve inventory data
-	b, err := json.MarshalIndent(metadata, "", "    ")
+	reports, err := telemetry.MetricReports()
 	if err != nil {
-		ctxCancel()
-		return nil, fmt.Errorf("failed to marshal JSON: %v", err)
+		return nil, fmt.Errorf("failed to get metric reports (%v:%v): %v", q.Host, q.Port, err)
 	}
 
-	ctxCancel()
-	return b, nil
+	temp := make([]map[string]any, 0, len(reports))
+	for _, report := range reports {
+		values := report.MetricValues
+		if q.MaxMetricValues > 0 && len(values) > q.MaxMetricValues {
+			values = values[:q.MaxMetricValues]
+		}
+		temp = append(temp, map[string]any{
+			"ID":           report.ID,
+			"Name":         report.Name,
+			"Timestamp":    report.Timestamp,
+			"MetricValues": values,
+			"Truncated":    len(values) < len(report.MetricValues),
+		})
+	}
+
+	return temp, nil
 }
 
-func CollectInventory(client *bmclib.Client, q *QueryParams) ([]byte, error) {
-	// open BMC session and update driver registry
-	ctx, ctxCancel := context.WithTimeout(context.Background(), time.Second*time.Duration(q.Timeout))
-	client.Registry.FilterForCompatible(ctx)
-	err := client.PreferProvider(q.Preferred).Open(ctx)
+// CollectCertificates lists the certificates installed on the BMC using the
+// certificate locations of the CertificateService. This includes certificates
+// that are not used for the HTTPS connection (i.e. LDAP or client auth).
+// Nothing is returned when the BMC does not have a CertificateService.
+func CollectCertificates(c *gofish.APIClient, q *QueryParams) ([]byte, error) {
+	value, err := collectCertificates(c, q)
 	if err != nil {
-		ctxCancel()
-		return nil, fmt.Errorf("failed to open client: %v", err)
+		return nil, err
 	}
+	return marshalSection("Certificates", value)
+}
 
-	inventory, err := client.Inventory(ctx)
+func collectCertificates(c *gofish.APIClient, q *QueryParams) (any, error) {
+	service, err := c.Service.CertificateService()
 	if err != nil {
-		ctxCancel()
-		return nil, fmt.Errorf("failed to get inventory: %v", err)
+		return nil, fmt.Errorf("failed to get certificate service (%v:%v): %v", q.Host, q.Port, err)
+	}
+	if service == nil {
+		return nil, nil
 	}
 
-	// retrieve inventory data
-	data := map[string]any{"Inventory": inventory}
-	b, err := json.MarshalIndent(data, "", "    ")
+	locations, err := service.CertificateLocations()
 	if err != nil {
-		ctxCancel()
-		return nil, fmt.Errorf("failed to marshal JSON: %v", err)
+		return nil, fmt.Errorf("failed to get certificate locations (%v:%v): %v", q.Host, q.Port, err)
+	}
+	if locations == nil {
+		return nil, nil
 	}
 
-	ctxCancel()
-	return b, nil
-}
-
-func CollectPowerState(client *bmclib.Client, q *QueryParams) ([]byte, error) {
-	ctx, ctxCancel := context.WithTimeout(context.Background(), time.Second*time.Duration(q.Timeout))
-	client.Registry.FilterForCompatible(ctx)
-	err := client.PreferProvider(q.Preferred).Open(ctx)
+	certificates, err := locations.Certificates()
 	if err != nil {
-		ctxCancel()
-		return nil, fmt.Errorf("failed to open client: %v", err)
+		return nil, fmt.Errorf("failed to get certificates (%v:%v): %v", q.Host, q.Port, err)
+	}
+
+	temp := make([]map[string]any, 0, len(certificates))
+	for _, certificate := range certificates {
+		temp = append(temp, map[string]any{
+			"URI":                   certificate.ODataID,
+			"Subject":               certificate.Subject,
+			"Issuer":                certificate.Issuer,
+			"ValidNotBefore":        certificate.ValidNotBefore,
+			"ValidNotAfter":         certificate.ValidNotAfter,
+			"CertificateType":       certificate.CertificateType,
+			"CertificateUsageTypes": certificate.CertificateUsageTypes,
+			"KeyUsage":              certificate.KeyUsage,
+			"SerialNumber":          certificate.SerialNumber,
+			"Fingerprint":           certificate.Fingerprint,
+		})
 	}
 
-	powerState, err := client.GetPowerState(ctx)
+	return temp, nil
+}
+
+// CollectServiceRoot returns the Redfish service root as-is, which includes
+// the Redfish version and the protocol features supported by the BMC.
+func CollectServiceRoot(c *gofish.APIClient, q *QueryParams) ([]byte, error) {
+	value, err := collectServiceRoot(c, q)
 	if err != nil {
-		ctxCancel()
-		return nil, fmt.Errorf("failed to get inventory: %v", err)
+		return nil, err
 	}
+	return marshalSection("ServiceRoot", value)
+}
 
-	// retrieve inventory data
-	data := map[string]any{"PowerState": powerState}
-	b, err := json.MarshalIndent(data, "", "    ")
+func collectServiceRoot(c *gofish.APIClient, q *QueryParams) (any, error) {
+	var root json.RawMessage
+	err := getRaw(c, "/redfish/v1/", &root)
 	if err != nil {
-		ctxCancel()
-		return nil, fmt.Errorf("failed to marshal JSON: %v", err)
+		return nil, fmt.Errorf("failed to get service root (%v:%v): %v", q.Host, q.Port, err)
 	}
 
-	ctxCancel()
-	return b, nil
+	return root, nil
+}
 
+// CollectThermal reads the temperature and fan readings from the Thermal
+// resource of every chassis. Chassis without one are skipped.
+func CollectThermal(c *gofish.APIClient, q *QueryParams) ([]byte, error) {
+	value, err := collectThermal(c, q)
+	if err != nil {
+		return nil, err
+	}
+	return marshalSection("Thermal", value)
 }
 
-func CollectUsers(client *bmclib.Client, q *QueryParams) ([]byte, error) {
-	// open BMC session and update driver registry
-	ctx, ctxCancel := context.WithTimeout(context.Background(), time.Second*time.Duration(q.Timeout))
-	client.Registry.FilterForCompatible(ctx)
-	err := client.Open(ctx)
+func collectThermal(c *gofish.APIClient, q *QueryParams) (any, error) {
+	chassis, err := c.Service.Chassis()
 	if err != nil {
-		ctxCancel()
-		return nil, fmt.Errorf("failed to connect to bmc: %v", err)
+		return nil, fmt.Errorf("failed to get chassis: (%v:%v): %v", q.Host, q.Port, err)
+	}
+
+	temp := []map[string]any{}
+	for _, ch := range chassis {
+		thermal, err := ch.Thermal()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get thermal for chassis '%s' (%v:%v): %v", ch.ID, q.Host, q.Port, err)
+		}
+		if thermal == nil {
+			continue
+		}
+		temp = append(temp, map[string]any{
+			"Chassis":      ch.ID,
+			"Temperatures": thermal.Temperatures,
+			"Fans":         thermal.Fans,
+		})
 	}
 
-	defer client.Close(ctx)
+	return temp, nil
+}
 
-	users, err := client.ReadUsers(ctx)
+// CollectPower reads the power supply, power control, and voltage readings
+// from the Power resource of every chassis. Chassis without one are skipped.
+func CollectPower(c *gofish.APIClient, q *QueryParams) ([]byte, error) {
+	value, err := collectPower(c, q)
 	if err != nil {
-		ctxCancel()
-		return nil, fmt.Errorf("failed to get users: %v", err)
+		return nil, err
 	}
+	return marshalSection("Power", value)
+}
 
-	// retrieve inventory data
-	data := map[string]any{"Users": users}
-	b, err := json.MarshalIndent(data, "", "    ")
+func collectPower(c *gofish.APIClient, q *QueryParams) (any, error) {
+	chassis, err := c.Service.Chassis()
 	if err != nil {
-		ctxCancel()
-		return nil, fmt.Errorf("failed to marshal JSON: %v", err)
+		return nil, fmt.Errorf("failed to get chassis: (%v:%v): %v", q.Host, q.Port, err)
 	}
 
-	ctxCancel()
-	return b, nil
-}
+	temp := []map[string]any{}
+	for _, ch := range chassis {
+		power, err := ch.Power()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get power for chassis '%s' (%v:%v): %v", ch.ID, q.Host, q.Port, err)
+		}
+		if power == nil {
+			continue
+		}
+		temp = append(temp, map[string]any{
+			"Chassis":       ch.ID,
+			"PowerSupplies": power.PowerSupplies,
+			"PowerControl":  power.PowerControl,
+			"Voltages":      power.Voltages,
+		})
+	}
 
-func CollectBios(client *bmclib.Client, q *QueryParams) ([]byte, error) {
-	b, err := makeRequest(client, client.GetBiosConfiguration, q.Timeout)
-	return b, err
+	return temp, nil
 }
 
-func CollectEthernetInterfaces(c *gofish.APIClient, q *QueryParams, systemID string) ([]byte, error) {
-	// TODO: add more endpoints to test for ethernet interfaces
-	// /redfish/v1/Chassis/{ChassisID}/NetworkAdapters/{NetworkAdapterId}/NetworkDeviceFunctions/{NetworkDeviceFunctionId}/EthernetInterfaces/{EthernetInterfaceId}
-	// /redfish/v1/Managers/{ManagerId}/EthernetInterfaces/{EthernetInterfaceId}
-	// /redfish/v1/Systems/{ComputerSystemId}/EthernetInterfaces/{EthernetInterfaceId}
-	// /redfish/v1/Systems/{ComputerSystemId}/OperatingSystem/Containers/EthernetInterfaces/{EthernetInterfaceId}
-	systems, err := c.Service.Systems()
+// CollectSensors merges the thermal and power readings enabled in q under a
+// single "Sensors" key. Both are collected when neither is enabled.
+func CollectSensors(c *gofish.APIClient, q *QueryParams) ([]byte, error) {
+	value, err := collectSensors(c, q)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get systems: (%v:%v): %v", q.Host, q.Port, err)
+		return nil, err
 	}
+	return marshalSection("Sensors", value)
+}
 
+func collectSensors(c *gofish.APIClient, q *QueryParams) (any, error) {
 	var (
-		interfaces []*redfish.EthernetInterface
-		errList    []error
+		sensors = map[string]any{}
+		errList []error
+		both    = !q.CollectThermal && !q.CollectPowerSensors
 	)
-
-	// get all of the ethernet interfaces in our systems
-	for _, system := range systems {
-		system.EthernetInterfaces()
-		eth, err := redfish.ListReferencedEthernetInterfaces(c, "/redfish/v1/Systems/"+system.ID+"/EthernetInterfaces")
+	collect := func(key string, fn func(*gofish.APIClient, *QueryParams) (any, error)) {
+		value, err := fn(c, q)
 		if err != nil {
 			errList = append(errList, err)
+			return
 		}
-
-		interfaces = append(interfaces, eth...)
+		if value != nil {
+			sensors[key] = value
+		}
+	}
+	if q.CollectThermal || both {
+		collect("Thermal", collectThermal)
+	}
+	if q.CollectPowerSensors || both {
+		collect("Power", collectPower)
 	}
 
 	// print any report errors
-	err = util.FormatErrorList(errList)
+	err := util.FormatErrorList(errList)
 	if util.HasErrors(errList) {
-		return nil, fmt.Errorf("failed to get ethernet interfaces with %d error(s): \n%v", len(errList), err)
+		return nil, fmt.Errorf("failed to get sensors with %d error(s): \n%v", len(errList), err)
 	}
 
-	data := map[string]any{"EthernetInterfaces": interfaces}
-	b, err := json.MarshalIndent(data, "", "    ")
+	return sensors, nil
+}
+
+// CollectSEL reads the entries of the System Event Log (SEL) services found
+// on the systems and managers. At most q.MaxLogEntries entries are kept from
+// each log when set to a positive value.
+func CollectSEL(c *gofish.APIClient, q *QueryParams) ([]byte, error) {
+	entries, err := collectSEL(c, q)
 	if err != nil {
-		return nil, fmt.Errorf("failed to marshal JSON: %v", err)
+		return nil, err
 	}
-
-	return b, nil
+	return marshalSection("SEL", entries)
 }
 
-func CollectChassis(c *gofish.APIClient, q *QueryParams) ([]byte, error) {
-	chassis, err := c.Service.Chassis()
+func collectSEL(c *gofish.APIClient, q *QueryParams) (any, error) {
+	systems, err := c.Service.Systems()
 	if err != nil {
-		return nil, fmt.Errorf("failed to query chassis (%v:%v): %v", q.Host, q.Port, err)
+		return nil, fmt.Errorf("failed to get systems: (%v:%v): %v", q.Host, q.Port, err)
 	}
-
-	data := map[string]any{"Chassis": chassis}
-	b, err := json.MarshalIndent(data, "", "    ")
+	managers, err := c.Service.Managers()
 	if err != nil {
-		return nil, fmt.Errorf("failed to marshal JSON: %v", err)
+		return nil, fmt.Errorf("failed to get managers: (%v:%v): %v", q.Host, q.Port, err)
 	}
 
-	return b, nil
+	var services []*redfish.LogService
+	for _, system := range systems {
+		s, err := system.LogServices()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get system log services (%v:%v): %v", q.Host, q.Port, err)
+		}
+		services = append(services, s...)
+	}
+	for _, manager := range managers {
+		s, err := manager.LogServices()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get manager log services (%v:%v): %v", q.Host, q.Port, err)
+		}
+		services = append(services, s...)
+	}
+
+	temp := []map[string]any{}
+	for _, service := range services {
+		if service.LogEntryType != redfish.SELLogEntryTypes && !strings.EqualFold(service.ID, "SEL") {
+			continue
+		}
+
+		var entries []*redfish.LogEntry
+		if q.MaxLogEntries > 0 {
+			entries, err = service.FilteredEntries(common.WithTop(q.MaxLogEntries))
+		} else {
+			entries, err = service.Entries()
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to get SEL entries (%v:%v): %v", q.Host, q.Port, err)
+		}
+
+		// not every BMC supports $top so make sure the cap is applied
+		if q.MaxLogEntries > 0 && len(entries) > q.MaxLogEntries {
+			entries = entries[:q.MaxLogEntries]
+		}
+		temp = append(temp, map[string]any{
+			"URI":     service.ODataID,
+			"Entries": entries,
+		})
+	}
+
+	return temp, nil
 }
 
-func CollectStorage(c *gofish.APIClient, q *QueryParams) ([]byte, error) {
-	systems, err := c.Service.StorageSystems()
+// CollectSystemPowerState reads the power state (On, Off, PoweringOn, etc.)
+// of the first system managed by the BMC, which is the only one for most.
+func CollectSystemPowerState(c *gofish.APIClient, q *QueryParams) ([]byte, error) {
+	value, err := collectSystemPowerState(c, q)
 	if err != nil {
-		return nil, fmt.Errorf("failed to query storage systems (%v:%v): %v", q.Host, q.Port, err)
+		return nil, err
 	}
+	return marshalSection("PowerState", value)
+}
 
-	services, err := c.Service.StorageServices()
+func collectSystemPowerState(c *gofish.APIClient, q *QueryParams) (any, error) {
+	systems, err := c.Service.Systems()
 	if err != nil {
-		return nil, fmt.Errorf("failed to query storage services (%v:%v): %v", q.Host, q.Port, err)
+		return nil, fmt.Errorf("failed to get systems: (%v:%v): %v", q.Host, q.Port, err)
+	}
+	if len(systems) <= 0 {
+		return nil, fmt.Errorf("no systems found (%v:%v)", q.Host, q.Port)
 	}
 
-	data := map[string]any{
-		"Storage": map[string]any{
-			"Systems":  systems,
-			"Services": services,
-		},
+	return systems[0].PowerState, nil
+}
+
+// CollectManagers returns the details of each manager (the BMC itself rather
+// than the systems it manages) including which network protocols it has
+// enabled. Managers without network protocol settings are still included.
+func CollectManagers(c *gofish.APIClient, l *log.Logger, q *QueryParams) ([]byte, error) {
+	value, err := collectManagers(c, l, q)
+	if err != nil {
+		return nil, err
 	}
-	b, err := json.MarshalIndent(data, "", "    ")
+	return marshalSection("Managers", value)
+}
+
+func collectManagers(c *gofish.APIClient, l *log.Logger, q *QueryParams) (any, error) {
+	l.Log.Debugf("querying managers (%v:%v)", q.Host, q.Port)
+	managers, err := c.Service.Managers()
 	if err != nil {
-		return nil, fmt.Errorf("failed to marshal JSON: %v", err)
+		return nil, fmt.Errorf("failed to get managers (%v:%v): %v", q.Host, q.Port, err)
 	}
 
-	return b, nil
+	temp := make([]map[string]any, 0, len(managers))
+	for _, manager := range managers {
+		entry := map[string]any{
+			"ID":                  manager.ID,
+			"Name":                manager.Name,
+			"ManagerType":         manager.ManagerType,
+			"Manufacturer":        manager.Manufacturer,
+			"Model":               manager.Model,
+			"FirmwareVersion":     manager.FirmwareVersion,
+			"SerialNumber":        manager.SerialNumber,
+			"PartNumber":          manager.PartNumber,
+			"UUID":                manager.UUID,
+			"DateTime":            manager.DateTime,
+			"DateTimeLocalOffset": manager.DateTimeLocalOffset,
+			"TimeZoneName":        manager.TimeZoneName,
+			"LastResetTime":       manager.LastResetTime,
+			"PowerState":          manager.PowerState,
+			"Status":              manager.Status,
+		}
+
+		protocol, err := manager.NetworkProtocol()
+		if err != nil {
+			l.Log.Debugf("failed to get network protocol of manager '%s' (%v:%v): %v", manager.ID, q.Host, q.Port, err)
+		}
+		if protocol != nil {
+			entry["NetworkProtocol"] = map[string]any{
+				"HostName": protocol.HostName,
+				"FQDN":     protocol.FQDN,
+				"HTTP":     protocol.HTTP,
+				"HTTPS":    protocol.HTTPS.NetworkProtocol,
+				"SSH":      protocol.SSH,
+				"IPMI":     protocol.IPMI,
+				"NTP": map[string]any{
+					"ProtocolEnabled": protocol.NTP.ProtocolEnabled,
+					"Port":            protocol.NTP.Port,
+					"NTPServers":      protocol.NTP.NTPServers,
+				},
+			}
+		}
+		temp = append(temp, entry)
+	}
+
+	return temp, nil
 }
 
-func CollectSystems(c *gofish.APIClient, q *QueryParams) ([]byte, error) {
-	systems, err := c.Service.Systems()
+// aggregationSources maps the URI of each system behind an aggregating BMC to
+// the node (aggregation source) it is accessed through. The URIs are also
+// returned in the order they were found. Nothing is returned when the BMC has
+// no aggregation service.
+func aggregationSources(c *gofish.APIClient, l *log.Logger, q *QueryParams) (map[string]map[string]any, []string, error) {
+	service, err := c.Service.AggregationService()
 	if err != nil {
-		return nil, fmt.Errorf("failed to get systems (%v:%v): %v", q.Host, q.Port, err)
+		return nil, nil, fmt.Errorf("failed to get aggregation service (%v:%v): %v", q.Host, q.Port, err)
+	}
+	if service == nil {
+		return nil, nil, nil
+	}
+
+	l.Log.Debugf("querying aggregation sources (%v:%v)", q.Host, q.Port)
+	sources, err := service.AggregationSources()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get aggregation sources (%v:%v): %v", q.Host, q.Port, err)
 	}
 
-	// 1. check if system has ethernet interfaces
-	// 1.a. if yes, create system data and ethernet interfaces JSON
-	// 1.b. if no, try to get data using manager instead
-	// 2. check if manager has "ManagerForServices" and "EthernetInterfaces" properties
-	// 2.a. if yes, query both properties to use in next step
-	// 2.b. for each service, query its data and add the ethernet interfaces
-	// 2.c. add the system to list of systems to marshal and return
 	var (
-		temp []map[string]any
-		eths []*redfish.EthernetInterface
+		nodes = map[string]map[string]any{}
+		uris  []string
 	)
-
-	for _, system := range systems {
-		eths, err = system.EthernetInterfaces()
+	for _, source := range sources {
+		// the resources accessed through the source are not exported by gofish
+		var raw struct {
+			Links struct {
+				ResourcesAccessed []struct {
+					ODataID string `json:"@odata.id"`
+				}
+			}
+		}
+		err = getRaw(c, source.ODataID, &raw)
 		if err != nil {
-			return nil, fmt.Errorf("failed to get system ethernet interfaces: %v", err)
+			return nil, nil, fmt.Errorf("failed to get resources of aggregation source '%s' (%v:%v): %v", source.ID, q.Host, q.Port, err)
 		}
 
-		// try and get ethernet interfaces through manager if empty
-		if len(eths) <= 0 {
-			if q.Verbose {
-				fmt.Printf("no system ethernet interfaces found...trying to get from managers interface\n")
+		node := map[string]any{
+			"ID":              source.ID,
+			"Name":            source.Name,
+			"HostName":        source.HostName,
+			"AggregationType": source.AggregationType,
+		}
+		for _, resource := range raw.Links.ResourcesAccessed {
+			uri := strings.TrimSuffix(resource.ODataID, "/")
+			if !strings.HasPrefix(uri, "/redfish/v1/Systems/") {
+				continue
 			}
-			for _, managerLink := range system.ManagedBy {
-				// try getting ethernet interface from all managers until one is found
-				eths, err = redfish.ListReferencedEthernetInterfaces(c, managerLink+"/EthernetInterfaces")
-				if err != nil {
-					return nil, fmt.Errorf("failed to get system manager ethernet interfaces: %v", err)
-				}
-				if len(eths) > 0 {
-					break
-				}
+			if _, found := nodes[uri]; !found {
+				uris = append(uris, uri)
 			}
+			nodes[uri] = node
 		}
+	}
+	return nodes, uris, nil
+}
 
-		// add system to collection of systems
-		temp = append(temp, map[string]any{
-			"Data":               system,
-			"EthernetInterfaces": eths,
-		})
+// CollectBootOptions reports the boot source override and the boot order of
+// each system keyed by the system ID. Entries in the boot order are matched
+// to the system's boot options when it has any so they include the name of
+// the device, otherwise only the references are listed.
+func CollectBootOptions(c *gofish.APIClient, l *log.Logger, q *QueryParams) ([]byte, error) {
+	value, err := collectBootOptions(c, l, q)
+	if err != nil {
+		return nil, err
 	}
+	return marshalSection("Boot", value)
+}
 
-	// do manual requests if systems is empty to only get necessary info as last resort
-	// /redfish/v1/Systems
+func collectBootOptions(c *gofish.APIClient, l *log.Logger, q *QueryParams) (any, error) {
+	l.Log.Debugf("querying boot options (%v:%v)", q.Host, q.Port)
+	systems, err := c.Service.Systems()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get systems (%v:%v): %v", q.Host, q.Port, err)
+	}
 
-	// /redfish/v1/Systems/Members
-	// /redfish/v1/Systems/
-	// fmt.Printf("system count: %d\n", len(systems))
-	// if len(systems) == 0 {
-	// 	url := baseRedfishUrl(q) + "/Systems"
-	// 	if q.Verbose {
-	// 		fmt.Printf("%s\n", url)
-	// 	}
-	// 	res, body, err := util.MakeRequest(nil, url, "GET", nil, nil)
-	// 	if err != nil {
-	// 		return nil, fmt.Errorf("failed to make request: %v", err)
-	// 	} else if res.StatusCode != http.StatusOK {
-	// 		return nil, fmt.Errorf("request returned status code %d", res.StatusCode)
-	// 	}
+	boot := map[string]any{}
+	for _, system := range systems {
+		options, err := system.BootOptions()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get boot options of system '%s' (%v:%v): %v", system.ID, q.Host, q.Port, err)
+		}
+		if len(options) <= 0 {
+			l.Log.Debugf("no boot options found for system '%s' (%v:%v)", system.ID, q.Host, q.Port)
+		}
 
-	// 	// sweet syntatic sugar type aliases
-	// 	type System = map[string]any
-	// 	type Member = map[string]string
+		references := make(map[string]*redfish.BootOption, len(options))
+		for _, option := range options {
+			references[option.BootOptionReference] = option
+		}
+		order := make([]map[string]any, 0, len(system.Boot.BootOrder))
+		for _, reference := range system.Boot.BootOrder {
+			entry := map[string]any{"Reference": reference}
+			if option, ok := references[reference]; ok {
+				entry["DisplayName"] = option.DisplayName
+				entry["Enabled"] = option.BootOptionEnabled
+				entry["Alias"] = option.Alias
+			}
+			order = append(order, entry)
+		}
 
-	// 	// get all the systems
-	// 	var (
-	// 		tempSystems System
-	// 		interfaces  []*redfish.EthernetInterface
-	// 		errList     []error
-	// 	)
-	// 	err = json.Unmarshal(body, &tempSystems)
-	// 	if err != nil {
-	// 		return nil, fmt.Errorf("failed to unmarshal systems: %v", err)
-	// 	}
+		boot[system.ID] = map[string]any{
+			"Override": map[string]any{
+				"Enabled": system.Boot.BootSourceOverrideEnabled,
+				"Target":  system.Boot.BootSourceOverrideTarget,
+				"Mode":    system.Boot.BootSourceOverrideMode,
+			},
+			"BootNext":    system.Boot.BootNext,
+			"BootOrder":   order,
+			"BootOptions": options,
+		}
+	}
 
-	// 	// then, get all the members within a system
-	// 	members, ok := tempSystems["Members"]
-	// 	if ok {
-	// 		for _, member := range members.([]Member) {
-	// 			id, ok := member["@odata.id"]
-	// 			if ok {
-	// 				// /redfish/v1/Systems/Self (or whatever)
-	// 				// memberEndpoint := fmt.Sprintf("%s%s", url, id)
-	// 				// res, body, err := util.MakeRequest(nil, baseRedfishUrl(q)+memberEndpoint, http.MethodGet, nil, nil)
-	// 				// if err != nil {
-	// 				// 	continue
-	// 				// } else if res.StatusCode != http.StatusOK {
-	// 				// 	continue
-	// 				// }
-	// 				// TODO: extract EthernetInterfaces from Systems then query
+	return boot, nil
+}
 
-	// 				// get all of the ethernet interfaces in our systems
-	// 				ethernetInterface, err := redfish.ListReferencedEthernetInterfaces(c, id+"/EthernetInterfaces/")
-	// 				if err != nil {
-	// 					errList = append(errList, err)
-	// 					continue
-	// 				}
-	// 				interfaces = append(interfaces, ethernetInterface...)
-	// 			} else {
-	// 				return nil, fmt.Errorf("no ID found for member")
-	// 			}
-	// 			if util.HasErrors(errList) {
-	// 				return nil, util.FormatErrorList(errList)
-	// 			}
-	// 		}
-	// 		i, err := json.Marshal(interfaces)
-	// 		if err != nil {
-	// 			return nil, fmt.Errorf("failed to unmarshal interface: %v", err)
-	// 		}
-	// 		temp = append(temp, map[string]any{
-	// 			"Data":               nil,
-	// 			"EthernetInterfaces": string(i),
-	// 		})
-	// 	} else {
-	// 		return nil, fmt.Errorf("no members found in systems")
-	// 	}
+// CollectFirmwareInventory lists the firmware versions of each component from
+// the UpdateService. Nothing is returned when the BMC does not have an
+// UpdateService.
+func CollectFirmwareInventory(c *gofish.APIClient, q *QueryParams) ([]byte, error) {
+	inventory, err := collectFirmwareInventory(c, q)
+	if err != nil {
+		return nil, err
+	}
+	return marshalSection("Firmware", inventory)
+}
 
-	// } else {
-	// 	b, err := json.Marshal(systems)
-	// 	if err != nil {
-	// 		fmt.Printf("failed to marshal systems: %v", err)
-	// 	}
-	// 	fmt.Printf("systems: %v\n", string(b))
+func collectFirmwareInventory(c *gofish.APIClient, q *QueryParams) (any, error) {
+	// gofish does not check if the service exists before getting it
+	var root struct {
+		UpdateService common.Link
+	}
+	err := getRaw(c, "/redfish/v1/", &root)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get service root (%v:%v): %v", q.Host, q.Port, err)
+	}
+	if root.UpdateService.String() == "" {
+		return nil, nil
+	}
 
-	// 	// query the system's ethernet interfaces
-	// 	// var temp []map[string]any
-	// 	var errList []error
-	// 	for _, system := range systems {
-	// 		interfaces, err := CollectEthernetInterfaces(c, q, system.ID)
-	// 		if err != nil {
-	// 			errList = append(errList, fmt.Errorf("failed to collect ethernet interface: %v", err))
-	// 			continue
-	// 		}
-	// 		var i map[string]any
-	// 		err = json.Unmarshal(interfaces, &i)
-	// 		if err != nil {
-	// 			return nil, fmt.Errorf("failed to unmarshal interface: %v", err)
-	// 		}
-	// 		temp = append(temp, map[string]any{
-	// 			"Data":               system,
-	// 			"EthernetInterfaces": i["EthernetInterfaces"],
-	// 		})
-	// 	}
-	// 	if util.HasErrors(errList) {
-	// 		err = util.FormatErrorList(errList)
-	// 		if err != nil {
-	// 			return nil, fmt.Errorf("multiple errors occurred: %v", err)
-	// 		}
-	// 	}
-	// }
+	service, err := c.Service.UpdateService()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get update service (%v:%v): %v", q.Host, q.Port, err)
+	}
 
-	data := map[string]any{"Systems": temp}
-	b, err := json.MarshalIndent(data, "", "    ")
+	inventories, err := service.FirmwareInventories()
 	if err != nil {
-		return nil, fmt.Errorf("failed to marshal JSON: %v", err)
+		return nil, fmt.Errorf("failed to get firmware inventory (%v:%v): %v", q.Host, q.Port, err)
 	}
 
-	return b, nil
+	temp := make([]map[string]any, 0, len(inventories))
+	for _, inventory := range inventories {
+		temp = append(temp, map[string]any{
+			"ID":           inventory.ID,
+			"Name":         inventory.Name,
+			"Version":      inventory.Version,
+			"Manufacturer": inventory.Manufacturer,
+			"SoftwareID":   inventory.SoftwareID,
+			"ReleaseDate":  inventory.ReleaseDate,
+			"Updateable":   inventory.Updateable,
+			"Status":       inventory.Status,
+		})
+	}
+
+	return temp, nil
 }
 
-func CollectRegisteries(c *gofish.APIClient, q *QueryParams) ([]byte, error) {
+func CollectRegisteries(c *gofish.APIClient, l *log.Logger, q *QueryParams) ([]byte, error) {
+	l.Log.Debugf("querying registries (%v:%v)", q.Host, q.Port)
 	registries, err := c.Service.Registries()
 	if err != nil {
-		return nil, fmt.Errorf("failed to query storage systems (%v:%v): %v", q.Host, q.Port, err)
+		return nil, fmt.Errorf("failed to query registries (%v:%v): %v", q.Host, q.Port, err)
 	}
 
 	data := map[string]any{"Registries": registries}
@@ -659,66 +2725,449 @@ func CollectProcessors(q *QueryParams) ([]byte, error) {
 	return b, nil
 }
 
-func connectGofish(q *QueryParams) (*gofish.APIClient, error) {
-	config, err := makeGofishConfig(q)
+// makeEnvelope wraps the collected data with the time it was collected as
+// well as the collector and schema versions.
+func makeEnvelope(data any, q *QueryParams) map[string]any {
+	key := q.EnvelopeKey
+	if key == "" {
+		key = "data"
+	}
+	return map[string]any{
+		"schema":           SCHEMA_VERSION,
+		"collectedAt":      q.now().UTC().Format(time.RFC3339),
+		"collectorVersion": Version,
+		key:                data,
+	}
+}
+
+// NewClient creates a bmclib client for the host in q using the drivers in
+// q.Drivers (all drivers are used when empty).
+func NewClient(l *log.Logger, q *QueryParams) (*bmclib.Client, error) {
+	tlsConfig, err := makeTLSConfig(q)
 	if err != nil {
-		return nil, fmt.Errorf("failed to make gofish config: %v", err)
+		return nil, err
 	}
-	c, err := gofish.Connect(config)
+	proxy, err := makeProxy(q)
 	if err != nil {
-		return nil, fmt.Errorf("failed to connect to redfish endpoint: %v", err)
+		return nil, err
 	}
-	if c != nil {
-		c.Service.ProtocolFeaturesSupported = gofish.ProtocolFeaturesSupported{
-			ExpandQuery: gofish.Expand{
-				ExpandAll: true,
-				Links:     true,
-			},
+	var transport http.RoundTripper = &http.Transport{
+		TLSClientConfig:     tlsConfig,
+		Proxy:               proxy,
+		DialContext:         (&net.Dialer{Timeout: q.connectTimeout()}).DialContext,
+		TLSHandshakeTimeout: q.connectTimeout(),
+	}
+	if q.Transport != nil {
+		transport = q.Transport
+	}
+	httpClient := &http.Client{Transport: transport}
+
+	clientOpts := []bmclib.Option{
+		bmclib.WithHTTPClient(httpClient),
+		bmclib.WithPerProviderTimeout(q.queryTimeout()),
+		bmclib.WithIpmitoolPort(fmt.Sprint(q.ipmiPort())),
+		bmclib.WithRedfishPort(fmt.Sprint(q.Port)),
+	}
+	if q.SecureTLS {
+		// bmclib skips verification unless given a pool so fall back to the
+		// system pool when no CA was given
+		rootCAs := tlsConfig.RootCAs
+		if rootCAs == nil {
+			rootCAs, err = x509.SystemCertPool()
+			if err != nil {
+				return nil, fmt.Errorf("failed to load system cert pool: %v", err)
+			}
+		}
+		clientOpts = append(clientOpts, bmclib.WithSecureTLS(rootCAs))
+	}
+	if q.IpmitoolPath != "" {
+		clientOpts = append(clientOpts, bmclib.WithIpmitoolPath(q.IpmitoolPath))
+	}
+	if q.quirks().BasicAuth {
+		clientOpts = append(clientOpts,
+			bmclib.WithRedfishUseBasicAuth(true),
+			bmclib.WithDellRedfishUseBasicAuth(true),
+		)
+	}
+
+	// the redfish drivers append the port to the host so IPv6 needs brackets
+	client := bmclib.NewClient(urlHost(q.Host), q.User, q.Pass, clientOpts...)
+	if len(q.Drivers) > 0 {
+		client.Registry.Drivers = client.Registry.Using(q.Drivers[0])
+		for _, driver := range q.Drivers[1:] {
+			client.Registry.Drivers = append(client.Registry.Drivers, client.Registry.Using(driver)...)
 		}
 	}
-	return c, err
+	if len(client.Registry.Drivers) <= 0 {
+		return nil, fmt.Errorf("no drivers found for %v", q.Drivers)
+	}
+	return client, nil
+}
+
+// checkServiceRoot reads the Redfish service root of the session to confirm
+// the host is a BMC. An error is returned when the host cannot be reached at
+// all so it can be handled the same way as any other connection failure.
+func checkServiceRoot(ctx context.Context, s *Session) (bool, error) {
+	status, body, err := s.ServiceRoot(ctx)
+	if err != nil {
+		return false, err
+	}
+
+	// some BMCs require authentication even for the service root
+	if status == http.StatusUnauthorized {
+		return true, nil
+	}
+	if status != http.StatusOK {
+		return false, nil
+	}
+
+	var root struct {
+		ODataID        string `json:"@odata.id"`
+		RedfishVersion string
+	}
+	err = json.Unmarshal(body, &root)
+	if err != nil {
+		return false, nil
+	}
+	return root.RedfishVersion != "" || root.ODataID != "", nil
+}
+
+// serviceRootUUID returns the UUID reported by the Redfish service root of
+// the session which is empty when the BMC does not report one.
+func serviceRootUUID(ctx context.Context, s *Session) (string, error) {
+	status, body, err := s.ServiceRoot(ctx)
+	if err != nil {
+		return "", err
+	}
+	if status != http.StatusOK {
+		return "", fmt.Errorf("service root returned status code %d", status)
+	}
+
+	var root struct {
+		UUID string
+	}
+	err = json.Unmarshal(body, &root)
+	if err != nil {
+		return "", fmt.Errorf("failed to unmarshal service root: %v", err)
+	}
+	return root.UUID, nil
 }
 
-func makeGofishConfig(q *QueryParams) (gofish.ClientConfig, error) {
+// dedupeByUUID keeps the first open probe state of each BMC using the UUID
+// of its service root to tell when different hosts are the same BMC. The
+// hosts that were dropped are returned as aliases of the one kept. Hosts
+// without a UUID (or that could not be reached) are always kept. The service
+// roots read are returned by host:port so they are not requested again.
+func dedupeByUUID(ctx context.Context, states []ScannedResult, l *log.Logger, q *QueryParams, concurrency int) ([]ScannedResult, map[string][]string, map[string]*serviceRoot) {
+	if concurrency <= 0 {
+		concurrency = 1
+	}
 	var (
-		client = &http.Client{
-			Transport: &http.Transport{
-				TLSClientConfig: &tls.Config{
-					InsecureSkipVerify: true,
-				},
-			},
+		uuids    = make([]string, len(states))
+		sessions = make([]*Session, len(states))
+		sem      = make(chan struct{}, concurrency)
+		wg       sync.WaitGroup
+	)
+	for i, ps := range states {
+		if !ps.State {
+			continue
+		}
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, ps ScannedResult) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			params := *q
+			params.Host = ps.Host
+			params.Port = ps.Port
+			sessions[i] = NewSession(l, &params)
+			uuids[i], _ = serviceRootUUID(ctx, sessions[i])
+		}(i, ps)
+	}
+	wg.Wait()
+
+	var (
+		unique  = make([]ScannedResult, 0, len(states))
+		aliases = map[string][]string{}
+		roots   = map[string]*serviceRoot{}
+		first   = map[string]string{}
+	)
+	for i, ps := range states {
+		if sessions[i] != nil && sessions[i].root != nil {
+			roots[net.JoinHostPort(ps.Host, fmt.Sprint(ps.Port))] = sessions[i].root
+		}
+		uuid := strings.ToLower(uuids[i])
+		if uuid == "" {
+			unique = append(unique, ps)
+			continue
+		}
+		host, seen := first[uuid]
+		if !seen {
+			first[uuid] = ps.Host
+			unique = append(unique, ps)
+			continue
+		}
+		if host != ps.Host {
+			aliases[host] = append(aliases[host], net.JoinHostPort(ps.Host, fmt.Sprint(ps.Port)))
+		}
+	}
+	return unique, aliases, roots
+}
+
+// retryConnect calls fn up to q.Retries more times when it fails because the
+// BMC could not be reached or timed out. Authentication and other errors are
+// returned right away since retrying will not help. Each attempt waits on the
+// rate limit and gets its own query timeout so a BMC hanging on the first
+// attempt still leaves time for the others.
+func retryConnect(ctx context.Context, q *QueryParams, fn func(ctx context.Context) error) error {
+	delay := q.RetryDelay
+	if delay <= 0 {
+		delay = time.Second
+	}
+	return util.Retry(ctx, q.Retries+1, delay, isConnectionError, func() error {
+		err := q.wait(ctx)
+		if err != nil {
+			return err
+		}
+		ctx, ctxCancel := context.WithTimeout(ctx, q.queryTimeout())
+		defer ctxCancel()
+		return fn(ctx)
+	})
+}
+
+func isConnectionError(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr) ||
+		errors.Is(err, io.EOF) ||
+		errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, syscall.ECONNRESET)
+}
+
+// isUnreachable returns whether err means the BMC did not respond at all
+// because the connection timed out or was refused.
+func isUnreachable(err error) bool {
+	var netErr net.Error
+	return (errors.As(err, &netErr) && netErr.Timeout()) ||
+		errors.Is(err, context.DeadlineExceeded) ||
+		errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, syscall.EHOSTUNREACH) ||
+		errors.Is(err, syscall.ENETUNREACH)
+}
+
+// getRaw requests the resource at uri and decodes the JSON response into v
+// which is useful for properties that gofish does not expose.
+func getRaw(c *gofish.APIClient, uri string, v any) error {
+	res, err := c.Get(uri)
+	if err != nil {
+		return fmt.Errorf("failed to get resource '%s': %v", uri, err)
+	}
+	defer res.Body.Close()
+	err = json.NewDecoder(res.Body).Decode(v)
+	if err != nil {
+		return fmt.Errorf("failed to decode resource '%s': %v", uri, err)
+	}
+	return nil
+}
+
+// newLimiter returns a limiter allowing perSecond connections each second or
+// no limit at all when perSecond is not positive.
+func newLimiter(perSecond float64) *rate.Limiter {
+	if perSecond <= 0 {
+		return rate.NewLimiter(rate.Inf, 0)
+	}
+	return rate.NewLimiter(rate.Limit(perSecond), 1)
+}
+
+// wait blocks until the rate limit allows another connection to a BMC or ctx
+// is done. It never blocks when no limiter was set.
+func (q *QueryParams) wait(ctx context.Context) error {
+	if q.limiter == nil {
+		return ctx.Err()
+	}
+	return q.limiter.Wait(ctx)
+}
+
+// BMCs (host:port) mapped to whether they support $expand queries
+var expandSupport sync.Map
+
+// expandKey returns the key of the BMC in q in expandSupport so BMCs sharing
+// a host on different ports are kept apart.
+func expandKey(q *QueryParams) string {
+	return fmt.Sprintf("%s:%d", urlHost(q.Host), q.Port)
+}
+
+// connectGofish logs in to the BMC and returns a client that is meant to be
+// shared by every query made to the host (i.e. each section in CollectAll) so
+// the BMC only has to authenticate once. Requests are made with transport
+// (see makeTransport when nil). The caller must call Logout once done with it.
+func connectGofish(ctx context.Context, q *QueryParams, transport http.RoundTripper) (*gofish.APIClient, error) {
+	config, err := makeGofishConfig(q, transport)
+	if err != nil {
+		return nil, fmt.Errorf("failed to make gofish config: %v", err)
+	}
+	c, err := gofish.ConnectContext(ctx, config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to redfish endpoint: %w", err)
+	}
+	if c == nil || c.Service == nil {
+		// do not leave the session open on the BMC since it will not be used
+		if c != nil {
+			c.Logout()
+		}
+		return nil, fmt.Errorf("failed to connect to redfish endpoint: no service root returned")
+	}
+
+	// only expand queries when the BMC says it can since some reject $expand
+	// with a 400 (remembered per BMC so it is only decided once)
+	supported := c.Service.ProtocolFeaturesSupported.ExpandQuery.ExpandAll
+	if cached, ok := expandSupport.Load(expandKey(q)); ok {
+		supported = cached.(bool)
+	} else {
+		expandSupport.Store(expandKey(q), supported)
+	}
+
+	// the user and the quirks of the vendor can still turn it off for this
+	// query without changing what is known about the BMC
+	expand := supported && !q.DisableExpand && !q.quirks().NoExpand
+	c.Service.ProtocolFeaturesSupported.ExpandQuery = gofish.Expand{
+		ExpandAll: expand,
+		Links:     expand,
+	}
+	return c, nil
+}
+
+// makeGofishConfig builds the client config used to connect to the BMC with
+// transport or a new one from makeTransport when nil.
+func makeGofishConfig(q *QueryParams, transport http.RoundTripper) (gofish.ClientConfig, error) {
+	if transport == nil {
+		var err error
+		transport, err = makeTransport(q, nil)
+		if err != nil {
+			return gofish.ClientConfig{}, err
 		}
-		url = baseRedfishUrl(q)
+	}
+	var (
+		client = &http.Client{Transport: transport, Timeout: q.queryTimeout()}
+		url    = baseRedfishUrl(q)
 	)
+	// TLSHandshakeTimeout is not set since gofish only uses it when making
+	// its own client (the handshake timeout is set in makeTransport instead)
 	return gofish.ClientConfig{
-		Endpoint:            url,
-		Username:            q.User,
-		Password:            q.Pass,
-		Insecure:            true,
-		TLSHandshakeTimeout: q.Timeout,
-		HTTPClient:          client,
+		Endpoint:   url,
+		Username:   q.User,
+		Password:   q.Pass,
+		Insecure:   !q.SecureTLS,
+		BasicAuth:  q.quirks().BasicAuth,
+		HTTPClient: client,
 		// MaxConcurrentRequests: int64(q.Threads),  // NOTE: this was added in latest version of gofish
 	}, nil
 }
 
-func makeRequest[T any](client *bmclib.Client, fn func(context.Context) (T, error), timeout int) ([]byte, error) {
-	ctx, ctxCancel := context.WithTimeout(context.Background(), time.Second*time.Duration(timeout))
-	client.Registry.FilterForCompatible(ctx)
-	err := client.Open(ctx)
+// makeTransport builds the transport used for all requests made to a BMC.
+// When q.Transport is set it is used as the base as-is, which means the TLS
+// settings and certificate capture are the responsibility of the caller.
+// Otherwise, a new transport is created with the TLS settings applied. In
+// both cases, the base is wrapped to retry when the BMC is busy or rejects
+// $expand.
+func makeTransport(q *QueryParams, capture *certCapture) (http.RoundTripper, error) {
+	transport := q.Transport
+	if transport == nil {
+		tlsConfig, err := makeTLSConfig(q)
+		if err != nil {
+			return nil, err
+		}
+		if capture != nil {
+			tlsConfig.VerifyConnection = capture.verifyConnection
+		}
+		proxy, err := makeProxy(q)
+		if err != nil {
+			return nil, err
+		}
+		transport = &http.Transport{
+			TLSClientConfig:     tlsConfig,
+			Proxy:               proxy,
+			DialContext:         (&net.Dialer{Timeout: q.connectTimeout()}).DialContext,
+			TLSHandshakeTimeout: q.connectTimeout(),
+		}
+	}
+
+	// retry without $expand when rejected and stop using it for the host
+	transport = &util.ExpandFallbackTransport{
+		Base: transport,
+		OnFallback: func(req *http.Request) {
+			expandSupport.Store(expandKey(q), false)
+			if q.onExpandFallback != nil {
+				q.onExpandFallback(req.URL.Path)
+			}
+		},
+	}
+
+	// retry requests when the BMC is busy, but never wait longer in total than
+	// the query timeout across every request made with the transport (which
+	// is shared by the session of the host)
+	if q.BusyRetries > 0 {
+		transport = &util.BusyRetryTransport{
+			Base:       transport,
+			MaxRetries: q.BusyRetries,
+			MaxWait:    q.queryTimeout(),
+		}
+	}
+	return transport, nil
+}
+
+// makeProxy returns the proxy function used by transports for q.Proxy or nil
+// when no proxy is set. IPMI goes directly to the BMC since ipmitool has no
+// way to use a proxy.
+func makeProxy(q *QueryParams) (func(*http.Request) (*url.URL, error), error) {
+	if q.Proxy == "" {
+		return nil, nil
+	}
+	proxyUrl, err := url.Parse(q.Proxy)
 	if err != nil {
-		ctxCancel()
-		return nil, fmt.Errorf("failed to open client: %v", err)
+		return nil, fmt.Errorf("invalid proxy '%s': %v", q.Proxy, err)
+	}
+	switch proxyUrl.Scheme {
+	case "http", "https", "socks5":
+	default:
+		return nil, fmt.Errorf("invalid proxy '%s' (scheme must be 'http', 'https', or 'socks5')", q.Proxy)
+	}
+	if proxyUrl.Host == "" {
+		return nil, fmt.Errorf("invalid proxy '%s': missing host", q.Proxy)
+	}
+	return http.ProxyURL(proxyUrl), nil
+}
+
+// makeTLSConfig skips verifying the BMC's certificate unless q.SecureTLS is
+// set, in which case it must be signed by the CA at q.CaCertPath (or by the
+// system roots when no CA is given).
+func makeTLSConfig(q *QueryParams) (*tls.Config, error) {
+	if !q.SecureTLS {
+		return &tls.Config{InsecureSkipVerify: true}, nil
+	}
+	tlsConfig := &tls.Config{}
+	if q.CaCertPath != "" {
+		pool, err := loadCertPool(q.CaCertPath)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.RootCAs = pool
 	}
+	return tlsConfig, nil
+}
 
-	defer client.Close(ctx)
+// makeRequest calls fn with the query timeout of q and returns the response
+// as JSON. The bmclib client fn belongs to must already be open.
+func makeRequest[T any](ctx context.Context, fn func(context.Context) (T, error), q *QueryParams) ([]byte, error) {
+	ctx, ctxCancel := context.WithTimeout(ctx, q.queryTimeout())
+	defer ctxCancel()
 
 	response, err := fn(ctx)
 	if err != nil {
-		ctxCancel()
 		return nil, fmt.Errorf("failed to get response: %v", err)
 	}
 
-	ctxCancel()
 	return makeJson(response)
 }
 
@@ -735,5 +3184,14 @@ func baseRedfishUrl(q *QueryParams) string {
 	if q.User != "" && q.Pass != "" {
 		url += fmt.Sprintf("%s:%s@", q.User, q.Pass)
 	}
-	return fmt.Sprintf("%s%s:%d", url, q.Host, q.Port)
+	return fmt.Sprintf("%s%s:%d", url, urlHost(q.Host), q.Port)
+}
+
+// urlHost wraps IPv6 addresses in brackets so a port can be appended and
+// the result used in a URL. Hostnames and IPv4 addresses are returned as-is.
+func urlHost(host string) string {
+	if ip := net.ParseIP(host); ip != nil && ip.To4() == nil {
+		return "[" + host + "]"
+	}
+	return host
 }
@@ -0,0 +1,2250 @@
+package magellan
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/OpenCHAMI/magellan/internal/util"
+)
+
+// decodeSection unmarshals the output of an exported collect function (or a
+// collected payload) and returns the value under key.
+func decodeSection(t *testing.T, b []byte, key string) []map[string]any {
+	t.Helper()
+	var output map[string]json.RawMessage
+	err := json.Unmarshal(b, &output)
+	if err != nil {
+		t.Fatalf("failed to unmarshal output: %v\n%s", err, b)
+	}
+	raw, ok := output[key]
+	if !ok {
+		t.Fatalf("missing %s in output:\n%s", key, b)
+	}
+	var value []map[string]any
+	err = json.Unmarshal(raw, &value)
+	if err != nil {
+		t.Fatalf("failed to unmarshal %s: %v\n%s", key, err, b)
+	}
+	return value
+}
+
+// macs returns the sorted MAC addresses of the ethernet interfaces in the
+// output since the members of a collection are requested concurrently.
+func macs(interfaces []any) []string {
+	found := []string{}
+	for _, eth := range interfaces {
+		if eth, ok := eth.(map[string]any); ok {
+			found = append(found, eth["MACAddress"].(string))
+		}
+	}
+	sort.Strings(found)
+	return found
+}
+
+func TestCollectSystems(t *testing.T) {
+	f := newRedfishFixture(t)
+	q := f.params(t)
+	c := f.connect(t, q)
+
+	b, err := CollectSystems(c, testLogger(), q)
+	if err != nil {
+		t.Fatalf("failed to collect systems: %v", err)
+	}
+	systems := decodeSection(t, b, "Systems")
+	if len(systems) != 1 {
+		t.Fatalf("expected 1 system, got %d:\n%s", len(systems), b)
+	}
+	data, _ := systems[0]["Data"].(map[string]any)
+	if data["Id"] != "1" || data["Name"] != "Node 1" || data["PowerState"] != "On" {
+		t.Errorf("unexpected system data: %v", data)
+	}
+	interfaces, _ := systems[0]["EthernetInterfaces"].([]any)
+	if got := macs(interfaces); len(got) != 1 || got[0] != "AA:BB:CC:DD:EE:01" {
+		t.Errorf("expected the interface of the system, got %v", got)
+	}
+	if _, ok := systems[0]["AggregationSource"]; ok {
+		t.Errorf("unexpected aggregation source without an aggregation service")
+	}
+}
+
+func TestCollectSystemsFallsBackToManagers(t *testing.T) {
+	f := newRedfishFixture(t)
+	f.set("/redfish/v1/Systems/1/EthernetInterfaces", collection("/redfish/v1/Systems/1/EthernetInterfaces"))
+	q := f.params(t)
+	c := f.connect(t, q)
+
+	b, err := CollectSystems(c, testLogger(), q)
+	if err != nil {
+		t.Fatalf("failed to collect systems: %v", err)
+	}
+	systems := decodeSection(t, b, "Systems")
+	if len(systems) != 1 {
+		t.Fatalf("expected 1 system, got %d:\n%s", len(systems), b)
+	}
+	interfaces, _ := systems[0]["EthernetInterfaces"].([]any)
+	if got := macs(interfaces); len(got) != 1 || got[0] != "AA:BB:CC:DD:EE:00" {
+		t.Errorf("expected the interface of the manager, got %v", got)
+	}
+}
+
+func TestCollectChassis(t *testing.T) {
+	f := newRedfishFixture(t)
+	q := f.params(t)
+	c := f.connect(t, q)
+
+	b, err := CollectChassis(c, testLogger(), q)
+	if err != nil {
+		t.Fatalf("failed to collect chassis: %v", err)
+	}
+	chassis := decodeSection(t, b, "Chassis")
+	if len(chassis) != 1 {
+		t.Fatalf("expected 1 chassis, got %d:\n%s", len(chassis), b)
+	}
+	if chassis[0]["Id"] != "1" || chassis[0]["ChassisType"] != "RackMount" {
+		t.Errorf("unexpected chassis: %v", chassis[0])
+	}
+}
+
+func TestCollectChassisEmpty(t *testing.T) {
+	f := newRedfishFixture(t)
+	f.set("/redfish/v1/Chassis", collection("/redfish/v1/Chassis"))
+	q := f.params(t)
+	c := f.connect(t, q)
+
+	b, err := CollectChassis(c, testLogger(), q)
+	if err != nil {
+		t.Fatalf("failed to collect chassis: %v", err)
+	}
+	if chassis := decodeSection(t, b, "Chassis"); chassis == nil || len(chassis) != 0 {
+		t.Errorf("expected an empty list of chassis, got:\n%s", b)
+	}
+}
+
+func TestCollectEthernetInterfaces(t *testing.T) {
+	f := newRedfishFixture(t)
+
+	// the same interface reported by the manager and system is listed once
+	f.set("/redfish/v1/Systems/1/EthernetInterfaces", collection("/redfish/v1/Systems/1/EthernetInterfaces",
+		"/redfish/v1/Systems/1/EthernetInterfaces/eth0",
+		"/redfish/v1/Systems/1/EthernetInterfaces/eth1",
+	))
+	f.set("/redfish/v1/Systems/1/EthernetInterfaces/eth1", map[string]any{
+		"@odata.id":  "/redfish/v1/Systems/1/EthernetInterfaces/eth1",
+		"Id":         "eth1",
+		"MACAddress": "aa-bb-cc-dd-ee-00",
+	})
+	q := f.params(t)
+	c := f.connect(t, q)
+
+	tests := []struct {
+		name     string
+		systemID string
+		expected []string
+	}{
+		{"all", "", []string{"AA:BB:CC:DD:EE:00", "AA:BB:CC:DD:EE:01"}},
+		{"one system", "1", []string{"AA:BB:CC:DD:EE:01", "aa-bb-cc-dd-ee-00"}},
+		{"unknown system", "2", []string{}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			b, err := CollectEthernetInterfaces(c, testLogger(), q, test.systemID)
+			if err != nil {
+				t.Fatalf("failed to collect ethernet interfaces: %v", err)
+			}
+			var output map[string][]any
+			err = json.Unmarshal(b, &output)
+			if err != nil {
+				t.Fatalf("failed to unmarshal ethernet interfaces: %v", err)
+			}
+			got := macs(output["EthernetInterfaces"])
+			if len(got) != len(test.expected) {
+				t.Fatalf("expected %v, got %v", test.expected, got)
+			}
+			for i := range got {
+				if got[i] != test.expected[i] {
+					t.Fatalf("expected %v, got %v", test.expected, got)
+				}
+			}
+		})
+	}
+}
+
+func TestCollectEthernetInterfacesPaths(t *testing.T) {
+	f := newRedfishFixture(t)
+	q := f.params(t)
+	c := f.connect(t, q)
+
+	// only the configured collections are read (the missing one is skipped)
+	q.EthernetPaths = []string{"/redfish/v1/Managers/BMC/EthernetInterfaces", "/redfish/v1/Missing/EthernetInterfaces"}
+	b, err := CollectEthernetInterfaces(c, testLogger(), q, "")
+	if err != nil {
+		t.Fatalf("failed to collect ethernet interfaces: %v", err)
+	}
+	var output map[string][]any
+	err = json.Unmarshal(b, &output)
+	if err != nil {
+		t.Fatalf("failed to unmarshal ethernet interfaces: %v", err)
+	}
+	if got := macs(output["EthernetInterfaces"]); len(got) != 1 || got[0] != "AA:BB:CC:DD:EE:00" {
+		t.Errorf("expected the interface of the manager, got %v", got)
+	}
+
+	// and it is an error when none of them can be read
+	q.EthernetPaths = []string{"/redfish/v1/Missing/EthernetInterfaces"}
+	_, err = CollectEthernetInterfaces(c, testLogger(), q, "")
+	if err == nil {
+		t.Errorf("expected an error when no collection could be read")
+	}
+}
+
+// fleet returns the probe states of n fake hosts which are all served by
+// the fixture when collecting with its transport.
+func fleet(f *redfishFixture, n int) []ScannedResult {
+	_, port := f.hostPort()
+	states := make([]ScannedResult, 0, n)
+	for i := 0; i < n; i++ {
+		states = append(states, ScannedResult{
+			Host:     fmt.Sprintf("10.0.%d.%d", i/250, i%250+1),
+			Port:     port,
+			Protocol: "http",
+			State:    true,
+		})
+	}
+	return states
+}
+
+// outputFiles returns the paths of the output files written with q which
+// are in a directory named after the time of the run.
+func outputFiles(t *testing.T, q *QueryParams) []string {
+	t.Helper()
+	files, err := filepath.Glob(filepath.Join(q.OutputPath, "*", "*.json"))
+	if err != nil {
+		t.Fatalf("failed to list output files: %v", err)
+	}
+	return files
+}
+
+// slowSink blocks every write until released.
+type slowSink struct {
+	release chan struct{}
+	mu      sync.Mutex
+	written []string
+}
+
+func (s *slowSink) Write(result CollectResult) error {
+	<-s.release
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.written = append(s.written, result.Host)
+	return nil
+}
+
+func TestCollectAllSlowSink(t *testing.T) {
+	f := newRedfishFixture(t)
+	states := fleet(f, 20)
+	sink := &slowSink{release: make(chan struct{})}
+
+	var started atomic.Int32
+	q := f.params(t)
+	q.Transport = f.transport()
+	q.Concurrency = 4
+	q.ResultBuffer = 1
+	q.Sinks = []OutputSink{sink}
+	q.Progress = func(event CollectEvent) {
+		if event.Type == EVENT_HOST_START {
+			started.Add(1)
+		}
+	}
+
+	type collected struct {
+		results []CollectResult
+		err     error
+	}
+	done := make(chan collected)
+	go func() {
+		results, err := CollectAll(context.Background(), &states, testLogger(), q)
+		done <- collected{results, err}
+	}()
+
+	// while the sink is stuck, one host is being written, one is buffered,
+	// and each worker holds on to the host it finished
+	limit := int32(1 + q.ResultBuffer + q.Concurrency)
+	deadline := time.Now().Add(5 * time.Second)
+	for started.Load() < limit && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	time.Sleep(200 * time.Millisecond)
+	if n := started.Load(); n > limit {
+		t.Fatalf("expected at most %d hosts collected while the sink is blocked, got %d", limit, n)
+	}
+
+	close(sink.release)
+	select {
+	case c := <-done:
+		if c.err != nil {
+			t.Fatalf("failed to collect: %v", c.err)
+		}
+		if len(c.results) != len(states) {
+			t.Fatalf("expected %d results, got %d", len(states), len(c.results))
+		}
+		for _, result := range c.results {
+			if !result.Success {
+				t.Errorf("failed to collect from %s: %s", result.Host, result.Error)
+			}
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatalf("collection did not finish after releasing the sink")
+	}
+	if len(sink.written) != len(states) {
+		t.Fatalf("expected %d hosts written, got %d", len(states), len(sink.written))
+	}
+}
+
+func TestCollectAllDuplicateHosts(t *testing.T) {
+	f := newRedfishFixture(t)
+	unique := fleet(f, 10)
+
+	// every host is found twice and once more on another port
+	states := []ScannedResult{}
+	for _, ps := range unique {
+		other := ps
+		other.Port = HTTPS_PORT
+		states = append(states, ps, ps, other)
+	}
+
+	var (
+		mu      sync.Mutex
+		started = map[string]int{}
+	)
+	q := f.params(t)
+	q.Transport = f.transport()
+	q.Concurrency = 8
+	q.Progress = func(event CollectEvent) {
+		if event.Type == EVENT_HOST_START {
+			mu.Lock()
+			started[event.Host]++
+			mu.Unlock()
+		}
+	}
+	results, err := CollectAll(context.Background(), &states, testLogger(), q)
+	if err != nil {
+		t.Fatalf("failed to collect: %v", err)
+	}
+	if len(results) != len(unique) {
+		t.Fatalf("expected %d results, got %d", len(unique), len(results))
+	}
+	for _, ps := range unique {
+		if started[ps.Host] != 1 {
+			t.Errorf("expected %s to be collected once, got %d", ps.Host, started[ps.Host])
+		}
+	}
+	if logins, _ := f.sessions(); logins != len(unique) {
+		t.Errorf("expected %d sessions, got %d", len(unique), logins)
+	}
+}
+
+// BenchmarkCollectHostSessions reports the number of Redfish sessions opened
+// for each host which stays at one no matter how many sections are
+// collected since they all share the same session.
+func BenchmarkCollectHostSessions(b *testing.B) {
+	f := newRedfishFixture(b)
+	states := fleet(f, 1)
+	q := f.params(b)
+	q.Transport = f.transport()
+	q.CollectServiceRoot = true
+	q.CollectManagers = true
+	q.CollectEthernet = true
+	q.CollectFirmware = true
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_, err := CollectAll(context.Background(), &states, testLogger(), q)
+		if err != nil {
+			b.Fatalf("failed to collect: %v", err)
+		}
+	}
+	b.StopTimer()
+
+	logins, logouts := f.sessions()
+	if logins != logouts {
+		b.Fatalf("expected every session to be closed, got %d opened and %d closed", logins, logouts)
+	}
+	b.ReportMetric(float64(2+len(q.sectionNames())), "sections/host")
+	b.ReportMetric(float64(logins)/float64(b.N), "sessions/host")
+}
+
+func TestCollectAllUniqueXnames(t *testing.T) {
+	f := newRedfishFixture(t)
+	states := fleet(f, 100)
+	q := f.params(t)
+	q.Transport = f.transport()
+	q.Concurrency = 16
+
+	results, err := CollectAll(context.Background(), &states, testLogger(), q)
+	if err != nil {
+		t.Fatalf("failed to collect: %v", err)
+	}
+	if len(results) != len(states) {
+		t.Fatalf("expected %d results, got %d", len(states), len(results))
+	}
+
+	// xnames follow the order hosts were dispatched in whichever worker
+	// finishes first
+	seen := map[string]string{}
+	for _, result := range results {
+		if host, found := seen[result.Xname]; found {
+			t.Errorf("xname %s given to both %s and %s", result.Xname, host, result.Host)
+		}
+		seen[result.Xname] = result.Host
+	}
+	for i, ps := range states {
+		expected := fmt.Sprintf("x1000c1s7b%d", i)
+		if seen[expected] != ps.Host {
+			t.Errorf("expected %s to be %s, got %s", ps.Host, expected, seen[expected])
+		}
+	}
+}
+
+// hang makes requests to path block until the request is cancelled or the
+// test ends.
+func hang(t *testing.T, f *redfishFixture, path string) {
+	stop := make(chan struct{})
+	t.Cleanup(func() { close(stop) })
+	f.handle(path, func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-stop:
+		}
+	})
+}
+
+func TestCollectAllCancel(t *testing.T) {
+	f := newRedfishFixture(t)
+	hang(t, f, "/redfish/v1/Systems")
+	states := fleet(f, 20)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	q := f.params(t)
+	q.Transport = f.transport()
+	q.Concurrency = 2
+	q.Timeout = 30
+	q.Progress = func(event CollectEvent) {
+		if event.Type == EVENT_SECTION_DONE && event.Section == "Chassis" {
+			cancel()
+		}
+	}
+
+	start := time.Now()
+	results, err := CollectAll(ctx, &states, testLogger(), q)
+	if err == nil {
+		t.Fatalf("expected an error after cancelling")
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Fatalf("expected to return promptly after cancelling, took %v", elapsed)
+	}
+	if len(results) >= len(states) {
+		t.Errorf("expected the remaining hosts to be skipped, got %d results", len(results))
+	}
+}
+
+func TestValidate(t *testing.T) {
+	valid := func() *QueryParams {
+		return &QueryParams{
+			Host:    "10.0.0.1",
+			Port:    HTTPS_PORT,
+			Drivers: []string{"redfish"},
+			Timeout: 5,
+		}
+	}
+	tests := []struct {
+		name   string
+		modify func(q *QueryParams)
+		valid  bool
+	}{
+		{"valid", func(q *QueryParams) {}, true},
+		{"no host or port", func(q *QueryParams) { q.Host, q.Port = "", 0 }, true},
+		{"default IPMI port", func(q *QueryParams) { q.IpmiPort = 0 }, true},
+		{"zero timeout", func(q *QueryParams) { q.Timeout = 0 }, false},
+		{"negative timeout", func(q *QueryParams) { q.Timeout = -1 }, false},
+		{"negative query timeout", func(q *QueryParams) { q.QueryTimeout = -time.Second }, false},
+		{"port 0", func(q *QueryParams) { q.Port = 0 }, false},
+		{"negative port", func(q *QueryParams) { q.Port = -1 }, false},
+		{"port too large", func(q *QueryParams) { q.Port = 65536 }, false},
+		{"IPMI port too large", func(q *QueryParams) { q.IpmiPort = 65536 }, false},
+		{"no driver", func(q *QueryParams) { q.Drivers = nil }, false},
+		{"empty driver", func(q *QueryParams) { q.Drivers = []string{""} }, false},
+		{"missing CA cert", func(q *QueryParams) { q.SecureTLS, q.CaCertPath = true, "/nonexistent/ca.crt" }, false},
+		{"unknown output format", func(q *QueryParams) { q.OutputFormat = "xml" }, false},
+		{"unknown section", func(q *QueryParams) { q.Sections = []string{"Nope"} }, false},
+		{"skip unchanged without a state cache", func(q *QueryParams) { q.SkipUnchanged = true }, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			q := valid()
+			test.modify(q)
+			err := q.Validate()
+			if test.valid && err != nil {
+				t.Fatalf("expected valid params, got %v", err)
+			}
+			if !test.valid && err == nil {
+				t.Fatalf("expected invalid params")
+			}
+		})
+	}
+
+	// every problem is listed at once
+	q := &QueryParams{Host: "10.0.0.1"}
+	err := q.Validate()
+	if err == nil {
+		t.Fatalf("expected invalid params")
+	}
+	for _, problem := range []string{"timeout", "port 0", "driver"} {
+		if !strings.Contains(err.Error(), problem) {
+			t.Errorf("expected %q in %q", problem, err.Error())
+		}
+	}
+}
+
+func TestSecureTLS(t *testing.T) {
+	f := newRedfishTLSFixture(t)
+
+	// the self-signed certificate of the fixture as a CA
+	caCertPath := filepath.Join(t.TempDir(), "ca.crt")
+	err := os.WriteFile(caCertPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: f.Certificate().Raw}), 0o600)
+	if err != nil {
+		t.Fatalf("failed to write CA cert: %v", err)
+	}
+
+	connectors := []struct {
+		name    string
+		connect func(s *Session) error
+	}{
+		{"gofish", func(s *Session) error {
+			_, err := s.Redfish(context.Background())
+			return err
+		}},
+		{"bmclib", func(s *Session) error {
+			_, err := s.BMC(context.Background())
+			return err
+		}},
+	}
+	tests := []struct {
+		name       string
+		secure     bool
+		caCertPath string
+		accepted   bool
+	}{
+		{"insecure", false, "", true},
+		{"secure with system roots", true, "", false},
+		{"secure with CA", true, caCertPath, true},
+	}
+	for _, connector := range connectors {
+		for _, test := range tests {
+			t.Run(connector.name+"/"+test.name, func(t *testing.T) {
+				q := f.params(t)
+				q.SecureTLS = test.secure
+				q.CaCertPath = test.caCertPath
+				session := NewSession(testLogger(), q)
+				defer session.Close(context.Background())
+
+				err := connector.connect(session)
+				if test.accepted && err != nil {
+					t.Fatalf("expected the certificate to be accepted, got %v", err)
+				}
+				if !test.accepted && (err == nil || !strings.Contains(err.Error(), "certificate")) {
+					t.Fatalf("expected the self-signed certificate to be rejected, got %v", err)
+				}
+			})
+		}
+	}
+}
+
+func TestCollectAllGlobalTimeout(t *testing.T) {
+	f := newRedfishFixture(t)
+	hang(t, f, "/redfish/v1/Systems")
+	states := fleet(f, 4)
+
+	var (
+		mu   sync.Mutex
+		done = map[string]int{}
+	)
+	q := f.params(t)
+	q.Transport = f.transport()
+	q.Concurrency = 2
+	q.Timeout = 30
+	q.GlobalTimeout = 300 * time.Millisecond
+	q.Progress = func(event CollectEvent) {
+		if event.Type == EVENT_HOST_DONE {
+			mu.Lock()
+			done[event.Host]++
+			mu.Unlock()
+		}
+	}
+
+	start := time.Now()
+	results, _ := CollectAll(context.Background(), &states, testLogger(), q)
+	if elapsed := time.Since(start); elapsed > q.GlobalTimeout+2*time.Second {
+		t.Fatalf("expected to return at the deadline, took %v", elapsed)
+	}
+
+	// the hosts still running are reported as timed out once
+	if len(results) != len(states) {
+		t.Fatalf("expected %d results, got %d", len(states), len(results))
+	}
+	for _, result := range results {
+		if result.Success {
+			t.Errorf("expected %s to fail past the deadline", result.Host)
+		}
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	for _, ps := range states {
+		if done[ps.Host] != 1 {
+			t.Errorf("expected %s to be reported done once, got %d", ps.Host, done[ps.Host])
+		}
+	}
+}
+
+func TestCollectAllCancelBeforeGlobalTimeout(t *testing.T) {
+	f := newRedfishFixture(t)
+	hang(t, f, "/redfish/v1/Systems")
+	states := fleet(f, 2)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	q := f.params(t)
+	q.Transport = f.transport()
+	q.Concurrency = 2
+	q.Timeout = 30
+	q.GlobalTimeout = time.Minute
+	time.AfterFunc(300*time.Millisecond, cancel)
+
+	// cancelling is not the same as running out of time
+	results, err := CollectAll(ctx, &states, testLogger(), q)
+	if err == nil {
+		t.Fatalf("expected an error after cancelling")
+	}
+	for _, result := range results {
+		if result.Stage == "timeout" {
+			t.Errorf("expected %s to not be reported as timed out", result.Host)
+		}
+	}
+}
+
+// aggregate makes the fixture an aggregating BMC with a source for each
+// node giving access to a system that is not in the Systems collection.
+func aggregate(f *redfishFixture, nodes ...string) {
+	f.merge("/redfish/v1", map[string]any{"AggregationService": link("/redfish/v1/AggregationService")})
+	f.set("/redfish/v1/AggregationService", map[string]any{
+		"@odata.id":          "/redfish/v1/AggregationService",
+		"Id":                 "AggregationService",
+		"AggregationSources": link("/redfish/v1/AggregationService/AggregationSources"),
+	})
+	sources := []string{}
+	for i, node := range nodes {
+		source := "/redfish/v1/AggregationService/AggregationSources/" + node
+		system := "/redfish/v1/Systems/" + node
+		sources = append(sources, source)
+		f.set(source, map[string]any{
+			"@odata.id":       source,
+			"Id":              node,
+			"Name":            "Source " + node,
+			"HostName":        fmt.Sprintf("https://10.1.0.%d", i+1),
+			"AggregationType": "Full",
+			"Links": map[string]any{
+				"ResourcesAccessed": []any{link(system), link("/redfish/v1/Chassis/" + node)},
+			},
+		})
+		f.set(system, map[string]any{
+			"@odata.id":          system,
+			"Id":                 node,
+			"Name":               "Node " + node,
+			"EthernetInterfaces": link(system + "/EthernetInterfaces"),
+		})
+		f.set(system+"/EthernetInterfaces", collection(system+"/EthernetInterfaces"))
+	}
+	f.set("/redfish/v1/AggregationService/AggregationSources", collection("/redfish/v1/AggregationService/AggregationSources", sources...))
+}
+
+func TestCollectSystemsAggregation(t *testing.T) {
+	f := newRedfishFixture(t)
+	aggregate(f, "node2", "node3")
+	q := f.params(t)
+	c := f.connect(t, q)
+
+	b, err := CollectSystems(c, testLogger(), q)
+	if err != nil {
+		t.Fatalf("failed to collect systems: %v", err)
+	}
+	systems := decodeSection(t, b, "Systems")
+	if len(systems) != 3 {
+		t.Fatalf("expected the local system and 2 nodes, got %d:\n%s", len(systems), b)
+	}
+
+	// each node is its own entry with the source it was reached through
+	// (in any order since the sources are requested concurrently)
+	if _, ok := systems[0]["AggregationSource"]; ok {
+		t.Errorf("unexpected aggregation source on the local system")
+	}
+	hostNames := map[string]string{"node2": "https://10.1.0.1", "node3": "https://10.1.0.2"}
+	for _, system := range systems[1:] {
+		data, _ := system["Data"].(map[string]any)
+		node, _ := data["Id"].(string)
+		hostName, found := hostNames[node]
+		if !found {
+			t.Errorf("unexpected system %s", node)
+			continue
+		}
+		delete(hostNames, node)
+		source, _ := system["AggregationSource"].(map[string]any)
+		if source["ID"] != node || source["HostName"] != hostName || source["AggregationType"] != "Full" {
+			t.Errorf("unexpected aggregation source of %s: %v", node, source)
+		}
+	}
+	if len(hostNames) > 0 {
+		t.Errorf("missing systems of %v", hostNames)
+	}
+}
+
+// stream sends the probe states of n fake hosts without materializing them.
+func stream(f *redfishFixture, n int) <-chan ScannedResult {
+	_, port := f.hostPort()
+	states := make(chan ScannedResult)
+	go func() {
+		defer close(states)
+		for i := 0; i < n; i++ {
+			states <- ScannedResult{
+				Host:     fmt.Sprintf("10.%d.%d.%d", i/62500, i/250%250, i%250+1),
+				Port:     port,
+				Protocol: "http",
+				State:    true,
+			}
+		}
+	}()
+	return states
+}
+
+func TestCollectStream(t *testing.T) {
+	f := newRedfishFixture(t)
+	q := f.params(t)
+	q.Transport = f.transport()
+	q.Concurrency = 8
+
+	results, err := CollectStream(context.Background(), stream(f, 50), testLogger(), q)
+	if err != nil {
+		t.Fatalf("failed to collect: %v", err)
+	}
+	if len(results) != 50 {
+		t.Fatalf("expected 50 results, got %d", len(results))
+	}
+
+	// only a small record of each host is kept once it is written
+	for _, result := range results {
+		if !result.Success || result.Payload != nil {
+			t.Errorf("expected %s to be collected without keeping its payload, got %+v", result.Host, result)
+		}
+	}
+	if files := outputFiles(t, q); len(files) != 50 {
+		t.Errorf("expected 50 output files, got %d", len(files))
+	}
+}
+
+// BenchmarkCollectStream streams b.N hosts through a single collection so
+// the allocations reported per op are the ones made for each host, which
+// stay the same whatever the size of the fleet.
+func BenchmarkCollectStream(b *testing.B) {
+	f := newRedfishFixture(b)
+	q := f.params(b)
+	q.Transport = f.transport()
+	q.Concurrency = 16
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	results, err := CollectStream(context.Background(), stream(f, b.N), testLogger(), q)
+	if err != nil {
+		b.Fatalf("failed to collect: %v", err)
+	}
+	if len(results) != b.N {
+		b.Fatalf("expected %d results, got %d", b.N, len(results))
+	}
+}
+
+// BenchmarkMarshalOutput compares marshalling the output of a host with a
+// large inventory once against marshalling each section and decoding it
+// back before marshalling the output as was done before.
+func BenchmarkMarshalOutput(b *testing.B) {
+	f := newRedfishFixture(b)
+	members := []string{}
+	for i := 0; i < 500; i++ {
+		path := fmt.Sprintf("/redfish/v1/Systems/1/EthernetInterfaces/%d", i)
+		members = append(members, path)
+		f.set(path, map[string]any{
+			"@odata.id":  path,
+			"Id":         fmt.Sprint(i),
+			"MACAddress": fmt.Sprintf("aa:bb:cc:dd:%02x:%02x", i/256, i%256),
+		})
+	}
+	f.set("/redfish/v1/Systems/1/EthernetInterfaces", collection("/redfish/v1/Systems/1/EthernetInterfaces", members...))
+	q := f.params(b)
+	c := f.connect(b, q)
+	systems, err := collectSystems(c, testLogger(), q)
+	if err != nil {
+		b.Fatalf("failed to collect systems: %v", err)
+	}
+
+	b.Run("once", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			_, err := q.marshalOutput(map[string]any{"ID": "x1000c1s7b0", "Systems": systems})
+			if err != nil {
+				b.Fatalf("failed to marshal output: %v", err)
+			}
+		}
+	})
+	b.Run("round trip", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			section, err := marshalSection("Systems", systems)
+			if err != nil {
+				b.Fatalf("failed to marshal section: %v", err)
+			}
+			var raw map[string]json.RawMessage
+			err = json.Unmarshal(section, &raw)
+			if err != nil {
+				b.Fatalf("failed to unmarshal section: %v", err)
+			}
+			_, err = q.marshalOutput(map[string]any{"ID": "x1000c1s7b0", "Systems": raw["Systems"]})
+			if err != nil {
+				b.Fatalf("failed to marshal output: %v", err)
+			}
+		}
+	})
+}
+
+func TestCollectAllMatchesExportedCollectors(t *testing.T) {
+	f := newRedfishFixture(t)
+	q := f.params(t)
+	q.CollectServiceRoot = true
+	q.CollectManagers = true
+	q.CollectEthernet = true
+	host, port := f.hostPort()
+	states := []ScannedResult{{Host: host, Port: port, Protocol: "http", State: true}}
+
+	results, err := CollectAll(context.Background(), &states, testLogger(), q)
+	if err != nil {
+		t.Fatalf("failed to collect: %v", err)
+	}
+	if len(results) != 1 || !results[0].Success {
+		t.Fatalf("expected the host to be collected, got %+v", results)
+	}
+
+	// the file has the same bytes as the payload
+	files := outputFiles(t, q)
+	if len(files) != 1 {
+		t.Fatalf("expected 1 output file, got %d", len(files))
+	}
+	b, err := os.ReadFile(files[0])
+	if err != nil {
+		t.Fatalf("failed to read output file: %v", err)
+	}
+	if string(b) != string(results[0].Payload) {
+		t.Errorf("output file does not match the payload:\n%s\n%s", b, results[0].Payload)
+	}
+
+	// each section is the same as returned by the exported functions
+	var payload map[string]any
+	err = json.Unmarshal(results[0].Payload, &payload)
+	if err != nil {
+		t.Fatalf("failed to unmarshal payload: %v", err)
+	}
+	c := f.connect(t, q)
+	l := testLogger()
+	collectors := map[string]func() ([]byte, error){
+		"Chassis":            func() ([]byte, error) { return CollectChassis(c, l, q) },
+		"Systems":            func() ([]byte, error) { return CollectSystems(c, l, q) },
+		"ServiceRoot":        func() ([]byte, error) { return CollectServiceRoot(c, q) },
+		"Managers":           func() ([]byte, error) { return CollectManagers(c, l, q) },
+		"EthernetInterfaces": func() ([]byte, error) { return CollectEthernetInterfaces(c, l, q, "") },
+	}
+	for key, collect := range collectors {
+		b, err := collect()
+		if err != nil {
+			t.Fatalf("failed to collect %s: %v", key, err)
+		}
+		var section map[string]any
+		err = json.Unmarshal(b, &section)
+		if err != nil {
+			t.Fatalf("failed to unmarshal %s: %v", key, err)
+		}
+		if !reflect.DeepEqual(section[key], payload[key]) {
+			t.Errorf("%s differs from the output:\n%v\n%v", key, section[key], payload[key])
+		}
+	}
+}
+
+func TestCollectAllEmptySystems(t *testing.T) {
+	f := newRedfishFixture(t)
+	f.set("/redfish/v1/Systems", collection("/redfish/v1/Systems"))
+	q := f.params(t)
+
+	// listed as an empty array rather than null
+	b, err := CollectSystems(f.connect(t, q), testLogger(), q)
+	if err != nil {
+		t.Fatalf("failed to collect systems: %v", err)
+	}
+	if systems := decodeSection(t, b, "Systems"); systems == nil || len(systems) != 0 {
+		t.Errorf("expected an empty list of systems, got:\n%s", b)
+	}
+
+	// the BMC is still recorded
+	host, port := f.hostPort()
+	states := []ScannedResult{{Host: host, Port: port, Protocol: "http", State: true}}
+	results, err := CollectAll(context.Background(), &states, testLogger(), q)
+	if err != nil {
+		t.Fatalf("failed to collect: %v", err)
+	}
+	if len(results) != 1 || !results[0].Success {
+		t.Fatalf("expected the host to be collected, got %+v", results)
+	}
+	var data map[string]any
+	err = json.Unmarshal(results[0].Payload, &data)
+	if err != nil {
+		t.Fatalf("failed to unmarshal payload: %v", err)
+	}
+	if systems, ok := data["Systems"].([]any); !ok || len(systems) != 0 {
+		t.Errorf("expected an empty list of systems, got %v", data["Systems"])
+	}
+	if data["ID"] != "x1000c1s7b0" || data["Chassis"] == nil {
+		t.Errorf("expected a record with the ID and chassis, got %v", data)
+	}
+}
+
+func TestCollectAllCompact(t *testing.T) {
+	f := newRedfishFixture(t)
+	host, port := f.hostPort()
+	tests := []struct {
+		name     string
+		compact  bool
+		envelope bool
+	}{
+		{name: "indented", compact: false},
+		{name: "compact", compact: true},
+		{name: "indented envelope", compact: false, envelope: true},
+		{name: "compact envelope", compact: true, envelope: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			q := f.params(t)
+			q.Compact = test.compact
+			q.Envelope = test.envelope
+			states := []ScannedResult{{Host: host, Port: port, Protocol: "http", State: true}}
+			_, err := CollectAll(context.Background(), &states, testLogger(), q)
+			if err != nil {
+				t.Fatalf("failed to collect: %v", err)
+			}
+			files := outputFiles(t, q)
+			if len(files) != 1 {
+				t.Fatalf("expected 1 output file, got %v", files)
+			}
+			b, err := os.ReadFile(files[0])
+			if err != nil {
+				t.Fatalf("failed to read output file: %v", err)
+			}
+			if !json.Valid(b) {
+				t.Fatalf("expected valid JSON, got:\n%s", b)
+			}
+			if newlines := bytes.Contains(b, []byte("\n")); newlines == test.compact {
+				t.Errorf("expected newlines to be %v, got:\n%s", !test.compact, b)
+			}
+		})
+	}
+}
+
+// dropConnections closes the connection of the first n requests to path
+// without responding and serves the others normally.
+func dropConnections(f *redfishFixture, path string, n int) {
+	var dropped atomic.Int32
+	f.handle(path, func(w http.ResponseWriter, r *http.Request) {
+		if dropped.Add(1) > int32(n) {
+			f.serve(w, r)
+			return
+		}
+		conn, _, err := w.(http.Hijacker).Hijack()
+		if err == nil {
+			conn.Close()
+		}
+	})
+}
+
+func TestCollectAllRetries(t *testing.T) {
+	tests := []struct {
+		name    string
+		retries int
+		success bool
+	}{
+		{"enough retries", 2, true},
+		{"too few retries", 1, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			f := newRedfishFixture(t)
+			dropConnections(f, "/redfish/v1/SessionService/Sessions", 2)
+			q := f.params(t)
+			q.Retries = test.retries
+			q.RetryDelay = time.Millisecond
+			host, port := f.hostPort()
+			states := []ScannedResult{{Host: host, Port: port, Protocol: "http", State: true}}
+
+			results, err := CollectAll(context.Background(), &states, testLogger(), q)
+			if err != nil {
+				t.Fatalf("failed to collect: %v", err)
+			}
+			if len(results) != 1 || results[0].Success != test.success {
+				t.Fatalf("expected success to be %v, got %+v", test.success, results)
+			}
+			if attempts := f.count("/redfish/v1/SessionService/Sessions"); attempts != test.retries+1 {
+				t.Errorf("expected %d attempts, got %d", test.retries+1, attempts)
+			}
+			if !test.success && results[0].Stage != "connect" {
+				t.Errorf("expected the host to fail to connect, got stage %q", results[0].Stage)
+			}
+		})
+	}
+}
+
+// busy makes the fixture respond to the first n requests to path with 503
+// and a "Retry-After" of retryAfter before serving it normally (n < 0 stays
+// busy).
+func busy(f *redfishFixture, path string, retryAfter string, n int) {
+	var requests atomic.Int32
+	f.handle(path, func(w http.ResponseWriter, r *http.Request) {
+		if n < 0 || int(requests.Add(1)) <= n {
+			w.Header().Set("Retry-After", retryAfter)
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		f.serve(w, r)
+	})
+}
+
+func TestCollectAllBusyRetries(t *testing.T) {
+	// retried as long as the BMC asks to
+	f := newRedfishFixture(t)
+	busy(f, "/redfish/v1/Systems", "0", 2)
+	q := f.params(t)
+	q.BusyRetries = 2
+	host, port := f.hostPort()
+	states := []ScannedResult{{Host: host, Port: port, Protocol: "http", State: true}}
+	results, err := CollectAll(context.Background(), &states, testLogger(), q)
+	if err != nil {
+		t.Fatalf("failed to collect: %v", err)
+	}
+	if len(results) != 1 || !results[0].Success {
+		t.Fatalf("expected the host to be collected after retrying, got %+v", results)
+	}
+	if requests := f.count("/redfish/v1/Systems"); requests != 3 {
+		t.Errorf("expected 3 requests to systems, got %d", requests)
+	}
+
+	// the time waited is capped for the whole host rather than per request
+	// so the systems are not waited on once the chassis used it up
+	f = newRedfishFixture(t)
+	busy(f, "/redfish/v1/Chassis", "1", -1)
+	busy(f, "/redfish/v1/Systems", "1", -1)
+	q = f.params(t)
+	q.BusyRetries = 5
+	q.QueryTimeout = 1500 * time.Millisecond
+	host, port = f.hostPort()
+	states = []ScannedResult{{Host: host, Port: port, Protocol: "http", State: true}}
+	start := time.Now()
+	results, err = CollectAll(context.Background(), &states, testLogger(), q)
+	if err != nil {
+		t.Fatalf("failed to collect: %v", err)
+	}
+	if len(results) != 1 || results[0].Success {
+		t.Fatalf("expected the host to fail while busy, got %+v", results)
+	}
+	if elapsed := time.Since(start); elapsed > 1800*time.Millisecond {
+		t.Errorf("expected to wait on the BMC once, took %v", elapsed)
+	}
+	if requests := f.count("/redfish/v1/Systems"); requests != 1 {
+		t.Errorf("expected systems to be requested once, got %d", requests)
+	}
+}
+
+func TestConnectGofishDeadAddress(t *testing.T) {
+	// nothing listens on the port once the listener is closed
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	port := listener.Addr().(*net.TCPAddr).Port
+	listener.Close()
+
+	q := &QueryParams{Host: "127.0.0.1", Port: port, Protocol: "http", User: "root", Pass: "secret", Timeout: 1}
+	c, err := connectGofish(context.Background(), q, nil)
+	if err == nil || c != nil {
+		t.Fatalf("expected an error without a client, got %v", err)
+	}
+	if !isUnreachable(err) {
+		t.Errorf("expected the BMC to be unreachable, got %v", err)
+	}
+}
+
+func TestTimeouts(t *testing.T) {
+	tests := []struct {
+		name    string
+		q       QueryParams
+		connect time.Duration
+		query   time.Duration
+	}{
+		{"default", QueryParams{Timeout: 5}, 5 * time.Second, 5 * time.Second},
+		{"connect", QueryParams{Timeout: 5, ConnectTimeout: time.Second}, time.Second, 5 * time.Second},
+		{"query", QueryParams{Timeout: 5, QueryTimeout: time.Minute}, 5 * time.Second, time.Minute},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := test.q.connectTimeout(); got != test.connect {
+				t.Errorf("expected connect timeout %v, got %v", test.connect, got)
+			}
+			if got := test.q.queryTimeout(); got != test.query {
+				t.Errorf("expected query timeout %v, got %v", test.query, got)
+			}
+		})
+	}
+
+	// a BMC that accepts connections but never finishes the handshake is
+	// given up on after the connect timeout
+	t.Run("slow handshake", func(t *testing.T) {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("failed to listen: %v", err)
+		}
+		defer listener.Close()
+		go func() {
+			for {
+				conn, err := listener.Accept()
+				if err != nil {
+					return
+				}
+				defer conn.Close() // held open until the listener is closed
+			}
+		}()
+
+		addr := listener.Addr().(*net.TCPAddr)
+		q := &QueryParams{Host: "127.0.0.1", Port: addr.Port, Protocol: "https", Timeout: 30, ConnectTimeout: 200 * time.Millisecond}
+		start := time.Now()
+		_, err = connectGofish(context.Background(), q, nil)
+		if err == nil {
+			t.Fatalf("expected the handshake to time out")
+		}
+		if elapsed := time.Since(start); elapsed > 5*time.Second {
+			t.Errorf("expected to give up after the connect timeout, took %v", elapsed)
+		}
+	})
+
+	// a BMC that is slow to answer is given up on after the query timeout
+	t.Run("slow query", func(t *testing.T) {
+		f := newRedfishFixture(t)
+		hang(t, f, "/redfish/v1/Chassis")
+		q := f.params(t)
+		q.Timeout = 30
+		q.QueryTimeout = 200 * time.Millisecond
+		c := f.connect(t, q)
+
+		start := time.Now()
+		_, err := CollectChassis(c, testLogger(), q)
+		if err == nil {
+			t.Fatalf("expected the query to time out")
+		}
+		if elapsed := time.Since(start); elapsed > 5*time.Second {
+			t.Errorf("expected to give up after the query timeout, took %v", elapsed)
+		}
+	})
+}
+
+func TestMakeGofishConfigTimeouts(t *testing.T) {
+	q := &QueryParams{Host: "10.0.0.1", Port: HTTPS_PORT, Protocol: "https", Timeout: 10, ConnectTimeout: 3 * time.Second}
+	config, err := makeGofishConfig(q, nil)
+	if err != nil {
+		t.Fatalf("failed to make gofish config: %v", err)
+	}
+	if config.HTTPClient.Timeout != 10*time.Second {
+		t.Errorf("expected a query timeout of 10s, got %v", config.HTTPClient.Timeout)
+	}
+
+	// the handshake timeout is a duration on the transport rather than the
+	// seconds gofish expects in its own config
+	fallback, ok := config.HTTPClient.Transport.(*util.ExpandFallbackTransport)
+	if !ok {
+		t.Fatalf("unexpected transport %T", config.HTTPClient.Transport)
+	}
+	transport, ok := fallback.Base.(*http.Transport)
+	if !ok {
+		t.Fatalf("unexpected base transport %T", fallback.Base)
+	}
+	if transport.TLSHandshakeTimeout != 3*time.Second {
+		t.Errorf("expected a handshake timeout of 3s, got %v", transport.TLSHandshakeTimeout)
+	}
+}
+
+func TestConnectGofishExpandSupport(t *testing.T) {
+	tests := []struct {
+		name      string
+		supported bool
+	}{
+		{"supported", true},
+		{"not supported", false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			f := newRedfishFixture(t)
+			f.merge("/redfish/v1", map[string]any{"ProtocolFeaturesSupported": map[string]any{
+				"ExpandQuery": map[string]any{"ExpandAll": test.supported, "Links": test.supported},
+			}})
+			q := f.params(t)
+
+			// forget earlier fixtures that listened on the same port
+			expandSupport.Delete(expandKey(q))
+
+			c := f.connect(t, q)
+			if c.Service.ProtocolFeaturesSupported.ExpandQuery.ExpandAll != test.supported {
+				t.Errorf("expected $expand to be %v", test.supported)
+			}
+			cached, ok := expandSupport.Load(expandKey(q))
+			if !ok || cached.(bool) != test.supported {
+				t.Errorf("expected %v to be cached for the BMC, got %v", test.supported, cached)
+			}
+
+			// the cached capability wins over what the BMC advertises later
+			f.merge("/redfish/v1", map[string]any{"ProtocolFeaturesSupported": map[string]any{
+				"ExpandQuery": map[string]any{"ExpandAll": !test.supported, "Links": !test.supported},
+			}})
+			c = f.connect(t, q)
+			if c.Service.ProtocolFeaturesSupported.ExpandQuery.ExpandAll != test.supported {
+				t.Errorf("expected $expand to stay %v once cached", test.supported)
+			}
+		})
+	}
+}
+
+func TestCollectManagers(t *testing.T) {
+	f := newRedfishFixture(t)
+	f.merge("/redfish/v1/Managers/BMC", map[string]any{
+		"FirmwareVersion": "1.2.3",
+		"DateTime":        "2024-01-01T00:00:00Z",
+		"NetworkProtocol": link("/redfish/v1/Managers/BMC/NetworkProtocol"),
+	})
+	f.set("/redfish/v1/Managers/BMC/NetworkProtocol", map[string]any{
+		"@odata.id": "/redfish/v1/Managers/BMC/NetworkProtocol",
+		"Id":        "NetworkProtocol",
+		"HostName":  "bmc-1",
+		"FQDN":      "bmc-1.example.com",
+		"NTP":       map[string]any{"ProtocolEnabled": true, "Port": 123, "NTPServers": []string{"10.0.0.254"}},
+	})
+	q := f.params(t)
+	c := f.connect(t, q)
+
+	b, err := CollectManagers(c, testLogger(), q)
+	if err != nil {
+		t.Fatalf("failed to collect managers: %v", err)
+	}
+	managers := decodeSection(t, b, "Managers")
+	if len(managers) != 1 {
+		t.Fatalf("expected 1 manager, got %d:\n%s", len(managers), b)
+	}
+	manager := managers[0]
+	if manager["ID"] != "BMC" || manager["ManagerType"] != "BMC" || manager["FirmwareVersion"] != "1.2.3" || manager["DateTime"] != "2024-01-01T00:00:00Z" {
+		t.Errorf("unexpected manager: %v", manager)
+	}
+	protocol, _ := manager["NetworkProtocol"].(map[string]any)
+	ntp, _ := protocol["NTP"].(map[string]any)
+	if protocol["FQDN"] != "bmc-1.example.com" || ntp["ProtocolEnabled"] != true {
+		t.Errorf("unexpected network protocol: %v", protocol)
+	}
+}
+
+// fakeClock is always at the same time.
+type fakeClock time.Time
+
+func (c fakeClock) Now() time.Time {
+	return time.Time(c)
+}
+
+func TestCollectAllClockSkew(t *testing.T) {
+	f := newRedfishFixture(t)
+	f.merge("/redfish/v1/Managers/BMC", map[string]any{"DateTime": "2024-01-01T00:00:00Z"})
+	q := f.params(t)
+	q.CollectManagers = true
+	q.MaxClockSkew = time.Minute
+	q.SummaryPath = filepath.Join(t.TempDir(), "summary.json")
+
+	// the clock of the BMC is an hour behind
+	q.Clock = fakeClock(time.Date(2024, 1, 1, 1, 0, 0, 0, time.UTC))
+	host, port := f.hostPort()
+	states := []ScannedResult{{Host: host, Port: port, Protocol: "http", State: true}}
+
+	results, err := CollectAll(context.Background(), &states, testLogger(), q)
+	if err != nil {
+		t.Fatalf("failed to collect: %v", err)
+	}
+	if len(results) != 1 || results[0].ClockSkewSeconds == nil {
+		t.Fatalf("expected the clock skew to be reported, got %+v", results)
+	}
+	if skew := *results[0].ClockSkewSeconds; skew != -3600 {
+		t.Errorf("expected a skew of -3600s, got %v", skew)
+	}
+
+	b, err := os.ReadFile(q.SummaryPath)
+	if err != nil {
+		t.Fatalf("failed to read summary: %v", err)
+	}
+	if !strings.Contains(string(b), fmt.Sprintf("%s:%d", host, port)) {
+		t.Errorf("expected the host to be listed as skewed in the summary:\n%s", b)
+	}
+}
+
+func TestCollectAllInjectedClockAndTransport(t *testing.T) {
+	f := newRedfishFixture(t)
+	q := f.params(t)
+	q.Envelope = true
+	q.Clock = fakeClock(time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC))
+
+	// every request goes through the injected transport to the fixture
+	q.Transport = f.transport()
+	states := []ScannedResult{{Host: "bmc.invalid", Port: HTTPS_PORT, Protocol: "http", State: true}}
+
+	results, err := CollectAll(context.Background(), &states, testLogger(), q)
+	if err != nil {
+		t.Fatalf("failed to collect: %v", err)
+	}
+	if len(results) != 1 || !results[0].Success {
+		t.Fatalf("expected the host to be collected through the transport, got %+v", results)
+	}
+
+	files := outputFiles(t, q)
+	if len(files) != 1 || filepath.Base(files[0]) != "bmc.invalid.json" {
+		t.Fatalf("expected the output file of the host, got %v", files)
+	}
+	b, err := os.ReadFile(files[0])
+	if err != nil {
+		t.Fatalf("failed to read output file: %v", err)
+	}
+	var envelope map[string]any
+	err = json.Unmarshal(b, &envelope)
+	if err != nil {
+		t.Fatalf("failed to unmarshal output: %v", err)
+	}
+	if envelope["collectedAt"] != "2024-06-01T12:00:00Z" {
+		t.Errorf("expected the time of the fake clock, got %v", envelope["collectedAt"])
+	}
+}
+
+func TestCollectDrives(t *testing.T) {
+	f := newRedfishFixture(t)
+	f.merge("/redfish/v1/Systems/1", map[string]any{"Storage": link("/redfish/v1/Systems/1/Storage")})
+	f.set("/redfish/v1/Systems/1/Storage", collection("/redfish/v1/Systems/1/Storage", "/redfish/v1/Systems/1/Storage/NVMe"))
+	f.set("/redfish/v1/Systems/1/Storage/NVMe", map[string]any{
+		"@odata.id": "/redfish/v1/Systems/1/Storage/NVMe",
+		"Id":        "NVMe",
+		"Drives":    []any{link("/redfish/v1/Systems/1/Storage/NVMe/Drives/0")},
+	})
+	f.set("/redfish/v1/Systems/1/Storage/NVMe/Drives/0", map[string]any{
+		"@odata.id":     "/redfish/v1/Systems/1/Storage/NVMe/Drives/0",
+		"Id":            "0",
+		"Model":         "PM1733",
+		"SerialNumber":  "S4YNNE0N100000",
+		"CapacityBytes": 3840755982336,
+		"Protocol":      "NVMe",
+		"MediaType":     "SSD",
+	})
+
+	// a system whose storage cannot be read is skipped
+	f.set("/redfish/v1/Systems", collection("/redfish/v1/Systems", "/redfish/v1/Systems/1", "/redfish/v1/Systems/2"))
+	f.set("/redfish/v1/Systems/2", map[string]any{
+		"@odata.id": "/redfish/v1/Systems/2",
+		"Id":        "2",
+		"Storage":   link("/redfish/v1/Systems/2/Storage"),
+	})
+	f.handle("/redfish/v1/Systems/2/Storage", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	})
+
+	q := f.params(t)
+	b, err := CollectDrives(f.connect(t, q), testLogger(), q)
+	if err != nil {
+		t.Fatalf("failed to collect drives: %v", err)
+	}
+	drives := decodeSection(t, b, "Drives")
+	if len(drives) != 1 {
+		t.Fatalf("expected 1 drive, got:\n%s", b)
+	}
+	if drives[0]["System"] != "1" || drives[0]["Storage"] != "NVMe" || drives[0]["SerialNumber"] != "S4YNNE0N100000" {
+		t.Errorf("unexpected drive: %v", drives[0])
+	}
+
+	// and the section is part of the output when requested
+	q.Sections = []string{"Drives"}
+	host, port := f.hostPort()
+	states := []ScannedResult{{Host: host, Port: port, Protocol: "http", State: true}}
+	results, err := CollectAll(context.Background(), &states, testLogger(), q)
+	if err != nil {
+		t.Fatalf("failed to collect: %v", err)
+	}
+	if len(results) != 1 || !results[0].Success {
+		t.Fatalf("expected the host to be collected, got %+v", results)
+	}
+	if drives := decodeSection(t, results[0].Payload, "Drives"); len(drives) != 1 {
+		t.Errorf("expected the drives in the output, got:\n%s", results[0].Payload)
+	}
+}
+
+func TestURLHost(t *testing.T) {
+	tests := map[string]string{
+		"10.0.0.1":          "10.0.0.1",
+		"fd00::1":           "[fd00::1]",
+		"bmc01.example.com": "bmc01.example.com",
+	}
+	for host, expected := range tests {
+		if got := urlHost(host); got != expected {
+			t.Errorf("expected %s for %s, got %s", expected, host, got)
+		}
+	}
+}
+
+func TestCollectAllSections(t *testing.T) {
+	f := newRedfishFixture(t)
+	q := f.params(t)
+	q.Sections = []string{"PowerState", "managers"}
+	q.CollectServiceRoot = true // ignored since sections were requested
+	states := []ScannedResult{{Host: q.Host, Port: q.Port, Protocol: "http", State: true}}
+
+	results, err := CollectAll(context.Background(), &states, testLogger(), q)
+	if err != nil {
+		t.Fatalf("failed to collect: %v", err)
+	}
+	if len(results) != 1 {
+		t.Fatalf("expected 1 result, got %d", len(results))
+	}
+	var output map[string]json.RawMessage
+	err = json.Unmarshal(results[0].Payload, &output)
+	if err != nil {
+		t.Fatalf("failed to unmarshal payload: %v", err)
+	}
+	keys := util.SortedKeys(output)
+	expected := []string{"FQDN", "ID", "MACRequired", "Managers", "Name", "PowerState", "RediscoverOnUpdate", "TLS", "Type", "User"}
+	if !reflect.DeepEqual(keys, expected) {
+		t.Errorf("expected only the requested sections %v, got %v", expected, keys)
+	}
+}
+
+func TestCollectAllInvalidSection(t *testing.T) {
+	f := newRedfishFixture(t)
+	f.handle("/redfish/v1/Managers", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"Members": [`))
+	})
+	q := f.params(t)
+	q.CollectManagers = true
+	states := []ScannedResult{{Host: q.Host, Port: q.Port, Protocol: "http", State: true}}
+
+	results, err := CollectAll(context.Background(), &states, testLogger(), q)
+	if err != nil {
+		t.Fatalf("failed to collect: %v", err)
+	}
+	if len(results) != 1 {
+		t.Fatalf("expected 1 result, got %d", len(results))
+	}
+
+	// the broken section is recorded as an error without losing the others
+	if _, ok := results[0].Errors["Managers"]; !ok {
+		t.Errorf("expected an error for Managers, got %v", results[0].Errors)
+	}
+	if systems := decodeSection(t, results[0].Payload, "Systems"); len(systems) != 1 {
+		t.Errorf("expected 1 system, got %d", len(systems))
+	}
+	if chassis := decodeSection(t, results[0].Payload, "Chassis"); len(chassis) != 1 {
+		t.Errorf("expected 1 chassis, got %d", len(chassis))
+	}
+}
+
+func TestCollectAllRateLimit(t *testing.T) {
+	f := newRedfishFixture(t)
+	q := f.params(t)
+	q.Transport = f.transport()
+	q.Concurrency = 5
+	q.RateLimit = 10
+	states := fleet(f, 5)
+
+	start := time.Now()
+	_, err := CollectAll(context.Background(), &states, testLogger(), q)
+	if err != nil {
+		t.Fatalf("failed to collect: %v", err)
+	}
+
+	// the first connection is allowed straight away and every other one waits
+	// for the limiter even though all hosts are collected at once
+	minimum := time.Duration(len(states)-1) * time.Second / time.Duration(q.RateLimit)
+	if elapsed := time.Since(start); elapsed < minimum {
+		t.Errorf("expected collecting %d hosts to take at least %s, took %s", len(states), minimum, elapsed)
+	}
+}
+
+func TestCollectAllSummary(t *testing.T) {
+	var (
+		healthy = newRedfishFixture(t)
+		partial = newRedfishFixture(t)
+		notBMC  = newRedfishFixture(t)
+	)
+	partial.handle("/redfish/v1/Managers", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"Members": [`))
+	})
+	notBMC.handle("/redfish/v1", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte("<html><body>It works!</body></html>"))
+	})
+
+	// nothing listens on the port once the listener is closed
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	deadPort := listener.Addr().(*net.TCPAddr).Port
+	listener.Close()
+
+	// each host is sent to its own fixture (or the dead port)
+	addrs := map[string]string{"10.0.0.1": fmt.Sprintf("127.0.0.1:%d", deadPort)}
+	for i, f := range []*redfishFixture{healthy, partial, notBMC} {
+		addrs[fmt.Sprintf("10.0.0.%d", i+2)] = f.Listener.Addr().String()
+	}
+	q := healthy.params(t)
+	q.Transport = routes(addrs)
+	q.CollectManagers = true
+	q.SummaryPath = filepath.Join(t.TempDir(), "summary.json")
+	states := []ScannedResult{}
+	for _, host := range util.SortedKeys(addrs) {
+		states = append(states, ScannedResult{Host: host, Port: q.Port, Protocol: "http", State: true})
+	}
+
+	_, err = CollectAll(context.Background(), &states, testLogger(), q)
+	if err != nil {
+		t.Fatalf("failed to collect: %v", err)
+	}
+	b, err := os.ReadFile(q.SummaryPath)
+	if err != nil {
+		t.Fatalf("failed to read summary: %v", err)
+	}
+	var summary Summary
+	err = json.Unmarshal(b, &summary)
+	if err != nil {
+		t.Fatalf("failed to unmarshal summary: %v", err)
+	}
+
+	// the host missing a section still counts as succeeded
+	expected := Summary{
+		Attempted:      4,
+		Succeeded:      2,
+		Failed:         1,
+		NotBMC:         1,
+		FailedStages:   map[string]int{"connect": 1},
+		FailedSections: map[string]int{"Managers": 1},
+		Unreachable:    []string{fmt.Sprintf("10.0.0.1:%d", q.Port)},
+		ClockSkewed:    []string{},
+		Elapsed:        summary.Elapsed,
+	}
+	if !reflect.DeepEqual(summary, expected) {
+		t.Errorf("expected summary %+v, got %+v", expected, summary)
+	}
+}
+
+func TestCollectAllNDJSON(t *testing.T) {
+	f := newRedfishFixture(t)
+	q := f.params(t)
+	q.Transport = f.transport()
+	q.Concurrency = 3
+	q.OutputFormat = OUTPUT_NDJSON
+	q.OutputPath = filepath.Join(t.TempDir(), "inventory.ndjson")
+	states := fleet(f, 3)
+
+	_, err := CollectAll(context.Background(), &states, testLogger(), q)
+	if err != nil {
+		t.Fatalf("failed to collect: %v", err)
+	}
+	b, err := os.ReadFile(q.OutputPath)
+	if err != nil {
+		t.Fatalf("failed to read output: %v", err)
+	}
+
+	// every host is appended as one complete line even when written at once
+	lines := bytes.Split(bytes.TrimSuffix(b, []byte("\n")), []byte("\n"))
+	if len(lines) != len(states) {
+		t.Fatalf("expected %d lines, got %d", len(states), len(lines))
+	}
+	ids := map[string]bool{}
+	for _, line := range lines {
+		var record struct{ ID string }
+		err = json.Unmarshal(line, &record)
+		if err != nil {
+			t.Fatalf("failed to unmarshal line: %v", err)
+		}
+		ids[record.ID] = true
+	}
+	if len(ids) != len(states) {
+		t.Errorf("expected a line for each host, got %v", ids)
+	}
+}
+
+func TestCollectAllProxy(t *testing.T) {
+	f := newRedfishFixture(t)
+
+	// the proxy records the requests it forwards to the fixture
+	var (
+		mu        sync.Mutex
+		forwarded = map[string]int{}
+	)
+	target := f.Listener.Addr().String()
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		forwarded[r.Host]++
+		mu.Unlock()
+		r.URL.Host = target
+		r.RequestURI = ""
+		res, err := http.DefaultTransport.RoundTrip(r)
+		if err != nil {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		defer res.Body.Close()
+		for key, values := range res.Header {
+			w.Header()[key] = values
+		}
+		w.WriteHeader(res.StatusCode)
+		io.Copy(w, res.Body)
+	}))
+	t.Cleanup(proxy.Close)
+
+	// the BMC can only be reached through the proxy
+	q := f.params(t)
+	q.Proxy = proxy.URL
+	states := []ScannedResult{{Host: "bmc01.example.com", Port: q.Port, Protocol: "http", State: true}}
+	results, err := CollectAll(context.Background(), &states, testLogger(), q)
+	if err != nil {
+		t.Fatalf("failed to collect: %v", err)
+	}
+	if len(results) != 1 || !results[0].Success {
+		t.Fatalf("expected the host to be collected through the proxy, got %+v", results)
+	}
+	bmc := net.JoinHostPort("bmc01.example.com", fmt.Sprint(q.Port))
+	if len(forwarded) != 1 || forwarded[bmc] == 0 {
+		t.Errorf("expected every request to be forwarded to %s, got %v", bmc, forwarded)
+	}
+}
+
+func TestCollectAllSectionFailureLogged(t *testing.T) {
+	f := newRedfishFixture(t)
+	f.handle("/redfish/v1/Chassis", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	})
+	q := f.params(t)
+	states := []ScannedResult{{Host: q.Host, Port: q.Port, Protocol: "http", State: true}}
+
+	var buf bytes.Buffer
+	_, err := CollectAll(context.Background(), &states, captureLogger(&buf), q)
+	if err != nil {
+		t.Fatalf("failed to collect: %v", err)
+	}
+
+	// the attempt and the failure are both logged with the host
+	logged := buf.String()
+	bmc := fmt.Sprintf("(%v:%v)", q.Host, q.Port)
+	for _, entry := range []string{
+		`level=debug msg="querying chassis ` + bmc,
+		`level=error msg="failed to collect Chassis ` + bmc + `: failed to query chassis ` + bmc,
+	} {
+		if !strings.Contains(logged, entry) {
+			t.Errorf("expected %q to be logged, got %q", entry, logged)
+		}
+	}
+	if strings.Contains(logged, "failed to collect Systems") {
+		t.Errorf("expected only the failed section to be logged, got %q", logged)
+	}
+}
+
+func TestCollectAllProgress(t *testing.T) {
+	f := newRedfishFixture(t)
+	f.handle("/redfish/v1/Chassis", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	})
+	var (
+		mu     sync.Mutex
+		events = map[string][]CollectEvent{}
+	)
+	q := f.params(t)
+	q.Transport = f.transport()
+	q.CollectManagers = true
+	q.Progress = func(event CollectEvent) {
+		mu.Lock()
+		events[event.Host] = append(events[event.Host], event)
+		mu.Unlock()
+	}
+	states := fleet(f, 2)
+
+	_, err := CollectAll(context.Background(), &states, testLogger(), q)
+	if err != nil {
+		t.Fatalf("failed to collect: %v", err)
+	}
+
+	// hosts are collected at once so only the events of each are in order
+	for _, ps := range states {
+		var sequence []string
+		for _, event := range events[ps.Host] {
+			if event.Port != ps.Port || event.Time.IsZero() {
+				t.Errorf("unexpected event for %s: %+v", ps.Host, event)
+			}
+			step := event.Type
+			if event.Section != "" {
+				step += " " + event.Section
+			}
+			if event.Error != "" {
+				step += " (failed)"
+			}
+			sequence = append(sequence, step)
+		}
+		expected := []string{
+			EVENT_HOST_START,
+			EVENT_SECTION_DONE + " Chassis (failed)",
+			EVENT_SECTION_DONE + " Systems",
+			EVENT_SECTION_DONE + " Managers",
+			EVENT_HOST_DONE + " (failed)",
+		}
+		if !reflect.DeepEqual(sequence, expected) {
+			t.Errorf("expected events %v for %s, got %v", expected, ps.Host, sequence)
+		}
+		if last := events[ps.Host][len(events[ps.Host])-1]; last.Result == nil || last.Result.Host != ps.Host {
+			t.Errorf("expected the result of %s with the last event, got %+v", ps.Host, last.Result)
+		}
+	}
+}
+
+func TestPreferredProvider(t *testing.T) {
+	q := &QueryParams{
+		Preferred:          "gofish",
+		PreferredProviders: map[string]string{"PowerState": "ipmitool", "bios": "redfish"},
+	}
+	tests := map[string]string{
+		"PowerState": "ipmitool",
+		"Bios":       "redfish", // sections are matched without case
+		"Inventory":  "gofish",  // anything else falls back to Preferred
+		"Users":      "gofish",
+	}
+	for section, expected := range tests {
+		if provider := q.preferredProvider(section); provider != expected {
+			t.Errorf("expected %s to prefer %s, got %s", section, expected, provider)
+		}
+	}
+}
+
+func TestCollectBootOptions(t *testing.T) {
+	f := newRedfishFixture(t)
+	f.merge("/redfish/v1/Systems/1", map[string]any{"Boot": map[string]any{
+		"BootSourceOverrideEnabled": "Once",
+		"BootSourceOverrideTarget":  "Pxe",
+		"BootSourceOverrideMode":    "UEFI",
+		"BootNext":                  "Boot0001",
+		"BootOrder":                 []string{"Boot0002", "Boot0001", "Boot0003"},
+		"BootOptions":               link("/redfish/v1/Systems/1/BootOptions"),
+	}})
+	f.set("/redfish/v1/Systems/1/BootOptions", collection("/redfish/v1/Systems/1/BootOptions",
+		"/redfish/v1/Systems/1/BootOptions/0001",
+		"/redfish/v1/Systems/1/BootOptions/0002",
+	))
+	f.set("/redfish/v1/Systems/1/BootOptions/0001", map[string]any{
+		"@odata.id":           "/redfish/v1/Systems/1/BootOptions/0001",
+		"Id":                  "0001",
+		"BootOptionReference": "Boot0001",
+		"DisplayName":         "UEFI PXEv4 (MAC:AABBCCDDEE01)",
+		"Alias":               "Pxe",
+		"BootOptionEnabled":   true,
+	})
+	f.set("/redfish/v1/Systems/1/BootOptions/0002", map[string]any{
+		"@odata.id":           "/redfish/v1/Systems/1/BootOptions/0002",
+		"Id":                  "0002",
+		"BootOptionReference": "Boot0002",
+		"DisplayName":         "Hard Disk",
+		"Alias":               "Hdd",
+		"BootOptionEnabled":   false,
+	})
+	q := f.params(t)
+	c := f.connect(t, q)
+
+	b, err := CollectBootOptions(c, testLogger(), q)
+	if err != nil {
+		t.Fatalf("failed to collect boot options: %v", err)
+	}
+	var output struct {
+		Boot map[string]struct {
+			Override    map[string]any
+			BootNext    string
+			BootOrder   []map[string]any
+			BootOptions []map[string]any
+		}
+	}
+	err = json.Unmarshal(b, &output)
+	if err != nil {
+		t.Fatalf("failed to unmarshal output: %v\n%s", err, b)
+	}
+	boot, ok := output.Boot["1"]
+	if !ok {
+		t.Fatalf("expected the boot options of system 1:\n%s", b)
+	}
+	override := map[string]any{"Enabled": "Once", "Target": "Pxe", "Mode": "UEFI"}
+	if !reflect.DeepEqual(boot.Override, override) || boot.BootNext != "Boot0001" {
+		t.Errorf("unexpected boot override %v with next %q", boot.Override, boot.BootNext)
+	}
+
+	// the order is kept with the entries missing from the options left bare
+	order := []map[string]any{
+		{"Reference": "Boot0002", "DisplayName": "Hard Disk", "Enabled": false, "Alias": "Hdd"},
+		{"Reference": "Boot0001", "DisplayName": "UEFI PXEv4 (MAC:AABBCCDDEE01)", "Enabled": true, "Alias": "Pxe"},
+		{"Reference": "Boot0003"},
+	}
+	if !reflect.DeepEqual(boot.BootOrder, order) {
+		t.Errorf("expected boot order %v, got %v", order, boot.BootOrder)
+	}
+	if len(boot.BootOptions) != 2 {
+		t.Errorf("expected 2 boot options, got %d", len(boot.BootOptions))
+	}
+}
+
+// selFixture adds a SEL with n entries and an event log to the system of
+// the fixture.
+func selFixture(f *redfishFixture, n int) {
+	f.merge("/redfish/v1/Systems/1", map[string]any{"LogServices": link("/redfish/v1/Systems/1/LogServices")})
+	f.set("/redfish/v1/Systems/1/LogServices", collection("/redfish/v1/Systems/1/LogServices",
+		"/redfish/v1/Systems/1/LogServices/SEL",
+		"/redfish/v1/Systems/1/LogServices/Event",
+	))
+	f.set("/redfish/v1/Systems/1/LogServices/Event", map[string]any{
+		"@odata.id":    "/redfish/v1/Systems/1/LogServices/Event",
+		"Id":           "Event",
+		"LogEntryType": "Event",
+		"Entries":      link("/redfish/v1/Systems/1/LogServices/Event/Entries"),
+	})
+	f.set("/redfish/v1/Systems/1/LogServices/SEL", map[string]any{
+		"@odata.id":    "/redfish/v1/Systems/1/LogServices/SEL",
+		"Id":           "SEL",
+		"LogEntryType": "SEL",
+		"Entries":      link("/redfish/v1/Systems/1/LogServices/SEL/Entries"),
+	})
+	entries := []string{}
+	for i := 1; i <= n; i++ {
+		path := fmt.Sprintf("/redfish/v1/Systems/1/LogServices/SEL/Entries/%d", i)
+		f.set(path, map[string]any{
+			"@odata.id": path,
+			"Id":        fmt.Sprint(i),
+			"EntryType": "SEL",
+			"Severity":  "Warning",
+			"Message":   fmt.Sprintf("Fan %d speed below threshold", i),
+			"Created":   fmt.Sprintf("2024-01-01T00:00:%02dZ", i),
+		})
+		entries = append(entries, path)
+	}
+	f.set("/redfish/v1/Systems/1/LogServices/SEL/Entries", collection("/redfish/v1/Systems/1/LogServices/SEL/Entries", entries...))
+}
+
+func TestCollectSEL(t *testing.T) {
+	tests := []struct {
+		name       string
+		maxEntries int
+		expected   int
+	}{
+		{"all entries", 0, 3},
+		{"capped", 2, 2}, // the fixture ignores $top so the cap is applied after
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			f := newRedfishFixture(t)
+			selFixture(f, 3)
+			q := f.params(t)
+			q.MaxLogEntries = test.maxEntries
+			c := f.connect(t, q)
+
+			b, err := CollectSEL(c, q)
+			if err != nil {
+				t.Fatalf("failed to collect SEL: %v", err)
+			}
+
+			// only the SEL is read and not the other logs
+			services := decodeSection(t, b, "SEL")
+			if len(services) != 1 || services[0]["URI"] != "/redfish/v1/Systems/1/LogServices/SEL" {
+				t.Fatalf("expected only the SEL of the system, got:\n%s", b)
+			}
+			entries, _ := services[0]["Entries"].([]any)
+			if len(entries) != test.expected {
+				t.Fatalf("expected %d entries, got %d", test.expected, len(entries))
+			}
+			messages := map[string]bool{}
+			for _, entry := range entries {
+				entry, _ := entry.(map[string]any)
+				messages[fmt.Sprint(entry["Message"])] = true
+				if entry["Severity"] != "Warning" || entry["EntryType"] != "SEL" {
+					t.Errorf("unexpected entry: %v", entry)
+				}
+			}
+			if test.maxEntries == 0 && !messages["Fan 3 speed below threshold"] {
+				t.Errorf("expected every entry, got %v", messages)
+			}
+			if f.count("/redfish/v1/Systems/1/LogServices/Event/Entries") != 0 {
+				t.Errorf("expected the event log to not be read")
+			}
+		})
+	}
+}
+
+// thermal and power resources as recorded from a BMC (trimmed to a couple of
+// readings each)
+const (
+	recordedThermal = `{
+    "@odata.context": "/redfish/v1/$metadata#Thermal.Thermal",
+    "@odata.id": "/redfish/v1/Chassis/1/Thermal",
+    "@odata.type": "#Thermal.v1_4_0.Thermal",
+    "Id": "Thermal",
+    "Name": "Thermal",
+    "Temperatures": [
+        {
+            "@odata.id": "/redfish/v1/Chassis/1/Thermal#/Temperatures/0",
+            "MemberId": "0",
+            "Name": "CPU1 Temp",
+            "ReadingCelsius": 45,
+            "UpperThresholdCritical": 95,
+            "PhysicalContext": "CPU",
+            "Status": {"State": "Enabled", "Health": "OK"}
+        },
+        {
+            "@odata.id": "/redfish/v1/Chassis/1/Thermal#/Temperatures/1",
+            "MemberId": "1",
+            "Name": "Inlet Temp",
+            "ReadingCelsius": 22,
+            "UpperThresholdCritical": 47,
+            "PhysicalContext": "Intake",
+            "Status": {"State": "Enabled", "Health": "OK"}
+        }
+    ],
+    "Fans": [
+        {
+            "@odata.id": "/redfish/v1/Chassis/1/Thermal#/Fans/0",
+            "MemberId": "0",
+            "Name": "FAN1",
+            "Reading": 5880,
+            "ReadingUnits": "RPM",
+            "Status": {"State": "Enabled", "Health": "Warning"}
+        }
+    ]
+}`
+	recordedPower = `{
+    "@odata.context": "/redfish/v1/$metadata#Power.Power",
+    "@odata.id": "/redfish/v1/Chassis/1/Power",
+    "@odata.type": "#Power.v1_5_0.Power",
+    "Id": "Power",
+    "Name": "Power",
+    "PowerControl": [
+        {
+            "@odata.id": "/redfish/v1/Chassis/1/Power#/PowerControl/0",
+            "MemberId": "0",
+            "Name": "System Power Control",
+            "PowerConsumedWatts": 312,
+            "PowerCapacityWatts": 1600
+        }
+    ],
+    "PowerSupplies": [
+        {
+            "@odata.id": "/redfish/v1/Chassis/1/Power#/PowerSupplies/0",
+            "MemberId": "0",
+            "Name": "PS1 Status",
+            "PowerCapacityWatts": 800,
+            "LastPowerOutputWatts": 156,
+            "LineInputVoltage": 208,
+            "Status": {"State": "Enabled", "Health": "OK"}
+        },
+        {
+            "@odata.id": "/redfish/v1/Chassis/1/Power#/PowerSupplies/1",
+            "MemberId": "1",
+            "Name": "PS2 Status",
+            "PowerCapacityWatts": 800,
+            "LastPowerOutputWatts": 0,
+            "LineInputVoltage": 0,
+            "Status": {"State": "UnavailableOffline", "Health": "Critical"}
+        }
+    ],
+    "Voltages": [
+        {
+            "@odata.id": "/redfish/v1/Chassis/1/Power#/Voltages/0",
+            "MemberId": "0",
+            "Name": "PS1 Voltage 1",
+            "ReadingVolts": 208
+        }
+    ]
+}`
+)
+
+func TestCollectSensors(t *testing.T) {
+	f := newRedfishFixture(t)
+	f.merge("/redfish/v1/Chassis/1", map[string]any{
+		"Thermal": link("/redfish/v1/Chassis/1/Thermal"),
+		"Power":   link("/redfish/v1/Chassis/1/Power"),
+	})
+	f.set("/redfish/v1/Chassis/1/Thermal", json.RawMessage(recordedThermal))
+	f.set("/redfish/v1/Chassis/1/Power", json.RawMessage(recordedPower))
+
+	// a chassis without sensors is skipped
+	f.set("/redfish/v1/Chassis", collection("/redfish/v1/Chassis", "/redfish/v1/Chassis/1", "/redfish/v1/Chassis/2"))
+	f.set("/redfish/v1/Chassis/2", map[string]any{
+		"@odata.id":   "/redfish/v1/Chassis/2",
+		"Id":          "2",
+		"Name":        "Backplane",
+		"ChassisType": "Component",
+	})
+	q := f.params(t)
+	c := f.connect(t, q)
+
+	b, err := CollectSensors(c, q)
+	if err != nil {
+		t.Fatalf("failed to collect sensors: %v", err)
+	}
+	var output struct {
+		Sensors struct {
+			Thermal []struct {
+				Chassis      string
+				Temperatures []struct {
+					Name           string
+					ReadingCelsius float64
+				}
+				Fans []struct {
+					Name    string
+					Reading int
+					Status  struct{ Health string }
+				}
+			}
+			Power []struct {
+				Chassis       string
+				PowerSupplies []struct {
+					Name                 string
+					LastPowerOutputWatts float64
+					Status               struct{ Health string }
+				}
+				PowerControl []struct{ PowerConsumedWatts float64 }
+				Voltages     []struct{ ReadingVolts float64 }
+			}
+		}
+	}
+	err = json.Unmarshal(b, &output)
+	if err != nil {
+		t.Fatalf("failed to unmarshal output: %v\n%s", err, b)
+	}
+
+	thermal := output.Sensors.Thermal
+	if len(thermal) != 1 || thermal[0].Chassis != "1" {
+		t.Fatalf("expected the thermal readings of chassis 1 only:\n%s", b)
+	}
+	if len(thermal[0].Temperatures) != 2 || thermal[0].Temperatures[0].Name != "CPU1 Temp" || thermal[0].Temperatures[0].ReadingCelsius != 45 {
+		t.Errorf("unexpected temperatures: %+v", thermal[0].Temperatures)
+	}
+	if len(thermal[0].Fans) != 1 || thermal[0].Fans[0].Reading != 5880 || thermal[0].Fans[0].Status.Health != "Warning" {
+		t.Errorf("unexpected fans: %+v", thermal[0].Fans)
+	}
+
+	power := output.Sensors.Power
+	if len(power) != 1 || power[0].Chassis != "1" {
+		t.Fatalf("expected the power readings of chassis 1 only:\n%s", b)
+	}
+	supplies := power[0].PowerSupplies
+	if len(supplies) != 2 || supplies[0].LastPowerOutputWatts != 156 || supplies[1].Status.Health != "Critical" {
+		t.Errorf("unexpected power supplies: %+v", supplies)
+	}
+	if len(power[0].PowerControl) != 1 || power[0].PowerControl[0].PowerConsumedWatts != 312 {
+		t.Errorf("unexpected power control: %+v", power[0].PowerControl)
+	}
+	if len(power[0].Voltages) != 1 || power[0].Voltages[0].ReadingVolts != 208 {
+		t.Errorf("unexpected voltages: %+v", power[0].Voltages)
+	}
+}
+
+func TestCollectFirmwareInventory(t *testing.T) {
+	f := newRedfishFixture(t)
+
+	// entries as recorded from an iDRAC (trimmed to a few components)
+	firmware(f,
+		map[string]any{
+			"Id":           "Installed-25227-6.10.30.00",
+			"Name":         "Integrated Dell Remote Access Controller",
+			"Version":      "6.10.30.00",
+			"SoftwareId":   "25227",
+			"ReleaseDate":  "00:00:00Z",
+			"Updateable":   true,
+			"Status":       map[string]any{"State": "Enabled", "Health": "OK"},
+			"Manufacturer": "Dell Inc.",
+		},
+		map[string]any{
+			"Id":          "Installed-159-1.8.2",
+			"Name":        "BIOS",
+			"Version":     "1.8.2",
+			"SoftwareId":  "159",
+			"Updateable":  true,
+			"Status":      map[string]any{"State": "Enabled", "Health": "OK"},
+			"ReleaseDate": "00:00:00Z",
+		},
+		map[string]any{
+			"Id":         "Previous-108255-22.31.6",
+			"Name":       "Broadcom Gigabit Ethernet BCM5720",
+			"Version":    "22.31.6",
+			"SoftwareId": "108255",
+			"Updateable": false,
+			"Status":     map[string]any{"State": "StandbyOffline", "Health": "OK"},
+		},
+	)
+	q := f.params(t)
+	c := f.connect(t, q)
+
+	b, err := CollectFirmwareInventory(c, q)
+	if err != nil {
+		t.Fatalf("failed to collect firmware inventory: %v", err)
+	}
+	inventory := decodeSection(t, b, "Firmware")
+	if len(inventory) != 3 {
+		t.Fatalf("expected 3 components, got %d:\n%s", len(inventory), b)
+	}
+
+	// the members are requested concurrently so look them up by ID
+	versions := map[string]string{}
+	for _, fw := range inventory {
+		versions[fmt.Sprint(fw["ID"])] = fmt.Sprint(fw["Version"])
+		if fw["ID"] == "Installed-25227-6.10.30.00" {
+			status, _ := fw["Status"].(map[string]any)
+			if fw["SoftwareID"] != "25227" || fw["Updateable"] != true || fw["Manufacturer"] != "Dell Inc." || status["Health"] != "OK" {
+				t.Errorf("unexpected BMC firmware: %v", fw)
+			}
+		}
+	}
+	expected := map[string]string{
+		"Installed-25227-6.10.30.00": "6.10.30.00",
+		"Installed-159-1.8.2":        "1.8.2",
+		"Previous-108255-22.31.6":    "22.31.6",
+	}
+	if !reflect.DeepEqual(versions, expected) {
+		t.Errorf("expected versions %v, got %v", expected, versions)
+	}
+}
+
+func TestCollectFirmwareInventoryWithoutUpdateService(t *testing.T) {
+	f := newRedfishFixture(t)
+	q := f.params(t)
+	c := f.connect(t, q)
+
+	b, err := CollectFirmwareInventory(c, q)
+	if err != nil {
+		t.Fatalf("failed to collect firmware inventory: %v", err)
+	}
+	if b != nil {
+		t.Errorf("expected nothing without an update service, got:\n%s", b)
+	}
+	if count := f.count("/redfish/v1/UpdateService"); count != 0 {
+		t.Errorf("expected the missing update service to not be requested, got %d", count)
+	}
+}
+
+func TestCollectAllDefaultConcurrency(t *testing.T) {
+	for _, concurrency := range []int{0, -1} {
+		t.Run(fmt.Sprint(concurrency), func(t *testing.T) {
+			f := newRedfishFixture(t)
+			q := f.params(t)
+			q.Transport = f.transport()
+			q.Concurrency = concurrency
+
+			// more hosts than workers so some have to wait for a free one
+			states := fleet(f, runtime.NumCPU()+2)
+			done := make(chan error, 1)
+			var results []CollectResult
+			go func() {
+				var err error
+				results, err = CollectAll(context.Background(), &states, testLogger(), q)
+				done <- err
+			}()
+			select {
+			case err := <-done:
+				if err != nil {
+					t.Fatalf("failed to collect: %v", err)
+				}
+			case <-time.After(10 * time.Second):
+				t.Fatalf("collection did not complete")
+			}
+			if len(results) != len(states) {
+				t.Errorf("expected %d results, got %d", len(states), len(results))
+			}
+		})
+	}
+}
+
+func TestNewClientIpmiPort(t *testing.T) {
+	tests := map[int]string{
+		0:    fmt.Sprint(IPMI_PORT), // the default when not set
+		6230: "6230",
+	}
+	for port, expected := range tests {
+		t.Run(fmt.Sprint(port), func(t *testing.T) {
+			// ipmitool is faked with a script recording the arguments it is
+			// run with
+			dir := t.TempDir()
+			args := filepath.Join(dir, "args")
+			script := filepath.Join(dir, "ipmitool")
+			err := os.WriteFile(script, []byte("#!/bin/sh\necho \"$@\" >> "+args+"\necho \"Chassis Power is on\"\n"), 0o755)
+			if err != nil {
+				t.Fatalf("failed to write script: %v", err)
+			}
+
+			q := &QueryParams{Host: "10.0.0.1", Port: HTTPS_PORT, User: "root", Pass: "secret", Timeout: 5, IpmiPort: port, IpmitoolPath: script}
+			client, err := NewClient(testLogger(), q)
+			if err != nil {
+				t.Fatalf("failed to make client: %v", err)
+			}
+			drivers := client.Registry.For("ipmitool")
+			if len(drivers) != 1 {
+				t.Fatalf("expected the ipmitool provider to be registered, got %d", len(drivers))
+			}
+			opener, ok := drivers[0].DriverInterface.(interface{ Open(context.Context) error })
+			if !ok {
+				t.Fatalf("unexpected ipmitool provider %T", drivers[0].DriverInterface)
+			}
+			err = opener.Open(context.Background())
+			if err != nil {
+				t.Fatalf("failed to open ipmitool provider: %v", err)
+			}
+
+			b, err := os.ReadFile(args)
+			if err != nil {
+				t.Fatalf("failed to read ipmitool arguments: %v", err)
+			}
+			if !strings.Contains(string(b), "-H 10.0.0.1 -p "+expected+" ") {
+				t.Errorf("expected ipmitool to be run with port %s, got %q", expected, b)
+			}
+		})
+	}
+}
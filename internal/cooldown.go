@@ -0,0 +1,100 @@
+package magellan
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"time"
+
+	"github.com/OpenCHAMI/magellan/internal/util"
+)
+
+// CooldownEntry is the last failure recorded for a host.
+type CooldownEntry struct {
+	LastFailure time.Time `json:"last_failure"`
+	Reason      string    `json:"reason"`
+}
+
+// CooldownStore keeps track of hosts that failed to be collected so they can
+// be skipped for a period of time on subsequent runs. The store is persisted
+// as a JSON file.
+type CooldownStore struct {
+	Path    string                   `json:"-"`
+	Entries map[string]CooldownEntry `json:"entries"`
+}
+
+// LoadCooldownStore reads the store from path. A missing file is not an
+// error and returns an empty store.
+func LoadCooldownStore(path string) (*CooldownStore, error) {
+	store := &CooldownStore{
+		Path:    path,
+		Entries: map[string]CooldownEntry{},
+	}
+
+	exists, err := util.PathExists(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check for cooldown store: %v", err)
+	}
+	if !exists {
+		return store, nil
+	}
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read cooldown store: %v", err)
+	}
+	err = json.Unmarshal(b, store)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal cooldown store: %v", err)
+	}
+	if store.Entries == nil {
+		store.Entries = map[string]CooldownEntry{}
+	}
+	return store, nil
+}
+
+// InCooldown returns true if the host failed within the cooldown period.
+func (s *CooldownStore) InCooldown(host string, cooldown time.Duration) bool {
+	entry, ok := s.Entries[host]
+	if !ok {
+		return false
+	}
+	return time.Since(entry.LastFailure) < cooldown
+}
+
+// Update records the failed hosts and clears the ones that succeeded.
+func (s *CooldownStore) Update(results []CollectResult) {
+	now := time.Now()
+	for _, result := range results {
+		if result.Success {
+			delete(s.Entries, result.Host)
+			continue
+		}
+		reason := result.Error
+		if result.NotBMC {
+			reason = "not a BMC"
+		}
+		s.Entries[result.Host] = CooldownEntry{
+			LastFailure: now,
+			Reason:      reason,
+		}
+	}
+}
+
+// Save writes the store back to its path.
+func (s *CooldownStore) Save() error {
+	b, err := json.MarshalIndent(s, "", "    ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal cooldown store: %v", err)
+	}
+	err = os.MkdirAll(path.Dir(s.Path), 0700)
+	if err != nil {
+		return fmt.Errorf("failed to make cooldown store directory: %v", err)
+	}
+	err = os.WriteFile(s.Path, b, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to write cooldown store: %v", err)
+	}
+	return nil
+}
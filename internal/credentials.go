@@ -0,0 +1,100 @@
+package magellan
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Credentials are the username and password used to log in to a BMC.
+type Credentials struct {
+	User string `json:"user" yaml:"user"`
+	Pass string `json:"pass" yaml:"pass"`
+}
+
+// CredentialMap maps either a host or a subnet in CIDR notation to the
+// credentials used for it.
+type CredentialMap map[string]Credentials
+
+// LoadCredentialMap reads a credential mapping from a YAML or JSON file
+// containing a single object of hosts/subnets to credentials.
+func LoadCredentialMap(path string) (CredentialMap, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read credentials: %v", err)
+	}
+
+	// JSON is valid YAML so both can be read the same way
+	credentials := CredentialMap{}
+	err = yaml.Unmarshal(b, &credentials)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal credentials: %v", err)
+	}
+	for key := range credentials {
+		if strings.Contains(key, "/") {
+			if _, _, err := net.ParseCIDR(key); err != nil {
+				return nil, fmt.Errorf("invalid subnet '%s' in credentials: %v", key, err)
+			}
+		}
+	}
+	return credentials, nil
+}
+
+// Lookup returns the credentials for the host. An exact match on the host is
+// used first, then the most specific subnet containing it. The given user and
+// pass are returned when nothing matches and are kept for the fields left
+// empty in the entry that matched (i.e. one only setting the password).
+func (m CredentialMap) Lookup(host string, user string, pass string) (string, string) {
+	if creds, ok := m[host]; ok {
+		return creds.withDefaults(user, pass)
+	}
+
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return user, pass
+	}
+	var (
+		match   Credentials
+		longest = -1
+	)
+	for key, creds := range m {
+		_, subnet, err := net.ParseCIDR(key)
+		if err != nil || !subnet.Contains(ip) {
+			continue
+		}
+		if size, _ := subnet.Mask.Size(); size > longest {
+			longest = size
+			match = creds
+		}
+	}
+	if longest < 0 {
+		return user, pass
+	}
+	return match.withDefaults(user, pass)
+}
+
+// withDefaults returns the credentials with user and pass used for the
+// fields that are empty.
+func (c Credentials) withDefaults(user string, pass string) (string, string) {
+	if c.User != "" {
+		user = c.User
+	}
+	if c.Pass != "" {
+		pass = c.Pass
+	}
+	return user, pass
+}
+
+// credentials returns the user and password used to log in to the BMC at the
+// host. The credential provider is used when set, otherwise the credentials
+// are looked up in q.Credentials falling back to q.User and q.Pass.
+func (q *QueryParams) credentials(host string) (string, string, error) {
+	if q.CredentialProvider != nil {
+		return q.CredentialProvider.Get(host)
+	}
+	user, pass := q.Credentials.Lookup(host, q.User, q.Pass)
+	return user, pass, nil
+}
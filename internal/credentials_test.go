@@ -0,0 +1,33 @@
+package magellan
+
+import "testing"
+
+func TestCredentialMapLookup(t *testing.T) {
+	m := CredentialMap{
+		"10.0.0.5":    {User: "node5", Pass: "secret5"},
+		"10.0.0.0/16": {User: "rack", Pass: "rackpass"},
+		"10.0.1.0/24": {User: "row", Pass: "rowpass"},
+		"10.0.2.0/24": {Pass: "onlypass"},
+	}
+	tests := []struct {
+		name string
+		host string
+		user string
+		pass string
+	}{
+		{"exact host", "10.0.0.5", "node5", "secret5"},
+		{"subnet", "10.0.3.1", "rack", "rackpass"},
+		{"most specific subnet", "10.0.1.7", "row", "rowpass"},
+		{"empty user", "10.0.2.9", "root", "onlypass"},
+		{"fallback", "192.168.0.1", "root", "default"},
+		{"hostname", "bmc01", "root", "default"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			user, pass := m.Lookup(test.host, "root", "default")
+			if user != test.user || pass != test.pass {
+				t.Errorf("expected %s/%s, got %s/%s", test.user, test.pass, user, pass)
+			}
+		})
+	}
+}
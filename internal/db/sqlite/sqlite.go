@@ -2,6 +2,7 @@ package sqlite
 
 import (
 	"fmt"
+	"time"
 
 	magellan "github.com/OpenCHAMI/magellan/internal"
 
@@ -92,3 +93,106 @@ func GetProbeResults(path string) ([]magellan.ScannedResult, error) {
 	}
 	return results, nil
 }
+
+// CollectedRecord is the data collected from a host at one point in time.
+type CollectedRecord struct {
+	ID          int64     `db:"id" json:"-"`
+	Host        string    `db:"host" json:"host"`
+	Port        int       `db:"port" json:"port"`
+	Xname       string    `db:"xname" json:"xname"`
+	CollectedAt time.Time `db:"collected_at" json:"collected_at"`
+	Payload     string    `db:"payload" json:"payload"`
+	Success     bool      `db:"success" json:"success"`
+}
+
+// OpenCollectedRecords opens the database at path and creates the table of
+// collected records if it does not exist yet.
+func OpenCollectedRecords(path string) (*sqlx.DB, error) {
+	schema := `
+	CREATE TABLE IF NOT EXISTS magellan_collected_hosts (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		host TEXT NOT NULL,
+		port INTEGER NOT NULL,
+		xname TEXT,
+		collected_at TIMESTAMP NOT NULL,
+		payload TEXT,
+		success INTEGER NOT NULL
+	);
+	CREATE INDEX IF NOT EXISTS magellan_collected_hosts_host ON magellan_collected_hosts (host);
+	`
+	db, err := sqlx.Open("sqlite3", path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database: %v", err)
+	}
+	_, err = db.Exec(schema)
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to create collected hosts table: %v", err)
+	}
+	return db, nil
+}
+
+// InsertCollectResult adds a record of the result collected at the time.
+func InsertCollectResult(db *sqlx.DB, result magellan.CollectResult, collectedAt time.Time) error {
+	sql := `INSERT INTO magellan_collected_hosts (host, port, xname, collected_at, payload, success)
+	VALUES (:host, :port, :xname, :collected_at, :payload, :success);`
+	_, err := db.NamedExec(sql, &CollectedRecord{
+		Host:        result.Host,
+		Port:        result.Port,
+		Xname:       result.Xname,
+		CollectedAt: collectedAt,
+		Payload:     string(result.Payload),
+		Success:     result.Success,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to insert collected record: %v", err)
+	}
+	return nil
+}
+
+// GetLatestCollectedRecords returns the latest record of each host.
+func GetLatestCollectedRecords(path string) ([]CollectedRecord, error) {
+	db, err := OpenCollectedRecords(path)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	records := []CollectedRecord{}
+	err = db.Select(&records, `SELECT * FROM magellan_collected_hosts
+	WHERE id IN (SELECT MAX(id) FROM magellan_collected_hosts GROUP BY host)
+	ORDER BY host ASC;`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve collected records: %v", err)
+	}
+	return records, nil
+}
+
+// Sink writes the result of each host to the database at Path as it is
+// collected so it can be used as one of the sinks of magellan.QueryParams.
+type Sink struct {
+	Path string
+
+	db *sqlx.DB
+}
+
+func (s *Sink) Write(result magellan.CollectResult) error {
+	if s.db == nil {
+		db, err := OpenCollectedRecords(s.Path)
+		if err != nil {
+			return err
+		}
+		s.db = db
+	}
+	return InsertCollectResult(s.db, result, time.Now())
+}
+
+// Flush closes the database once every host has been written.
+func (s *Sink) Flush() error {
+	if s.db == nil {
+		return nil
+	}
+	err := s.db.Close()
+	s.db = nil
+	return err
+}
@@ -0,0 +1,70 @@
+package sqlite
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	magellan "github.com/OpenCHAMI/magellan/internal"
+)
+
+func TestCollectedRecords(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "magellan.db")
+
+	// the table is created when opening a new database
+	db, err := OpenCollectedRecords(path)
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	results := []magellan.CollectResult{
+		{Host: "10.0.0.1", Port: 443, Xname: "x1000c1s7b0", Payload: []byte(`{"run":1}`), Success: true},
+		{Host: "10.0.0.2", Port: 443, Xname: "x1000c1s7b1", Payload: []byte(`{"run":1}`), Success: true},
+		{Host: "10.0.0.1", Port: 443, Xname: "x1000c1s7b0", Payload: []byte(`{"run":2}`)},
+	}
+	for i, result := range results {
+		err = InsertCollectResult(db, result, start.Add(time.Duration(i)*time.Hour))
+		if err != nil {
+			t.Fatalf("failed to insert %s: %v", result.Host, err)
+		}
+	}
+	db.Close()
+
+	// opening an existing database keeps its records
+	records, err := GetLatestCollectedRecords(path)
+	if err != nil {
+		t.Fatalf("failed to get records: %v", err)
+	}
+	if len(records) != 2 {
+		t.Fatalf("expected the latest record of 2 hosts, got %d", len(records))
+	}
+	latest := records[0]
+	if latest.Host != "10.0.0.1" || latest.Payload != `{"run":2}` || latest.Success || !latest.CollectedAt.Equal(start.Add(2*time.Hour)) {
+		t.Errorf("unexpected latest record of 10.0.0.1: %+v", latest)
+	}
+	if records[1].Host != "10.0.0.2" || records[1].Xname != "x1000c1s7b1" || !records[1].Success {
+		t.Errorf("unexpected latest record of 10.0.0.2: %+v", records[1])
+	}
+}
+
+func TestSink(t *testing.T) {
+	sink := &Sink{Path: filepath.Join(t.TempDir(), "magellan.db")}
+	for _, host := range []string{"10.0.0.1", "10.0.0.2", "10.0.0.1"} {
+		err := sink.Write(magellan.CollectResult{Host: host, Port: 443, Payload: []byte(`{}`), Success: true})
+		if err != nil {
+			t.Fatalf("failed to write %s: %v", host, err)
+		}
+	}
+	err := sink.Flush()
+	if err != nil {
+		t.Fatalf("failed to flush: %v", err)
+	}
+
+	records, err := GetLatestCollectedRecords(sink.Path)
+	if err != nil {
+		t.Fatalf("failed to get records: %v", err)
+	}
+	if len(records) != 2 {
+		t.Errorf("expected 2 hosts, got %d", len(records))
+	}
+}
@@ -0,0 +1,92 @@
+package magellan
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"golang.org/x/crypto/nacl/secretbox"
+)
+
+// LoadEncryptionKey loads the 32-byte key used to encrypt output files from
+// the MAGELLAN_ENCRYPTION_KEY environment variable or, if not set, from the
+// file at path. The key can either be the raw bytes or encoded as base64 or
+// hex. An error is returned if no key is found.
+func LoadEncryptionKey(path string) (*[32]byte, error) {
+	encoded := os.Getenv("MAGELLAN_ENCRYPTION_KEY")
+	if encoded == "" && path != "" {
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read encryption key: %v", err)
+		}
+		if len(b) == 32 {
+			var key [32]byte
+			copy(key[:], b)
+			return &key, nil
+		}
+		encoded = string(b)
+	}
+	encoded = strings.TrimSpace(encoded)
+	if encoded == "" {
+		return nil, fmt.Errorf("no encryption key found in environment variable or file")
+	}
+
+	b, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		b, err = hex.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode encryption key (must be base64 or hex)")
+		}
+	}
+	if len(b) != 32 {
+		return nil, fmt.Errorf("invalid encryption key length %d (must be 32 bytes)", len(b))
+	}
+	var key [32]byte
+	copy(key[:], b)
+	return &key, nil
+}
+
+// EncryptOutput seals the data with NaCl secretbox. The random nonce is
+// prepended to the returned ciphertext.
+func EncryptOutput(key *[32]byte, data []byte) ([]byte, error) {
+	var nonce [24]byte
+	_, err := io.ReadFull(rand.Reader, nonce[:])
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate nonce: %v", err)
+	}
+	return secretbox.Seal(nonce[:], data, &nonce, key), nil
+}
+
+// DecryptOutput opens data that was encrypted with EncryptOutput.
+func DecryptOutput(key *[32]byte, data []byte) ([]byte, error) {
+	if len(data) < 24 {
+		return nil, fmt.Errorf("encrypted data is too short")
+	}
+	var nonce [24]byte
+	copy(nonce[:], data[:24])
+	b, ok := secretbox.Open(nil, data[24:], &nonce, key)
+	if !ok {
+		return nil, fmt.Errorf("failed to decrypt data (wrong key or corrupted file)")
+	}
+	return b, nil
+}
+
+// ReadOutputFile reads a file written by CollectAll, decrypting it first if
+// the file has the ".enc" extension.
+func ReadOutputFile(path string, key *[32]byte) ([]byte, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read output file: %v", err)
+	}
+	if !strings.HasSuffix(path, ".enc") {
+		return b, nil
+	}
+	if key == nil {
+		return nil, fmt.Errorf("no encryption key provided to decrypt '%s'", path)
+	}
+	return DecryptOutput(key, b)
+}
@@ -0,0 +1,314 @@
+package magellan
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"sync"
+)
+
+// lineWriter writes whole lines so records from different goroutines are
+// never interleaved.
+type lineWriter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// WriteLine writes b followed by a newline in a single write.
+func (lw *lineWriter) WriteLine(b []byte) error {
+	lw.mu.Lock()
+	defer lw.mu.Unlock()
+	_, err := lw.w.Write(append(b, '\n'))
+	return err
+}
+
+// columns expected by SMD's bulk import tooling for redfish endpoints
+var smdCsvHeader = []string{"ID", "FQDN", "Type", "MACAddr", "User", "Password", "Enabled", "RediscoverOnUpdate"}
+
+// WriteSmdCsv writes the collected data for each host as a row using the
+// columnar layout expected when bulk importing redfish endpoints into SMD.
+// Rows missing mandatory fields (ID, FQDN, or MACAddr) are still written so
+// the file can be fixed by hand, but the hosts are returned to be reported.
+func WriteSmdCsv(w io.Writer, records []map[string]any) ([]string, error) {
+	var (
+		writer  = csv.NewWriter(w)
+		missing = []string{}
+	)
+
+	err := writer.Write(smdCsvHeader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to write CSV header: %v", err)
+	}
+
+	for _, data := range records {
+		var (
+			id      = fmt.Sprint(valueOrEmpty(data["ID"]))
+			fqdn    = fmt.Sprint(valueOrEmpty(data["FQDN"]))
+			typ     = fmt.Sprint(valueOrEmpty(data["Type"]))
+			user    = fmt.Sprint(valueOrEmpty(data["User"]))
+			macAddr = firstSystemMAC(data)
+		)
+		if typ == "" {
+			typ = "NodeBMC"
+		}
+		if id == "" || fqdn == "" || macAddr == "" {
+			missing = append(missing, fqdn)
+		}
+
+		// the password is left as a placeholder to be filled in before import
+		err = writer.Write([]string{id, fqdn, typ, macAddr, user, "", "true", fmt.Sprint(valueOrEmpty(data["RediscoverOnUpdate"]))})
+		if err != nil {
+			return missing, fmt.Errorf("failed to write CSV row for %s: %v", fqdn, err)
+		}
+	}
+
+	writer.Flush()
+	return missing, writer.Error()
+}
+
+// WriteSmdCsvFile creates the file at path and writes the SMD bulk import CSV.
+func WriteSmdCsvFile(filepath string, records []map[string]any) ([]string, error) {
+	file, err := os.Create(path.Clean(filepath))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create CSV file: %v", err)
+	}
+	defer file.Close()
+	return WriteSmdCsv(file, records)
+}
+
+// firstSystemMAC returns the first non-empty MAC address found in the
+// collected systems' ethernet interfaces.
+func firstSystemMAC(data map[string]any) string {
+	// the systems are still the collected values and not JSON
+	raw, err := json.Marshal(data["Systems"])
+	if err != nil {
+		return ""
+	}
+
+	var systems []struct {
+		EthernetInterfaces []struct {
+			MACAddress string
+		}
+	}
+	if err := json.Unmarshal(raw, &systems); err != nil {
+		return ""
+	}
+	for _, system := range systems {
+		for _, eth := range system.EthernetInterfaces {
+			if eth.MACAddress != "" {
+				return eth.MACAddress
+			}
+		}
+	}
+	return ""
+}
+
+func valueOrEmpty(v any) any {
+	if v == nil {
+		return ""
+	}
+	return v
+}
+
+// columns of the flat inventory summary
+var inventoryCsvHeader = []string{"Host", "Xname", "Manufacturer", "Model", "SerialNumber", "BMCFirmware", "PowerState", "Reachable"}
+
+// WriteInventoryCsv writes a summary row for each host using the systems
+// (and firmware and power state if collected) found in the result's payload.
+// Fields that were not collected are left empty.
+func WriteInventoryCsv(w io.Writer, results []CollectResult) error {
+	writer := csv.NewWriter(w)
+	err := writer.Write(inventoryCsvHeader)
+	if err != nil {
+		return fmt.Errorf("failed to write CSV header: %v", err)
+	}
+
+	for _, result := range results {
+		var payload struct {
+			Systems []struct {
+				Data struct {
+					Manufacturer string
+					Model        string
+					SerialNumber string
+					PowerState   string
+				}
+			}
+			Firmware []struct {
+				ID      string
+				Name    string
+				Version string
+			}
+			PowerState string
+		}
+		if len(result.Payload) > 0 {
+			// keep whatever could be decoded since sections may be null
+			_ = json.Unmarshal(result.Payload, &payload)
+		}
+
+		var manufacturer, model, serial, powerState string
+		if len(payload.Systems) > 0 {
+			system := payload.Systems[0].Data
+			manufacturer = system.Manufacturer
+			model = system.Model
+			serial = system.SerialNumber
+			powerState = system.PowerState
+		}
+		if payload.PowerState != "" {
+			powerState = payload.PowerState
+		}
+
+		// firmware inventories usually name the BMC entry with "BMC"
+		var firmware string
+		for _, fw := range payload.Firmware {
+			if strings.Contains(strings.ToUpper(fw.ID+" "+fw.Name), "BMC") {
+				firmware = fw.Version
+				break
+			}
+		}
+
+		err = writer.Write([]string{
+			result.Host,
+			result.Xname,
+			manufacturer,
+			model,
+			serial,
+			firmware,
+			powerState,
+			fmt.Sprint(len(result.Payload) > 0),
+		})
+		if err != nil {
+			return fmt.Errorf("failed to write CSV row for %s: %v", result.Host, err)
+		}
+	}
+
+	writer.Flush()
+	return writer.Error()
+}
+
+// WriteInventoryCsvFile creates the file at path and writes the inventory CSV.
+func WriteInventoryCsvFile(filepath string, results []CollectResult) error {
+	file, err := os.Create(path.Clean(filepath))
+	if err != nil {
+		return fmt.Errorf("failed to create CSV file: %v", err)
+	}
+	defer file.Close()
+	return WriteInventoryCsv(file, results)
+}
+
+// MACMapping is the MAC address of the BMC at a host.
+type MACMapping struct {
+	MAC   string `json:"mac"`
+	Host  string `json:"host"`
+	Xname string `json:"xname"`
+}
+
+// ExtractMACs returns the normalized MAC addresses found in the payload of
+// the result. The interfaces of the managers (the BMC's own NICs) come first
+// followed by the other interfaces and then the ones of the systems.
+func ExtractMACs(result CollectResult) []string {
+	var payload struct {
+		EthernetInterfaces []struct {
+			ODataID    string `json:"@odata.id"`
+			MACAddress string
+		}
+		Systems []struct {
+			EthernetInterfaces []struct {
+				MACAddress string
+			}
+		}
+	}
+	if len(result.Payload) > 0 {
+		// keep whatever could be decoded since sections may be null
+		_ = json.Unmarshal(result.Payload, &payload)
+	}
+
+	var (
+		managers []string
+		others   []string
+	)
+	for _, eth := range payload.EthernetInterfaces {
+		if strings.Contains(eth.ODataID, "/Managers/") {
+			managers = append(managers, eth.MACAddress)
+		} else {
+			others = append(others, eth.MACAddress)
+		}
+	}
+	for _, system := range payload.Systems {
+		for _, eth := range system.EthernetInterfaces {
+			others = append(others, eth.MACAddress)
+		}
+	}
+
+	var (
+		macs = []string{}
+		seen = map[string]bool{}
+	)
+	for _, mac := range append(managers, others...) {
+		mac = NormalizeMAC(mac)
+		if mac == "" || seen[mac] {
+			continue
+		}
+		seen[mac] = true
+		macs = append(macs, mac)
+	}
+	return macs
+}
+
+// MACMappings returns the preferred MAC address of each host (see
+// ExtractMACs). Hosts without any MAC address are left out.
+func MACMappings(results []CollectResult) []MACMapping {
+	mappings := []MACMapping{}
+	for _, result := range results {
+		macs := ExtractMACs(result)
+		if len(macs) <= 0 {
+			continue
+		}
+		mappings = append(mappings, MACMapping{
+			MAC:   macs[0],
+			Host:  result.Host,
+			Xname: result.Xname,
+		})
+	}
+	return mappings
+}
+
+// WriteMACMappingFile writes the MAC address of each host to a JSON file
+// containing a list of objects or a CSV file with "mac,host,xname" rows. The
+// format is picked using the file extension.
+func WriteMACMappingFile(filepath string, results []CollectResult) error {
+	mappings := MACMappings(results)
+
+	var b []byte
+	switch strings.ToLower(path.Ext(filepath)) {
+	case ".json":
+		var err error
+		b, err = json.MarshalIndent(mappings, "", "    ")
+		if err != nil {
+			return fmt.Errorf("failed to marshal MAC mapping: %v", err)
+		}
+	case ".csv":
+		var buf strings.Builder
+		writer := csv.NewWriter(&buf)
+		rows := [][]string{{"mac", "host", "xname"}}
+		for _, mapping := range mappings {
+			rows = append(rows, []string{mapping.MAC, mapping.Host, mapping.Xname})
+		}
+		err := writer.WriteAll(rows)
+		if err != nil {
+			return fmt.Errorf("failed to write MAC mapping CSV: %v", err)
+		}
+		b = []byte(buf.String())
+	default:
+		return fmt.Errorf("unsupported MAC mapping format (must be .json or .csv)")
+	}
+
+	err := os.WriteFile(path.Clean(filepath), b, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to write MAC mapping: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,118 @@
+package magellan
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestExtractMACs(t *testing.T) {
+	f := newRedfishFixture(t)
+	f.set("/redfish/v1/Systems/1/EthernetInterfaces", collection("/redfish/v1/Systems/1/EthernetInterfaces",
+		"/redfish/v1/Systems/1/EthernetInterfaces/eth0",
+		"/redfish/v1/Systems/1/EthernetInterfaces/eth1",
+		"/redfish/v1/Systems/1/EthernetInterfaces/eth2",
+	))
+	f.set("/redfish/v1/Systems/1/EthernetInterfaces/eth1", map[string]any{
+		"@odata.id":  "/redfish/v1/Systems/1/EthernetInterfaces/eth1",
+		"Id":         "eth1",
+		"MACAddress": "AA-BB-CC-DD-EE-02",
+	})
+	f.set("/redfish/v1/Systems/1/EthernetInterfaces/eth2", map[string]any{
+		"@odata.id": "/redfish/v1/Systems/1/EthernetInterfaces/eth2",
+		"Id":        "eth2",
+	})
+	q := f.params(t)
+	q.CollectEthernet = true
+	q.MACMapPath = filepath.Join(t.TempDir(), "macs.csv")
+	host, port := f.hostPort()
+	states := []ScannedResult{{Host: host, Port: port, Protocol: "http", State: true}}
+
+	results, err := CollectAll(context.Background(), &states, testLogger(), q)
+	if err != nil {
+		t.Fatalf("failed to collect: %v", err)
+	}
+	if len(results) != 1 {
+		t.Fatalf("expected 1 result, got %d", len(results))
+	}
+
+	// the MAC of the BMC comes first followed by the others (normalized and
+	// without duplicates or empty addresses)
+	macs := ExtractMACs(results[0])
+	if len(macs) != 3 || macs[0] != "aa:bb:cc:dd:ee:00" {
+		t.Fatalf("expected 3 MACs starting with the manager's, got %v", macs)
+	}
+	found := map[string]bool{}
+	for _, mac := range macs {
+		found[mac] = true
+	}
+	if !found["aa:bb:cc:dd:ee:01"] || !found["aa:bb:cc:dd:ee:02"] {
+		t.Errorf("expected the MACs of the system, got %v", macs)
+	}
+
+	b, err := os.ReadFile(q.MACMapPath)
+	if err != nil {
+		t.Fatalf("failed to read MAC mapping: %v", err)
+	}
+	expected := "mac,host,xname\naa:bb:cc:dd:ee:00," + host + ",x1000c1s7b0\n"
+	if string(b) != expected {
+		t.Errorf("expected MAC mapping:\n%s\ngot:\n%s", expected, b)
+	}
+}
+
+func TestWriteInventoryCsv(t *testing.T) {
+	var (
+		dell = newRedfishFixture(t)
+		acme = newRedfishFixture(t)
+	)
+	dell.merge("/redfish/v1/Systems/1", map[string]any{"Manufacturer": "Dell Inc.", "Model": "PowerEdge R650", "SerialNumber": "ABC123"})
+	firmware(dell,
+		map[string]any{"Id": "BIOS", "Name": "BIOS", "Version": "1.8.2"},
+		map[string]any{"Id": "BMC", "Name": "Integrated Remote Access Controller", "Version": "6.10.30"},
+	)
+	// a comma in a value needs quoting and the missing serial an empty cell
+	acme.merge("/redfish/v1/Systems/1", map[string]any{"Manufacturer": "Acme, Inc.", "Model": "X1", "PowerState": "Off"})
+
+	q := dell.params(t)
+	q.Transport = routes(map[string]string{
+		"10.0.0.1": dell.Listener.Addr().String(),
+		"10.0.0.2": acme.Listener.Addr().String(),
+	})
+	q.CollectFirmware = true
+	states := []ScannedResult{
+		{Host: "10.0.0.1", Port: q.Port, Protocol: "http", State: true},
+		{Host: "10.0.0.2", Port: q.Port, Protocol: "http", State: true},
+	}
+	results, err := CollectAll(context.Background(), &states, testLogger(), q)
+	if err != nil {
+		t.Fatalf("failed to collect: %v", err)
+	}
+	sort.Slice(results, func(i, j int) bool { return results[i].Host < results[j].Host })
+
+	// a host that could not be collected still gets a row
+	results = append(results, CollectResult{Host: "10.0.0.3", Xname: "x1000c1s7b2"})
+
+	var b bytes.Buffer
+	err = WriteInventoryCsv(&b, results)
+	if err != nil {
+		t.Fatalf("failed to write CSV: %v", err)
+	}
+	records, err := csv.NewReader(&b).ReadAll()
+	if err != nil {
+		t.Fatalf("failed to read CSV back: %v", err)
+	}
+	expected := [][]string{
+		inventoryCsvHeader,
+		{"10.0.0.1", results[0].Xname, "Dell Inc.", "PowerEdge R650", "ABC123", "6.10.30", "On", "true"},
+		{"10.0.0.2", results[1].Xname, "Acme, Inc.", "X1", "", "", "Off", "true"},
+		{"10.0.0.3", "x1000c1s7b2", "", "", "", "", "", "false"},
+	}
+	if !reflect.DeepEqual(records, expected) {
+		t.Errorf("expected records:\n%q\ngot:\n%q", expected, records)
+	}
+}
@@ -0,0 +1,327 @@
+package magellan
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	stdlog "log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/OpenCHAMI/magellan/internal/log"
+	"github.com/sirupsen/logrus"
+	"github.com/stmcginnis/gofish"
+)
+
+// redfishFixture is a fake BMC serving a small Redfish tree (service root,
+// one system, one chassis, and one manager with ethernet interfaces) that
+// tests can change with set. It counts the sessions opened and closed and
+// the requests made to each resource.
+type redfishFixture struct {
+	*httptest.Server
+
+	mu        sync.Mutex
+	resources map[string]any // resources by path without a trailing slash
+	handlers  map[string]http.HandlerFunc
+	logins    int
+	logouts   int
+	requests  map[string]int
+}
+
+// newRedfishFixture starts a fake BMC over HTTP which is closed at the end
+// of the test.
+func newRedfishFixture(t testing.TB) *redfishFixture {
+	f := makeRedfishFixture()
+	f.Server = httptest.NewServer(f)
+	t.Cleanup(f.Close)
+	return f
+}
+
+// newRedfishTLSFixture starts a fake BMC over HTTPS with a self-signed
+// certificate which is closed at the end of the test. Failed handshakes are
+// not logged since tests cause them on purpose.
+func newRedfishTLSFixture(t testing.TB) *redfishFixture {
+	f := makeRedfishFixture()
+	f.Server = httptest.NewUnstartedServer(f)
+	f.Config.ErrorLog = stdlog.New(io.Discard, "", 0)
+	f.StartTLS()
+	t.Cleanup(f.Close)
+	return f
+}
+
+func makeRedfishFixture() *redfishFixture {
+	f := &redfishFixture{
+		resources: map[string]any{},
+		handlers:  map[string]http.HandlerFunc{},
+		requests:  map[string]int{},
+	}
+	f.set("/redfish/v1", map[string]any{
+		"@odata.id":      "/redfish/v1/",
+		"Id":             "RootService",
+		"Name":           "Root Service",
+		"RedfishVersion": "1.15.0",
+		"UUID":           "92384634-2938-2342-8820-489239905423",
+		"Systems":        link("/redfish/v1/Systems"),
+		"Chassis":        link("/redfish/v1/Chassis"),
+		"Managers":       link("/redfish/v1/Managers"),
+		"SessionService": link("/redfish/v1/SessionService"),
+		"Links": map[string]any{
+			"Sessions": link("/redfish/v1/SessionService/Sessions"),
+		},
+	})
+	f.set("/redfish/v1/Systems", collection("/redfish/v1/Systems", "/redfish/v1/Systems/1"))
+	f.set("/redfish/v1/Systems/1", map[string]any{
+		"@odata.id":          "/redfish/v1/Systems/1",
+		"Id":                 "1",
+		"Name":               "Node 1",
+		"UUID":               "38947555-7742-3448-3784-823347823834",
+		"PowerState":         "On",
+		"EthernetInterfaces": link("/redfish/v1/Systems/1/EthernetInterfaces"),
+		"Links": map[string]any{
+			"ManagedBy": []any{link("/redfish/v1/Managers/BMC")},
+		},
+	})
+	f.set("/redfish/v1/Systems/1/EthernetInterfaces", collection("/redfish/v1/Systems/1/EthernetInterfaces", "/redfish/v1/Systems/1/EthernetInterfaces/eth0"))
+	f.set("/redfish/v1/Systems/1/EthernetInterfaces/eth0", map[string]any{
+		"@odata.id":  "/redfish/v1/Systems/1/EthernetInterfaces/eth0",
+		"Id":         "eth0",
+		"Name":       "Node Ethernet",
+		"MACAddress": "AA:BB:CC:DD:EE:01",
+	})
+	f.set("/redfish/v1/Chassis", collection("/redfish/v1/Chassis", "/redfish/v1/Chassis/1"))
+	f.set("/redfish/v1/Chassis/1", map[string]any{
+		"@odata.id":   "/redfish/v1/Chassis/1",
+		"Id":          "1",
+		"Name":        "Enclosure",
+		"ChassisType": "RackMount",
+	})
+	f.set("/redfish/v1/Managers", collection("/redfish/v1/Managers", "/redfish/v1/Managers/BMC"))
+	f.set("/redfish/v1/Managers/BMC", map[string]any{
+		"@odata.id":          "/redfish/v1/Managers/BMC",
+		"Id":                 "BMC",
+		"Name":               "Manager",
+		"ManagerType":        "BMC",
+		"EthernetInterfaces": link("/redfish/v1/Managers/BMC/EthernetInterfaces"),
+	})
+	f.set("/redfish/v1/Managers/BMC/EthernetInterfaces", collection("/redfish/v1/Managers/BMC/EthernetInterfaces", "/redfish/v1/Managers/BMC/EthernetInterfaces/eth0"))
+	f.set("/redfish/v1/Managers/BMC/EthernetInterfaces/eth0", map[string]any{
+		"@odata.id":  "/redfish/v1/Managers/BMC/EthernetInterfaces/eth0",
+		"Id":         "eth0",
+		"Name":       "Manager Ethernet",
+		"MACAddress": "AA:BB:CC:DD:EE:00",
+	})
+	return f
+}
+
+// link returns a reference to the resource at path.
+func link(path string) map[string]any {
+	return map[string]any{"@odata.id": path}
+}
+
+// collection returns a collection at path with the members given.
+func collection(path string, members ...string) map[string]any {
+	links := make([]any, 0, len(members))
+	for _, member := range members {
+		links = append(links, link(member))
+	}
+	return map[string]any{
+		"@odata.id":           path,
+		"Name":                "Collection",
+		"Members":             links,
+		"Members@odata.count": len(links),
+	}
+}
+
+// firmware adds an UpdateService to the fixture with the firmware inventory
+// given (each needs an "Id").
+func firmware(f *redfishFixture, inventory ...map[string]any) {
+	members := make([]string, 0, len(inventory))
+	for _, fw := range inventory {
+		path := "/redfish/v1/UpdateService/FirmwareInventory/" + fw["Id"].(string)
+		fw["@odata.id"] = path
+		f.set(path, fw)
+		members = append(members, path)
+	}
+	f.set("/redfish/v1/UpdateService/FirmwareInventory", collection("/redfish/v1/UpdateService/FirmwareInventory", members...))
+	f.set("/redfish/v1/UpdateService", map[string]any{
+		"@odata.id":         "/redfish/v1/UpdateService",
+		"Id":                "UpdateService",
+		"FirmwareInventory": link("/redfish/v1/UpdateService/FirmwareInventory"),
+	})
+	f.merge("/redfish/v1", map[string]any{"UpdateService": link("/redfish/v1/UpdateService")})
+}
+
+// set replaces the resource at path (removed when v is nil).
+func (f *redfishFixture) set(path string, v any) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	path = strings.TrimSuffix(path, "/")
+	if v == nil {
+		delete(f.resources, path)
+		return
+	}
+	f.resources[path] = v
+}
+
+// merge sets the properties in values on the resource at path.
+func (f *redfishFixture) merge(path string, values map[string]any) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	resource := f.resources[strings.TrimSuffix(path, "/")].(map[string]any)
+	for key, value := range values {
+		resource[key] = value
+	}
+}
+
+// handle serves requests to path with fn instead of the resources which is
+// useful to make a resource slow or fail (see serve to fall back).
+func (f *redfishFixture) handle(path string, fn http.HandlerFunc) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.handlers[strings.TrimSuffix(path, "/")] = fn
+}
+
+// sessions returns the number of sessions opened and closed.
+func (f *redfishFixture) sessions() (logins int, logouts int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.logins, f.logouts
+}
+
+// count returns the number of requests made to the resource at path.
+func (f *redfishFixture) count(path string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.requests[strings.TrimSuffix(path, "/")]
+}
+
+func (f *redfishFixture) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimSuffix(r.URL.Path, "/")
+	f.mu.Lock()
+	f.requests[path]++
+	handler := f.handlers[path]
+	f.mu.Unlock()
+	if handler != nil {
+		handler(w, r)
+		return
+	}
+	f.serve(w, r)
+}
+
+// serve answers the request from the resources and sessions which handlers
+// set with handle can call to fall back to the default response.
+func (f *redfishFixture) serve(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimSuffix(r.URL.Path, "/")
+
+	f.mu.Lock()
+	resource, found := f.resources[path]
+	switch {
+	case r.Method == http.MethodPost && path == "/redfish/v1/SessionService/Sessions":
+		f.logins++
+		session := fmt.Sprintf("/redfish/v1/SessionService/Sessions/%d", f.logins)
+		f.mu.Unlock()
+		w.Header().Set("X-Auth-Token", fmt.Sprintf("token-%s", session))
+		w.Header().Set("Location", session)
+		w.WriteHeader(http.StatusCreated)
+		return
+	case r.Method == http.MethodDelete && strings.HasPrefix(path, "/redfish/v1/SessionService/Sessions/"):
+		f.logouts++
+		f.mu.Unlock()
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	f.mu.Unlock()
+
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if !found {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resource)
+}
+
+// hostPort returns the host and port the fixture listens on.
+func (f *redfishFixture) hostPort() (string, int) {
+	addr := f.Listener.Addr().(*net.TCPAddr)
+	return addr.IP.String(), addr.Port
+}
+
+// transport returns a transport sending the requests made to any host to
+// the fixture so multiple fake hosts can share it.
+func (f *redfishFixture) transport() http.RoundTripper {
+	addr := f.Listener.Addr().String()
+	return &http.Transport{
+		DialContext: func(ctx context.Context, network string, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, network, addr)
+		},
+	}
+}
+
+// routes returns a transport sending the requests made to each host in
+// addrs to its address so each fake host can be served by its own fixture.
+func routes(addrs map[string]string) http.RoundTripper {
+	return &http.Transport{
+		DialContext: func(ctx context.Context, network string, addr string) (net.Conn, error) {
+			host, _, _ := net.SplitHostPort(addr)
+			return (&net.Dialer{}).DialContext(ctx, network, addrs[host])
+		},
+	}
+}
+
+// params returns the params used to collect from the fixture writing the
+// output files to a temporary directory without adding anything to SMD.
+func (f *redfishFixture) params(t testing.TB) *QueryParams {
+	host, port := f.hostPort()
+	protocol := "http"
+	if f.TLS != nil {
+		protocol = "https"
+	}
+	return &QueryParams{
+		Host:       host,
+		Port:       port,
+		Protocol:   protocol,
+		User:       "root",
+		Pass:       "secret",
+		Drivers:    []string{"redfish"},
+		Timeout:    5,
+		OutputPath: t.TempDir(),
+		DryRun:     true,
+	}
+}
+
+// connect logs in to the fixture with q and returns the gofish client which
+// is logged out of at the end of the test.
+func (f *redfishFixture) connect(t testing.TB, q *QueryParams) *gofish.APIClient {
+	session := NewSession(testLogger(), q)
+	c, err := session.Redfish(context.Background())
+	if err != nil {
+		t.Fatalf("failed to connect to fixture: %v", err)
+	}
+	t.Cleanup(func() { session.Close(context.Background()) })
+	return c
+}
+
+// testLogger returns a logger that discards everything.
+func testLogger() *log.Logger {
+	l := logrus.New()
+	l.SetOutput(io.Discard)
+	return &log.Logger{Log: l}
+}
+
+// captureLogger returns a logger writing everything down to the debug level
+// to w.
+func captureLogger(w io.Writer) *log.Logger {
+	l := logrus.New()
+	l.SetOutput(w)
+	l.SetLevel(logrus.DebugLevel)
+	return &log.Logger{Log: l}
+}
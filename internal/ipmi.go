@@ -0,0 +1,111 @@
+package magellan
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/OpenCHAMI/magellan/internal/log"
+	bmclib "github.com/bmc-toolbox/bmclib/v2"
+)
+
+// CollectIpmiLan reads the LAN channel configuration (IP source, VLAN, auth
+// types, etc.) using ipmitool since bmclib does not expose it. The password
+// is passed through the environment so it does not show up in process lists.
+func CollectIpmiLan(ctx context.Context, q *QueryParams) ([]byte, error) {
+	value, err := collectIpmiLan(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+	return marshalSection("IpmiLan", value)
+}
+
+func collectIpmiLan(ctx context.Context, q *QueryParams) (any, error) {
+	ipmitool := q.IpmitoolPath
+	if ipmitool == "" {
+		ipmitool = "ipmitool"
+	}
+	channel := q.IpmiLanChannel
+	if channel <= 0 {
+		channel = 1
+	}
+
+	ctx, ctxCancel := context.WithTimeout(ctx, q.queryTimeout())
+	defer ctxCancel()
+
+	cmd := exec.CommandContext(ctx, ipmitool,
+		"-I", "lanplus",
+		"-H", q.Host,
+		"-p", fmt.Sprint(q.ipmiPort()),
+		"-U", q.User,
+		"-E",
+		"lan", "print", fmt.Sprint(channel),
+	)
+	cmd.Env = append(os.Environ(), "IPMI_PASSWORD="+q.Pass)
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get LAN config (%v:%v): %v", q.Host, q.ipmiPort(), err)
+	}
+
+	return parseIpmiLanPrint(string(out)), nil
+}
+
+// parseIpmiLanPrint converts the "key : value" output of `ipmitool lan print`
+// into a map. Lines without a key continue the value of the previous key.
+func parseIpmiLanPrint(output string) map[string]string {
+	var (
+		config  = map[string]string{}
+		scanner = bufio.NewScanner(strings.NewReader(output))
+		last    string
+	)
+	for scanner.Scan() {
+		key, value, found := strings.Cut(scanner.Text(), ":")
+		if !found {
+			continue
+		}
+		key = strings.TrimSpace(key)
+		value = strings.TrimSpace(value)
+		if key == "" && last != "" {
+			config[last] += "; " + value
+			continue
+		}
+		config[key] = value
+		last = key
+	}
+	return config
+}
+
+// ResetBMC issues a "cold" (reboot) or "warm" (restart management console)
+// reset to the BMC. This is the usual fix for a wedged BMC, but since it is a
+// write operation it is only ever done when explicitly requested.
+func ResetBMC(ctx context.Context, client *bmclib.Client, l *log.Logger, q *QueryParams, resetType string) error {
+	resetType = strings.ToLower(resetType)
+	if resetType != "cold" && resetType != "warm" {
+		return fmt.Errorf("invalid reset type '%s' (must be 'cold' or 'warm')", resetType)
+	}
+
+	filterCtx, filterCancel := context.WithTimeout(ctx, q.queryTimeout())
+	client.Registry.FilterForCompatible(filterCtx)
+	filterCancel()
+	err := retryConnect(ctx, q, client.Open)
+	if err != nil {
+		return fmt.Errorf("failed to connect to bmc: %v", err)
+	}
+	defer client.Close(ctx)
+
+	ctx, ctxCancel := context.WithTimeout(ctx, q.queryTimeout())
+	defer ctxCancel()
+
+	ok, err := client.ResetBMC(ctx, resetType)
+	if err != nil {
+		return fmt.Errorf("failed to reset bmc: %v", err)
+	}
+	if !ok {
+		return fmt.Errorf("failed to reset bmc: reset was not accepted")
+	}
+	l.Log.Infof("sent %s reset to BMC (%v)", resetType, q.Host)
+	return nil
+}
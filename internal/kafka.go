@@ -0,0 +1,108 @@
+package magellan
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+	"github.com/segmentio/kafka-go/sasl"
+	"github.com/segmentio/kafka-go/sasl/plain"
+	"github.com/segmentio/kafka-go/sasl/scram"
+)
+
+// KafkaConfig is how to reach and authenticate with the Kafka brokers used by
+// NewKafkaProducer.
+type KafkaConfig struct {
+	Brokers []string
+
+	// SASL mechanism ("plain", "scram-sha-256", or "scram-sha-512") used to
+	// log in with Username and Password (no SASL when empty)
+	SASLMechanism string
+	Username      string
+	Password      string
+
+	// connect with TLS using the CA in CaCertPath (or the system roots)
+	TLS        bool
+	CaCertPath string
+
+	// max time to publish a batch (10s when not set)
+	Timeout time.Duration
+}
+
+// KafkaWriter is a KafkaProducer publishing with the kafka-go client.
+type KafkaWriter struct {
+	writer  *kafka.Writer
+	timeout time.Duration
+}
+
+// NewKafkaProducer returns a producer publishing to the brokers in config
+// with the kafka-go client. It must be closed once done with it.
+func NewKafkaProducer(config KafkaConfig) (*KafkaWriter, error) {
+	if len(config.Brokers) <= 0 {
+		return nil, fmt.Errorf("no Kafka brokers set")
+	}
+
+	transport := &kafka.Transport{}
+	if config.TLS {
+		tlsConfig := &tls.Config{}
+		if config.CaCertPath != "" {
+			pool, err := loadCertPool(config.CaCertPath)
+			if err != nil {
+				return nil, err
+			}
+			tlsConfig.RootCAs = pool
+		}
+		transport.TLS = tlsConfig
+	}
+	if config.SASLMechanism != "" {
+		mechanism, err := kafkaSASLMechanism(config.SASLMechanism, config.Username, config.Password)
+		if err != nil {
+			return nil, err
+		}
+		transport.SASL = mechanism
+	}
+
+	timeout := config.Timeout
+	if timeout <= 0 {
+		timeout = 10 * time.Second
+	}
+	return &KafkaWriter{
+		writer: &kafka.Writer{
+			Addr:         kafka.TCP(config.Brokers...),
+			Balancer:     &kafka.Hash{}, // keep the messages of a BMC in order
+			RequiredAcks: kafka.RequireAll,
+			Transport:    transport,
+		},
+		timeout: timeout,
+	}, nil
+}
+
+func kafkaSASLMechanism(name string, username string, password string) (sasl.Mechanism, error) {
+	switch strings.ToLower(name) {
+	case "plain":
+		return plain.Mechanism{Username: username, Password: password}, nil
+	case "scram-sha-256":
+		return scram.Mechanism(scram.SHA256, username, password)
+	case "scram-sha-512":
+		return scram.Mechanism(scram.SHA512, username, password)
+	}
+	return nil, fmt.Errorf("invalid SASL mechanism '%s' (must be 'plain', 'scram-sha-256', or 'scram-sha-512')", name)
+}
+
+func (p *KafkaWriter) Produce(topic string, messages []KafkaMessage) error {
+	records := make([]kafka.Message, 0, len(messages))
+	for _, message := range messages {
+		records = append(records, kafka.Message{Topic: topic, Key: message.Key, Value: message.Value})
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), p.timeout)
+	defer cancel()
+	return p.writer.WriteMessages(ctx, records...)
+}
+
+// Close flushes and closes the connections to the brokers.
+func (p *KafkaWriter) Close() error {
+	return p.writer.Close()
+}
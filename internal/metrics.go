@@ -0,0 +1,131 @@
+package magellan
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// upper bounds (in seconds) of the buckets used for section latencies
+var metricsBuckets = []float64{0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60}
+
+// Metrics counts what happens while collecting and serves the counts in the
+// Prometheus text format. It is safe to share between goroutines so that
+// multiple collections can report to the same listener. Each set of metrics
+// has its own registry so they are never mixed with other collectors.
+type Metrics struct {
+	registry      *prometheus.Registry
+	hostsProbed   prometheus.Counter
+	hosts         *prometheus.CounterVec   // hosts by result ("success", "failure", or "not_bmc")
+	sections      *prometheus.CounterVec   // sections by name and result ("success" or "failure")
+	latencies     *prometheus.HistogramVec // time taken by section
+	activeWorkers prometheus.Gauge
+}
+
+// NewMetrics returns an empty set of metrics.
+func NewMetrics() *Metrics {
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+		hostsProbed: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "magellan_hosts_probed_total",
+			Help: "Number of hosts probed.",
+		}),
+		hosts: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "magellan_hosts_total",
+			Help: "Number of hosts collected from by result.",
+		}, []string{"result"}),
+		sections: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "magellan_sections_total",
+			Help: "Number of sections collected by section and result.",
+		}, []string{"section", "result"}),
+		latencies: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "magellan_section_duration_seconds",
+			Help:    "Time taken to collect each section.",
+			Buckets: metricsBuckets,
+		}, []string{"section"}),
+		activeWorkers: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "magellan_active_workers",
+			Help: "Number of workers collecting from a host.",
+		}),
+	}
+	m.registry.MustRegister(m.hostsProbed, m.hosts, m.sections, m.latencies, m.activeWorkers)
+	return m
+}
+
+// The methods below do nothing when m is nil so collection does not need to
+// check whether metrics are enabled.
+
+func (m *Metrics) probed() {
+	if m == nil {
+		return
+	}
+	m.hostsProbed.Inc()
+}
+
+func (m *Metrics) workerStarted() {
+	if m == nil {
+		return
+	}
+	m.activeWorkers.Inc()
+}
+
+func (m *Metrics) workerDone() {
+	if m == nil {
+		return
+	}
+	m.activeWorkers.Dec()
+}
+
+func (m *Metrics) hostDone(result CollectResult) {
+	if m == nil {
+		return
+	}
+	switch {
+	case result.NotBMC:
+		m.hosts.WithLabelValues("not_bmc").Inc()
+	case result.Success:
+		m.hosts.WithLabelValues("success").Inc()
+	default:
+		m.hosts.WithLabelValues("failure").Inc()
+	}
+}
+
+func (m *Metrics) sectionDone(section string, elapsed time.Duration, err error) {
+	if m == nil {
+		return
+	}
+	outcome := "success"
+	if err != nil {
+		outcome = "failure"
+	}
+	m.sections.WithLabelValues(section, outcome).Inc()
+	m.latencies.WithLabelValues(section).Observe(elapsed.Seconds())
+}
+
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{}).ServeHTTP(w, r)
+}
+
+// ServeMetrics serves the metrics at "/metrics" on addr until ctx is done.
+// The listener is opened before returning so address errors are reported
+// right away.
+func ServeMetrics(ctx context.Context, addr string, m *Metrics) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("failed to listen for metrics: %v", err)
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", m)
+	server := &http.Server{Handler: mux}
+	go server.Serve(listener)
+	go func() {
+		<-ctx.Done()
+		server.Close()
+	}()
+	return nil
+}
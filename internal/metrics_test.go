@@ -0,0 +1,53 @@
+package magellan
+
+import (
+	"errors"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMetricsScrape(t *testing.T) {
+	m := NewMetrics()
+	m.probed()
+	m.probed()
+	m.workerStarted()
+	m.hostDone(CollectResult{Success: true})
+	m.hostDone(CollectResult{Stage: "connect"})
+	m.sectionDone("Chassis", 200*time.Millisecond, nil)
+	m.sectionDone("Systems", 3*time.Second, errors.New("failed"))
+
+	server := httptest.NewServer(m)
+	defer server.Close()
+	res, err := server.Client().Get(server.URL)
+	if err != nil {
+		t.Fatalf("failed to scrape metrics: %v", err)
+	}
+	defer res.Body.Close()
+	if !strings.HasPrefix(res.Header.Get("Content-Type"), "text/plain") {
+		t.Errorf("unexpected content type %q", res.Header.Get("Content-Type"))
+	}
+	b, err := io.ReadAll(res.Body)
+	if err != nil {
+		t.Fatalf("failed to read metrics: %v", err)
+	}
+
+	body := string(b)
+	for _, line := range []string{
+		"magellan_hosts_probed_total 2",
+		`magellan_hosts_total{result="success"} 1`,
+		`magellan_hosts_total{result="failure"} 1`,
+		`magellan_sections_total{result="success",section="Chassis"} 1`,
+		`magellan_sections_total{result="failure",section="Systems"} 1`,
+		`magellan_section_duration_seconds_bucket{section="Chassis",le="0.25"} 1`,
+		`magellan_section_duration_seconds_bucket{section="Systems",le="2.5"} 0`,
+		`magellan_section_duration_seconds_count{section="Systems"} 1`,
+		"magellan_active_workers 1",
+	} {
+		if !strings.Contains(body, line+"\n") {
+			t.Errorf("missing %q in metrics:\n%s", line, body)
+		}
+	}
+}
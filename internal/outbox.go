@@ -0,0 +1,126 @@
+package magellan
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/OpenCHAMI/magellan/internal/api/smd"
+)
+
+// OutboxEntry is a RedfishEndpoint that could not be added to SMD and is
+// waiting to be sent again by FlushOutbox.
+type OutboxEntry struct {
+	ID       string            `json:"id"`
+	Payload  json.RawMessage   `json:"payload"`
+	Headers  map[string]string `json:"headers,omitempty"`
+	QueuedAt time.Time         `json:"queued_at"`
+	Attempts int               `json:"attempts"`
+}
+
+// QueueOutboxEntry writes the payload to the outbox directory so it can be
+// retried later. Only the latest payload of each ID is kept. The
+// Authorization header is not saved since the token would be written to disk
+// (and has likely expired by the time the outbox is flushed).
+func QueueOutboxEntry(dir string, id string, payload []byte, headers map[string]string) error {
+	entry := OutboxEntry{
+		ID:       id,
+		Payload:  payload,
+		Headers:  map[string]string{},
+		QueuedAt: time.Now(),
+	}
+	for key, value := range headers {
+		if strings.EqualFold(key, "Authorization") {
+			continue
+		}
+		entry.Headers[key] = value
+	}
+
+	err := os.MkdirAll(dir, 0700)
+	if err != nil {
+		return fmt.Errorf("failed to make outbox directory: %v", err)
+	}
+	return writeOutboxEntry(dir, entry)
+}
+
+func writeOutboxEntry(dir string, entry OutboxEntry) error {
+	b, err := json.MarshalIndent(entry, "", "    ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal outbox entry: %v", err)
+	}
+	err = os.WriteFile(outboxFilename(dir, entry.ID), b, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to write outbox entry: %v", err)
+	}
+	return nil
+}
+
+func outboxFilename(dir string, id string) string {
+	return filepath.Join(dir, strings.TrimSuffix(outputFilename(id, 0), "_0.json")+".json")
+}
+
+// FlushOutbox tries to add every queued entry to SMD again (updating the
+// endpoint if it was already added) and removes the ones that were sent.
+// The headers are added to the ones saved with each entry (i.e. to pass a
+// new access token). Entries that fail again are kept with their attempts
+// incremented. The number of entries sent is returned.
+func FlushOutbox(dir string, client *smd.Client, headers map[string]string) (int, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return 0, fmt.Errorf("failed to list outbox: %v", err)
+	}
+
+	var (
+		sent    int
+		errList []error
+	)
+	for _, file := range files {
+		b, err := os.ReadFile(file)
+		if err != nil {
+			errList = append(errList, fmt.Errorf("failed to read outbox entry: %v", err))
+			continue
+		}
+		var entry OutboxEntry
+		err = json.Unmarshal(b, &entry)
+		if err != nil {
+			errList = append(errList, fmt.Errorf("failed to unmarshal outbox entry '%s': %v", file, err))
+			continue
+		}
+
+		entryHeaders := map[string]string{}
+		for key, value := range entry.Headers {
+			entryHeaders[key] = value
+		}
+		for key, value := range headers {
+			entryHeaders[key] = value
+		}
+
+		err = client.AddRedfishEndpoint(entry.Payload, entryHeaders)
+		if errors.Is(err, smd.ErrEndpointExists) {
+			err = client.UpdateRedfishEndpoint(entry.ID, entry.Payload, entryHeaders)
+		}
+		if err != nil {
+			entry.Attempts += 1
+			errList = append(errList, fmt.Errorf("failed to send %s: %v", entry.ID, err))
+			err = writeOutboxEntry(dir, entry)
+			if err != nil {
+				errList = append(errList, err)
+			}
+			continue
+		}
+
+		err = os.Remove(file)
+		if err != nil {
+			errList = append(errList, fmt.Errorf("failed to remove outbox entry: %v", err))
+		}
+		sent += 1
+	}
+	if len(errList) > 0 {
+		return sent, fmt.Errorf("failed to flush outbox: %w", errors.Join(errList...))
+	}
+	return sent, nil
+}
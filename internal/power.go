@@ -0,0 +1,88 @@
+package magellan
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/OpenCHAMI/magellan/internal/log"
+	"github.com/stmcginnis/gofish"
+	"github.com/stmcginnis/gofish/redfish"
+)
+
+// actions accepted by SetPowerState
+var powerActions = []redfish.ResetType{
+	redfish.OnResetType,
+	redfish.ForceOffResetType,
+	redfish.GracefulShutdownResetType,
+	redfish.ForceRestartResetType,
+}
+
+// SetPowerState sends a reset action ("On", "ForceOff", "GracefulShutdown",
+// or "ForceRestart") to every system of the BMC and returns the power state
+// of the last system afterwards. The action must be one advertised by the
+// system when it lists its supported reset types. Since this changes the
+// state of the nodes, it should only ever be done when explicitly asked to.
+func SetPowerState(c *gofish.APIClient, l *log.Logger, q *QueryParams, action string) (redfish.PowerState, error) {
+	var resetType redfish.ResetType
+	for _, allowed := range powerActions {
+		if strings.EqualFold(action, string(allowed)) {
+			resetType = allowed
+			break
+		}
+	}
+	if resetType == "" {
+		return "", fmt.Errorf("invalid power action '%s' (must be 'On', 'ForceOff', 'GracefulShutdown', or 'ForceRestart')", action)
+	}
+
+	systems, err := c.Service.Systems()
+	if err != nil {
+		return "", fmt.Errorf("failed to get systems (%v:%v): %v", q.Host, q.Port, err)
+	}
+	if len(systems) <= 0 {
+		return "", fmt.Errorf("no systems found (%v:%v)", q.Host, q.Port)
+	}
+
+	// check every system first so none are reset when one does not support it
+	for _, system := range systems {
+		if len(system.SupportedResetTypes) > 0 && !containsResetType(system.SupportedResetTypes, resetType) {
+			return "", fmt.Errorf("power action '%s' is not supported by system '%s' (supports %v)", resetType, system.ID, system.SupportedResetTypes)
+		}
+	}
+
+	var state redfish.PowerState
+	for _, system := range systems {
+		err = system.Reset(resetType)
+		if err != nil {
+			return "", fmt.Errorf("failed to send power action '%s' to system '%s' (%v:%v): %v", resetType, system.ID, q.Host, q.Port, err)
+		}
+		l.Log.Infof("sent power action '%s' to system '%s' (%v)", resetType, system.ID, q.Host)
+
+		// read the state back since the action may not be instant
+		updated, err := redfish.GetComputerSystem(c, system.ODataID)
+		if err != nil {
+			return "", fmt.Errorf("failed to get power state of system '%s' (%v:%v): %v", system.ID, q.Host, q.Port, err)
+		}
+		state = updated.PowerState
+	}
+	return state, nil
+}
+
+// SetHostPowerState connects to the BMC in q and calls SetPowerState.
+func SetHostPowerState(ctx context.Context, l *log.Logger, q *QueryParams, action string) (redfish.PowerState, error) {
+	c, err := connectGofish(ctx, q, nil)
+	if err != nil {
+		return "", err
+	}
+	defer c.Logout()
+	return SetPowerState(c, l, q, action)
+}
+
+func containsResetType(types []redfish.ResetType, resetType redfish.ResetType) bool {
+	for _, t := range types {
+		if t == resetType {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,70 @@
+package magellan
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/stmcginnis/gofish/redfish"
+)
+
+// resettable makes system 1 of the fixture accept reset actions of the
+// allowed types, recording the reset type of each and turning the system
+// off or on accordingly.
+func resettable(f *redfishFixture, allowed ...string) *[]string {
+	target := "/redfish/v1/Systems/1/Actions/ComputerSystem.Reset"
+	f.merge("/redfish/v1/Systems/1", map[string]any{
+		"Actions": map[string]any{
+			"#ComputerSystem.Reset": map[string]any{
+				"target":                            target,
+				"ResetType@Redfish.AllowableValues": allowed,
+			},
+		},
+	})
+	resets := &[]string{}
+	f.handle(target, func(w http.ResponseWriter, r *http.Request) {
+		var body struct{ ResetType string }
+		json.NewDecoder(r.Body).Decode(&body)
+		state := "On"
+		if body.ResetType == string(redfish.ForceOffResetType) {
+			state = "Off"
+		}
+		f.mu.Lock()
+		*resets = append(*resets, body.ResetType)
+		f.mu.Unlock()
+		f.merge("/redfish/v1/Systems/1", map[string]any{"PowerState": state})
+		w.WriteHeader(http.StatusNoContent)
+	})
+	return resets
+}
+
+func TestSetPowerState(t *testing.T) {
+	f := newRedfishFixture(t)
+	resets := resettable(f, "On", "ForceOff")
+	q := f.params(t)
+	c := f.connect(t, q)
+
+	state, err := SetPowerState(c, testLogger(), q, "forceoff")
+	if err != nil {
+		t.Fatalf("failed to set power state: %v", err)
+	}
+	if state != redfish.OffPowerState {
+		t.Errorf("expected the system to be off, got %s", state)
+	}
+	if len(*resets) != 1 || (*resets)[0] != "ForceOff" {
+		t.Errorf("expected one ForceOff reset, got %v", *resets)
+	}
+
+	// actions the system does not support are never sent
+	_, err = SetPowerState(c, testLogger(), q, "ForceRestart")
+	if err == nil {
+		t.Errorf("expected an error for an unsupported action")
+	}
+	_, err = SetPowerState(c, testLogger(), q, "Reboot")
+	if err == nil {
+		t.Errorf("expected an error for an invalid action")
+	}
+	if len(*resets) != 1 {
+		t.Errorf("expected no more resets, got %v", *resets)
+	}
+}
@@ -0,0 +1,33 @@
+package magellan
+
+import "time"
+
+const (
+	EVENT_HOST_START   = "host_start"   // a worker started collecting from the host
+	EVENT_SECTION_DONE = "section_done" // a section of the host was collected (or failed)
+	EVENT_HOST_DONE    = "host_done"    // the host was written to every sink
+)
+
+// CollectEvent reports the progress of a collection to QueryParams.Progress.
+// Section is only set for EVENT_SECTION_DONE and Result only for
+// EVENT_HOST_DONE. Error is set when the section or host failed.
+type CollectEvent struct {
+	Type    string
+	Host    string
+	Port    int
+	Section string
+	Error   string
+	Result  *CollectResult
+	Time    time.Time
+}
+
+// progress sends the event to the progress callback if there is one.
+func (q *QueryParams) progress(event CollectEvent) {
+	if q.Progress == nil {
+		return
+	}
+	if event.Time.IsZero() {
+		event.Time = q.now()
+	}
+	q.Progress(event)
+}
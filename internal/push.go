@@ -0,0 +1,98 @@
+package magellan
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"sort"
+
+	"github.com/OpenCHAMI/magellan/internal/api/smd"
+	"github.com/OpenCHAMI/magellan/internal/log"
+)
+
+// PushResult is the outcome of adding one output file to SMD.
+type PushResult struct {
+	Path    string
+	ID      string
+	Success bool
+	Error   string
+}
+
+// PushOutputFiles adds the data in each file written to dir by CollectAll
+// (decrypting and unwrapping the envelope if needed) to SMD without querying
+// the BMCs again. Endpoints that were already added are updated the same way
+// as when collecting. The outcome of each file is returned.
+func PushOutputFiles(dir string, l *log.Logger, q *QueryParams) ([]PushResult, error) {
+	err := smd.ValidateBaseUrl(q.SmdEndpoint)
+	if err != nil {
+		return nil, err
+	}
+
+	var files []string
+	for _, pattern := range []string{"*.json", "*.json.enc"} {
+		matches, err := filepath.Glob(filepath.Join(dir, pattern))
+		if err != nil {
+			return nil, fmt.Errorf("failed to list output files: %v", err)
+		}
+		files = append(files, matches...)
+	}
+	if len(files) <= 0 {
+		return nil, fmt.Errorf("no output files found in '%s'", dir)
+	}
+	sort.Strings(files)
+
+	var (
+		client = smd.NewClient(
+			smd.WithSecureTLS(q.CaCertPath),
+			smd.WithBaseUrl(q.SmdEndpoint),
+		)
+		sink    = &smdSink{q: q, l: l, client: client}
+		results = make([]PushResult, 0, len(files))
+	)
+	for _, file := range files {
+		result := PushResult{Path: file}
+		payload, err := readPushPayload(file, q)
+		if err == nil {
+			var endpoint struct {
+				ID   string
+				FQDN string
+			}
+			_ = json.Unmarshal(payload, &endpoint)
+			result.ID = endpoint.ID
+			err = sink.Write(CollectResult{Host: endpoint.FQDN, Payload: payload})
+		}
+		if err != nil {
+			l.Log.Errorf("failed to push '%s': %v", file, err)
+			result.Error = err.Error()
+		} else {
+			result.Success = true
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+// readPushPayload reads the output file and returns the data sent to SMD
+// which is under the envelope key when the file has an envelope.
+func readPushPayload(file string, q *QueryParams) ([]byte, error) {
+	b, err := ReadOutputFile(file, q.EncryptionKey)
+	if err != nil {
+		return nil, err
+	}
+
+	var output map[string]json.RawMessage
+	err = json.Unmarshal(b, &output)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal output file: %v", err)
+	}
+	key := q.EnvelopeKey
+	if key == "" {
+		key = "data"
+	}
+	if _, ok := output["schema"]; ok {
+		if data, ok := output[key]; ok {
+			return data, nil
+		}
+	}
+	return b, nil
+}
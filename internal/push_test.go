@@ -0,0 +1,62 @@
+package magellan
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestPushOutputFiles(t *testing.T) {
+	server := newFakeSMD(t)
+	dir := t.TempDir()
+	files := map[string]string{
+		"10.0.0.1.json": `{"ID":"x1000c1s7b0","FQDN":"10.0.0.1","User":"root"}`,
+		"10.0.0.2.json": `{"schema":"v1","data":{"ID":"x1000c1s7b1","FQDN":"10.0.0.2","User":"root"}}`,
+		"10.0.0.3.json": `{"ID":"x1000c1s7b2","FQDN":"10.0.0.3","User":"root"}`,
+		"10.0.0.4.json": `not json`,
+		"notes.txt":     `ignored`,
+	}
+	for name, data := range files {
+		err := os.WriteFile(filepath.Join(dir, name), []byte(data), 0o644)
+		if err != nil {
+			t.Fatalf("failed to write %s: %v", name, err)
+		}
+	}
+
+	// one endpoint was added before and is updated instead
+	err := server.client().AddRedfishEndpoint([]byte(`{"ID":"x1000c1s7b2","FQDN":"old"}`), nil)
+	if err != nil {
+		t.Fatalf("failed to add endpoint: %v", err)
+	}
+
+	q := &QueryParams{SmdEndpoint: server.URL}
+	results, err := PushOutputFiles(dir, testLogger(), q)
+	if err != nil {
+		t.Fatalf("failed to push output files: %v", err)
+	}
+	if len(results) != 4 {
+		t.Fatalf("expected 4 results, got %+v", results)
+	}
+	for _, result := range results {
+		failed := filepath.Base(result.Path) == "10.0.0.4.json"
+		if result.Success == failed || (failed && result.Error == "") {
+			t.Errorf("unexpected result: %+v", result)
+		}
+	}
+	for _, id := range []string{"x1000c1s7b0", "x1000c1s7b1", "x1000c1s7b2"} {
+		endpoint := string(server.endpoint(id))
+		if !strings.Contains(endpoint, `"ID":"`+id+`"`) {
+			t.Errorf("expected %s to be in SMD, got %s", id, endpoint)
+		}
+	}
+	if strings.Contains(string(server.endpoint("x1000c1s7b2")), "old") {
+		t.Errorf("expected x1000c1s7b2 to be updated")
+	}
+
+	// an empty directory is an error
+	_, err = PushOutputFiles(t.TempDir(), testLogger(), q)
+	if err == nil {
+		t.Errorf("expected an error without output files")
+	}
+}
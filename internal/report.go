@@ -0,0 +1,188 @@
+package magellan
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path"
+	"sort"
+	"time"
+)
+
+type junitTestSuites struct {
+	XMLName xml.Name         `xml:"testsuites"`
+	Suites  []junitTestSuite `xml:"testsuite"`
+}
+
+type junitTestSuite struct {
+	Name     string          `xml:"name,attr"`
+	Tests    int             `xml:"tests,attr"`
+	Failures int             `xml:"failures,attr"`
+	Skipped  int             `xml:"skipped,attr"`
+	Time     string          `xml:"time,attr"`
+	Cases    []junitTestCase `xml:"testcase"`
+}
+
+type junitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	Classname string        `xml:"classname,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitFailure `xml:"failure,omitempty"`
+	Skipped   *junitSkipped `xml:"skipped,omitempty"`
+}
+
+type junitSkipped struct {
+	Message string `xml:"message,attr"`
+}
+
+type junitFailure struct {
+	Message string `xml:"message,attr"`
+	Type    string `xml:"type,attr"`
+	Body    string `xml:",chardata"`
+}
+
+// WriteJUnitReport converts the collection results into a JUnit XML report
+// with one test case per host. Hosts that failed have a failure containing
+// the stage and error message while hosts that are not BMCs are skipped.
+func WriteJUnitReport(w io.Writer, results []CollectResult) error {
+	suite := junitTestSuite{
+		Name:  "magellan.collect",
+		Tests: len(results),
+	}
+
+	var total float64
+	for _, result := range results {
+		testCase := junitTestCase{
+			Name:      fmt.Sprintf("%s:%d", result.Host, result.Port),
+			Classname: "magellan.collect." + result.Xname,
+			Time:      fmt.Sprintf("%.3f", result.Elapsed.Seconds()),
+		}
+		if result.NotBMC {
+			suite.Skipped += 1
+			testCase.Skipped = &junitSkipped{Message: "not a BMC"}
+		} else if !result.Success {
+			suite.Failures += 1
+			testCase.Failure = &junitFailure{
+				Message: fmt.Sprintf("failed at stage '%s'", result.Stage),
+				Type:    result.Stage,
+				Body:    result.Error,
+			}
+		}
+		total += result.Elapsed.Seconds()
+		suite.Cases = append(suite.Cases, testCase)
+	}
+	suite.Time = fmt.Sprintf("%.3f", total)
+
+	b, err := xml.MarshalIndent(junitTestSuites{Suites: []junitTestSuite{suite}}, "", "    ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal XML: %v", err)
+	}
+	_, err = w.Write(append([]byte(xml.Header), b...))
+	return err
+}
+
+// WriteJUnitReportFile creates the file at path and writes the JUnit report.
+func WriteJUnitReportFile(filepath string, results []CollectResult) error {
+	file, err := os.Create(path.Clean(filepath))
+	if err != nil {
+		return fmt.Errorf("failed to create report file: %v", err)
+	}
+	defer file.Close()
+	return WriteJUnitReport(file, results)
+}
+
+// Summary counts the outcomes of a collection run.
+type Summary struct {
+	Attempted      int            `json:"attempted"`
+	Succeeded      int            `json:"succeeded"`
+	Failed         int            `json:"failed"`
+	NotBMC         int            `json:"not_bmc"`
+	FailedStages   map[string]int `json:"failed_stages"`   // number of hosts that failed at each stage
+	FailedSections map[string]int `json:"failed_sections"` // number of hosts missing each section
+	Unreachable    []string       `json:"unreachable"`     // hosts that could not be connected to
+	ClockSkewed    []string       `json:"clock_skewed"`    // hosts with a clock off by more than QueryParams.MaxClockSkew
+	Elapsed        time.Duration  `json:"elapsed"`
+}
+
+// ClockSkewed returns the hosts whose clock is off by more than max (ahead
+// or behind). Hosts that did not report their time are left out.
+func ClockSkewed(results []CollectResult, max time.Duration) []string {
+	hosts := []string{}
+	for _, result := range results {
+		if result.ClockSkewSeconds == nil {
+			continue
+		}
+		if math.Abs(*result.ClockSkewSeconds) > max.Seconds() {
+			hosts = append(hosts, fmt.Sprintf("%s:%d", result.Host, result.Port))
+		}
+	}
+	sort.Strings(hosts)
+	return hosts
+}
+
+// ManagersClockSkew returns the seconds the clock of the first manager in
+// the Managers section that reports a DateTime is ahead of now (negative when
+// behind).
+func ManagersClockSkew(managers []map[string]any, now time.Time) (float64, error) {
+	for _, manager := range managers {
+		dateTime, _ := manager["DateTime"].(string)
+		if dateTime == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, dateTime)
+		if err != nil {
+			return 0, fmt.Errorf("invalid manager date time '%s': %v", dateTime, err)
+		}
+		return t.Sub(now).Seconds(), nil
+	}
+	return 0, fmt.Errorf("no manager reported its date time")
+}
+
+// Summarize counts the outcomes of the results. Hosts that succeeded but
+// are missing some sections count as succeeded and under FailedSections.
+func Summarize(results []CollectResult, elapsed time.Duration) Summary {
+	summary := Summary{
+		Attempted:      len(results),
+		FailedStages:   map[string]int{},
+		FailedSections: map[string]int{},
+		Unreachable:    []string{},
+		ClockSkewed:    []string{},
+		Elapsed:        elapsed,
+	}
+	for _, result := range results {
+		for section := range result.Errors {
+			summary.FailedSections[section] += 1
+		}
+		if result.NotBMC {
+			summary.NotBMC += 1
+			continue
+		}
+		if result.Success {
+			summary.Succeeded += 1
+			continue
+		}
+		summary.Failed += 1
+		summary.FailedStages[result.Stage] += 1
+		if result.Stage == "connect" {
+			summary.Unreachable = append(summary.Unreachable, fmt.Sprintf("%s:%d", result.Host, result.Port))
+		}
+	}
+	sort.Strings(summary.Unreachable)
+	return summary
+}
+
+// WriteSummaryFile writes the summary to a JSON file at path.
+func WriteSummaryFile(filepath string, summary Summary) error {
+	b, err := json.MarshalIndent(summary, "", "    ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal JSON: %v", err)
+	}
+	err = os.WriteFile(path.Clean(filepath), b, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to write summary file: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,70 @@
+package magellan
+
+import (
+	"context"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/OpenCHAMI/magellan/internal/log"
+)
+
+// Resolver looks up the names of an address (i.e. *net.Resolver).
+type Resolver interface {
+	LookupAddr(ctx context.Context, addr string) ([]string, error)
+}
+
+// names found for each address (empty when nothing was found) so each
+// address is only looked up once
+var fqdnCache sync.Map
+
+// resolveFQDN returns host with its IP address replaced by the name found
+// with a reverse DNS lookup. Host is returned as is when it is not an IP
+// address or no name is found.
+func resolveFQDN(ctx context.Context, host string, l *log.Logger, q *QueryParams) string {
+	// keep the scheme and port when the host is a URL
+	u := &url.URL{Host: host}
+	addr := host
+	if net.ParseIP(host) == nil {
+		parsed, err := url.Parse(host)
+		if err == nil && parsed.Host != "" {
+			u = parsed
+		}
+		addr = u.Hostname()
+		if net.ParseIP(addr) == nil {
+			return host
+		}
+	}
+
+	name, ok := fqdnCache.Load(addr)
+	if !ok {
+		resolver := q.Resolver
+		if resolver == nil {
+			resolver = net.DefaultResolver
+		}
+		ctx, cancel := context.WithTimeout(ctx, q.connectTimeout())
+		defer cancel()
+		names, err := resolver.LookupAddr(ctx, addr)
+		if err != nil || len(names) == 0 {
+			l.Log.Debugf("failed to resolve the name of %v, using the address instead: %v", addr, err)
+			name = ""
+		} else {
+			name = strings.TrimSuffix(names[0], ".")
+		}
+		fqdnCache.Store(addr, name)
+	}
+	if name == "" {
+		return host
+	}
+
+	if port := u.Port(); port != "" {
+		u.Host = net.JoinHostPort(name.(string), port)
+	} else {
+		u.Host = name.(string)
+	}
+	if u.Scheme == "" {
+		return u.Host
+	}
+	return u.String()
+}
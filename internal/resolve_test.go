@@ -0,0 +1,66 @@
+package magellan
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+)
+
+// fakeResolver returns the names set for each address and counts the
+// lookups made.
+type fakeResolver struct {
+	mu      sync.Mutex
+	names   map[string]string
+	lookups map[string]int
+}
+
+func (r *fakeResolver) LookupAddr(ctx context.Context, addr string) ([]string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.lookups[addr]++
+	name, ok := r.names[addr]
+	if !ok {
+		return nil, errors.New("no such host")
+	}
+	return []string{name}, nil
+}
+
+func TestResolveFQDN(t *testing.T) {
+	// the names found are cached for every test so forget them first
+	for _, addr := range []string{"192.0.2.10", "192.0.2.11"} {
+		fqdnCache.Delete(addr)
+	}
+	resolver := &fakeResolver{
+		names:   map[string]string{"192.0.2.10": "bmc10.example.com."},
+		lookups: map[string]int{},
+	}
+	q := &QueryParams{ResolveFQDN: true, Resolver: resolver, Timeout: 5}
+
+	tests := []struct {
+		host     string
+		expected string
+	}{
+		{host: "192.0.2.10", expected: "bmc10.example.com"},
+		{host: "192.0.2.11", expected: "192.0.2.11"},
+		{host: "https://192.0.2.10:8443", expected: "https://bmc10.example.com:8443"},
+		{host: "https://192.0.2.11", expected: "https://192.0.2.11"},
+		{host: "bmc12.example.com", expected: "bmc12.example.com"},
+	}
+	for _, test := range tests {
+		fqdn := resolveFQDN(context.Background(), test.host, testLogger(), q)
+		if fqdn != test.expected {
+			t.Errorf("expected %s to resolve to %s, got %s", test.host, test.expected, fqdn)
+		}
+	}
+
+	// each address is only looked up once whether a name was found or not
+	for _, addr := range []string{"192.0.2.10", "192.0.2.11"} {
+		if resolver.lookups[addr] != 1 {
+			t.Errorf("expected 1 lookup of %s, got %d", addr, resolver.lookups[addr])
+		}
+	}
+	if len(resolver.lookups) != 2 {
+		t.Errorf("expected only addresses to be looked up, got %v", resolver.lookups)
+	}
+}
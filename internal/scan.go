@@ -1,10 +1,21 @@
 package magellan
 
 import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"math"
 	"net"
 	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 
@@ -51,6 +62,90 @@ func rawConnect(host string, ports []int, timeout int, keepOpenOnly bool) []Scan
 	return results
 }
 
+// ProbeProtocols checks which of IPMI, SSH, and Redfish the host speaks on
+// the default ports. The checks run concurrently and each is given up to
+// timeout. One result is returned per protocol with State set when the host
+// answered with the expected protocol (an open port alone is not enough).
+func ProbeProtocols(host string, timeout time.Duration) []ScannedResult {
+	return probeProtocols(host, IPMI_PORT, SSH_PORT, HTTPS_PORT, timeout)
+}
+
+// probeProtocols is ProbeProtocols with the port of each protocol given.
+func probeProtocols(host string, ipmiPort int, sshPort int, redfishPort int, timeout time.Duration) []ScannedResult {
+	var (
+		results = []ScannedResult{
+			{Host: host, Port: ipmiPort, Protocol: "ipmi"},
+			{Host: host, Port: sshPort, Protocol: "ssh"},
+			{Host: host, Port: redfishPort, Protocol: "redfish"},
+		}
+		probes = []func(string, int, time.Duration) bool{probeIpmi, probeSsh, probeRedfish}
+		wg     sync.WaitGroup
+	)
+	wg.Add(len(results))
+	for i := range results {
+		go func(i int) {
+			defer wg.Done()
+			results[i].State = probes[i](host, results[i].Port, timeout)
+		}(i)
+	}
+	wg.Wait()
+	return results
+}
+
+// probeIpmi sends an RMCP presence ping over UDP and waits for the pong.
+func probeIpmi(host string, port int, timeout time.Duration) bool {
+	conn, err := net.DialTimeout("udp", net.JoinHostPort(host, fmt.Sprint(port)), timeout)
+	if err != nil {
+		return false
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(timeout))
+
+	// RMCP header (version 6, no ack, ASF class) then the ASF presence ping
+	ping := []byte{0x06, 0x00, 0xff, 0x06, 0x00, 0x00, 0x11, 0xbe, 0x80, 0x00, 0x00, 0x00}
+	if _, err := conn.Write(ping); err != nil {
+		return false
+	}
+	pong := make([]byte, 64)
+	n, err := conn.Read(pong)
+	if err != nil || n < 9 {
+		return false
+	}
+	return pong[3] == 0x06 && pong[8] == 0x40
+}
+
+// probeSsh connects and checks that the server sends an SSH banner.
+func probeSsh(host string, port int, timeout time.Duration) bool {
+	conn, err := net.DialTimeout("tcp", net.JoinHostPort(host, fmt.Sprint(port)), timeout)
+	if err != nil {
+		return false
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(timeout))
+
+	banner := make([]byte, 4)
+	if _, err := io.ReadFull(conn, banner); err != nil {
+		return false
+	}
+	return string(banner) == "SSH-"
+}
+
+// probeRedfish checks that the Redfish service root is served over HTTPS.
+func probeRedfish(host string, port int, timeout time.Duration) bool {
+	client := &http.Client{
+		Timeout: timeout,
+		Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+		},
+	}
+	url := fmt.Sprintf("https://%s/redfish/v1/", net.JoinHostPort(host, fmt.Sprint(port)))
+	res, _, err := util.MakeRequest(client, url, "GET", nil, nil)
+	if err != nil || res == nil {
+		return false
+	}
+	return res.StatusCode == http.StatusOK
+}
+
 func GenerateHosts(subnet string, subnetMask *net.IP) []string {
 	if subnet == "" || subnetMask == nil {
 		return nil
@@ -96,9 +191,16 @@ func generateHosts(ip *net.IP, mask *net.IPMask) []string {
 }
 
 func ScanForAssets(hosts []string, ports []int, threads int, timeout int, disableProbing bool, verbose bool) []ScannedResult {
+	return scanForAssets(context.Background(), hosts, ports, threads, timeout, disableProbing, verbose, nil)
+}
+
+// scanForAssets is ScanForAssets but stops handing out hosts once ctx is
+// done. Each host holds one of the sem slots (if not nil) while it is scanned
+// so scans share the slots with the collections running at the same time.
+func scanForAssets(ctx context.Context, hosts []string, ports []int, threads int, timeout int, disableProbing bool, verbose bool, sem chan struct{}) []ScannedResult {
 	var (
 		results  = make([]ScannedResult, 0, len(hosts))
-		done     = make(chan struct{}, threads+1)
+		mu       sync.Mutex
 		chanHost = make(chan string, threads+1)
 	)
 
@@ -106,17 +208,16 @@ func ScanForAssets(hosts []string, ports []int, threads int, timeout int, disabl
 	wg.Add(threads)
 	for i := 0; i < threads; i++ {
 		go func() {
-			for {
-				host, ok := <-chanHost
-				if !ok {
-					wg.Done()
-					return
+			defer wg.Done()
+			for host := range chanHost {
+				if sem != nil {
+					sem <- struct{}{}
 				}
 				scannedResults := rawConnect(host, ports, timeout, true)
 				if !disableProbing {
 					probeResults := []ScannedResult{}
 					for _, result := range scannedResults {
-						url := fmt.Sprintf("https://%s:%d/redfish/v1/", result.Host, result.Port)
+						url := fmt.Sprintf("https://%s/redfish/v1/", net.JoinHostPort(result.Host, fmt.Sprint(result.Port)))
 						res, _, err := util.MakeRequest(nil, url, "GET", nil, nil)
 						if err != nil || res == nil {
 							if verbose {
@@ -132,33 +233,280 @@ func ScanForAssets(hosts []string, ports []int, threads int, timeout int, disabl
 							probeResults = append(probeResults, result)
 						}
 					}
-					results = append(results, probeResults...)
-				} else {
-					results = append(results, scannedResults...)
+					scannedResults = probeResults
 				}
-
+				if sem != nil {
+					<-sem
+				}
+				mu.Lock()
+				results = append(results, scannedResults...)
+				mu.Unlock()
 			}
 		}()
 	}
 
 	for _, host := range hosts {
-		chanHost <- host
-	}
-	go func() {
 		select {
-		case <-done:
-			wg.Done()
+		case chanHost <- host:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
 			break
-		default:
-			time.Sleep(1000)
 		}
-	}()
+	}
 	close(chanHost)
 	wg.Wait()
-	close(done)
 	return results
 }
 
+// maximum number of addresses a single CIDR block or range may expand to
+const maxRangeSize = 1 << 16
+
+// ExpandHostRanges turns CIDR blocks (10.0.0.0/24) and IPv4 ranges
+// (10.0.0.1-10.0.0.20 or 10.0.0.1-20) into a list of hosts. The network and
+// broadcast addresses of CIDR blocks are skipped except for /31 and /32.
+// Anything else (including hostnames with a "-" like node-01) is kept as a
+// single host. Duplicates are removed while
+// keeping the order.
+func ExpandHostRanges(ranges []string) ([]string, error) {
+	var (
+		hosts = []string{}
+		seen  = map[string]bool{}
+	)
+	add := func(host string) {
+		if !seen[host] {
+			seen[host] = true
+			hosts = append(hosts, host)
+		}
+	}
+	for _, r := range ranges {
+		r = strings.TrimSpace(r)
+		if r == "" {
+			continue
+		}
+		var (
+			start, end uint32
+			err        error
+		)
+		if strings.Contains(r, "/") {
+			start, end, err = parseCIDRRange(r)
+		} else if first, _, found := strings.Cut(r, "-"); found && net.ParseIP(strings.TrimSpace(first)).To4() != nil {
+			start, end, err = parseIPRange(r)
+		} else {
+			add(r)
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		for v := uint64(start); v <= uint64(end); v++ {
+			add(uint32ToIP(uint32(v)).String())
+		}
+	}
+	return hosts, nil
+}
+
+func parseCIDRRange(r string) (uint32, uint32, error) {
+	_, network, err := net.ParseCIDR(r)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid CIDR '%s': %v", r, err)
+	}
+	ip := network.IP.To4()
+	ones, bits := network.Mask.Size()
+	if ip == nil || bits != 32 {
+		return 0, 0, fmt.Errorf("invalid CIDR '%s': only IPv4 is supported", r)
+	}
+	size := uint64(1) << (bits - ones)
+	if size > maxRangeSize {
+		return 0, 0, fmt.Errorf("invalid CIDR '%s': more than %d addresses", r, maxRangeSize)
+	}
+	start := ipToUint32(ip)
+	end := start + uint32(size-1)
+	if size > 2 {
+		start, end = start+1, end-1
+	}
+	return start, end, nil
+}
+
+func parseIPRange(r string) (uint32, uint32, error) {
+	first, last, _ := strings.Cut(r, "-")
+	startIp := net.ParseIP(strings.TrimSpace(first)).To4()
+	if startIp == nil {
+		return 0, 0, fmt.Errorf("invalid range '%s': start is not an IPv4 address", r)
+	}
+
+	// the end can be a full address or only the last octet
+	last = strings.TrimSpace(last)
+	if !strings.Contains(last, ".") {
+		last = fmt.Sprintf("%d.%d.%d.%s", startIp[0], startIp[1], startIp[2], last)
+	}
+	endIp := net.ParseIP(last).To4()
+	if endIp == nil {
+		return 0, 0, fmt.Errorf("invalid range '%s': end is not an IPv4 address", r)
+	}
+
+	start, end := ipToUint32(startIp), ipToUint32(endIp)
+	if start > end {
+		return 0, 0, fmt.Errorf("invalid range '%s': start is after end", r)
+	}
+	if uint64(end-start)+1 > maxRangeSize {
+		return 0, 0, fmt.Errorf("invalid range '%s': more than %d addresses", r, maxRangeSize)
+	}
+	return start, end, nil
+}
+
+func ipToUint32(ip net.IP) uint32 {
+	return binary.BigEndian.Uint32(ip.To4())
+}
+
+func uint32ToIP(v uint32) net.IP {
+	ip := make(net.IP, 4)
+	binary.BigEndian.PutUint32(ip, v)
+	return ip
+}
+
+// ProbeHosts runs ProbeProtocols against each host using a pool of threads
+// workers and returns the protocols found sorted by host and port.
+func ProbeHosts(hosts []string, threads int, timeout time.Duration) []ScannedResult {
+	if threads <= 0 {
+		threads = 1
+	}
+	var (
+		results   = []ScannedResult{}
+		chanHosts = make(chan string, threads)
+		mu        sync.Mutex
+		wg        sync.WaitGroup
+	)
+	wg.Add(threads)
+	for i := 0; i < threads; i++ {
+		go func() {
+			defer wg.Done()
+			for host := range chanHosts {
+				for _, result := range ProbeProtocols(host, timeout) {
+					if !result.State {
+						continue
+					}
+					mu.Lock()
+					results = append(results, result)
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+	for _, host := range hosts {
+		chanHosts <- host
+	}
+	close(chanHosts)
+	wg.Wait()
+	return SortScannedResults(results)
+}
+
+// SaveProbeStates writes the probe states to a JSON file so that they can
+// be collected from later without scanning again.
+func SaveProbeStates(path string, states []ScannedResult) error {
+	b, err := json.MarshalIndent(states, "", "    ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal probe states: %v", err)
+	}
+	err = os.WriteFile(path, b, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to write probe states: %v", err)
+	}
+	return nil
+}
+
+// LoadProbeStates reads probe states written by SaveProbeStates.
+func LoadProbeStates(path string) ([]ScannedResult, error) {
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("probe states file '%s' does not exist", path)
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to read probe states: %v", err)
+	}
+
+	var states []ScannedResult
+	err = json.Unmarshal(b, &states)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal probe states from '%s' (expected a JSON array of results): %v", path, err)
+	}
+	return states, nil
+}
+
+// LoadHostsFile reads a list of BMCs with one "host[:port]" per line and
+// returns them as open probe states so they can be collected from without
+// scanning. The port defaults to HTTPS_PORT and IPv6 addresses with a port
+// must be in brackets. Blank lines and anything after a "#" are ignored.
+func LoadHostsFile(path string) ([]ScannedResult, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read hosts file: %v", err)
+	}
+
+	states := []ScannedResult{}
+	for i, line := range strings.Split(string(b), "\n") {
+		line, _, _ = strings.Cut(line, "#")
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		host, port, err := parseHostPort(line)
+		if err != nil {
+			return nil, fmt.Errorf("invalid host on line %d of '%s': %v", i+1, path, err)
+		}
+		states = append(states, ScannedResult{
+			Host:     host,
+			Port:     port,
+			Protocol: "tcp",
+			State:    true,
+		})
+	}
+	return states, nil
+}
+
+func parseHostPort(s string) (string, int, error) {
+	// hosts without a port (including bare IPv6 addresses)
+	if !strings.HasPrefix(s, "[") && strings.Count(s, ":") != 1 {
+		if strings.ContainsAny(s, " \t/") {
+			return "", 0, fmt.Errorf("'%s' is not a host", s)
+		}
+		return s, HTTPS_PORT, nil
+	}
+	if strings.HasPrefix(s, "[") && strings.HasSuffix(s, "]") {
+		return strings.Trim(s, "[]"), HTTPS_PORT, nil
+	}
+
+	host, portStr, err := net.SplitHostPort(s)
+	if err != nil {
+		return "", 0, err
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil || port <= 0 || port > 65535 {
+		return "", 0, fmt.Errorf("invalid port '%s'", portStr)
+	}
+	if host == "" || strings.ContainsAny(host, " \t/") {
+		return "", 0, fmt.Errorf("'%s' is not a host", host)
+	}
+	return host, port, nil
+}
+
+// SortScannedResults returns a copy of the results sorted by host then port.
+// Hosts that are IP addresses are compared numerically.
+func SortScannedResults(results []ScannedResult) []ScannedResult {
+	sorted := append([]ScannedResult{}, results...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		a, b := sorted[i], sorted[j]
+		if a.Host != b.Host {
+			ipA, ipB := net.ParseIP(a.Host), net.ParseIP(b.Host)
+			if ipA != nil && ipB != nil {
+				return bytes.Compare(ipA.To16(), ipB.To16()) < 0
+			}
+			return a.Host < b.Host
+		}
+		return a.Port < b.Port
+	})
+	return sorted
+}
+
 func GetDefaultPorts() []int {
 	return []int{HTTPS_PORT}
 }
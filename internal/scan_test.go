@@ -0,0 +1,196 @@
+package magellan
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestExpandHostRanges(t *testing.T) {
+	tests := []struct {
+		name     string
+		ranges   []string
+		expected []string
+	}{
+		{"cidr", []string{"10.0.0.0/30"}, []string{"10.0.0.1", "10.0.0.2"}},
+		{"range", []string{"10.0.0.1-10.0.0.3"}, []string{"10.0.0.1", "10.0.0.2", "10.0.0.3"}},
+		{"last octet", []string{"10.0.0.254-255"}, []string{"10.0.0.254", "10.0.0.255"}},
+		{"hostnames", []string{"node-01", "bmc-rack1-02.example.com"}, []string{"node-01", "bmc-rack1-02.example.com"}},
+		{"duplicates", []string{"10.0.0.1", "10.0.0.0/31"}, []string{"10.0.0.1", "10.0.0.0"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			hosts, err := ExpandHostRanges(test.ranges)
+			if err != nil {
+				t.Fatalf("failed to expand ranges: %v", err)
+			}
+			if !reflect.DeepEqual(hosts, test.expected) {
+				t.Errorf("expected %v, got %v", test.expected, hosts)
+			}
+		})
+	}
+
+	// an IPv4 start with a bad end is still an invalid range
+	_, err := ExpandHostRanges([]string{"10.0.0.1-foo"})
+	if err == nil {
+		t.Errorf("expected an error for an invalid range")
+	}
+}
+
+func TestProbeStates(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "probes.json")
+	states := []ScannedResult{
+		{Host: "10.0.0.1", Port: HTTPS_PORT, Protocol: "https", State: true},
+		{Host: "fd00::1", Port: 8443, Protocol: "tcp", State: false},
+	}
+	err := SaveProbeStates(path, states)
+	if err != nil {
+		t.Fatalf("failed to save probe states: %v", err)
+	}
+	loaded, err := LoadProbeStates(path)
+	if err != nil {
+		t.Fatalf("failed to load probe states: %v", err)
+	}
+	if !reflect.DeepEqual(loaded, states) {
+		t.Errorf("expected %+v, got %+v", states, loaded)
+	}
+
+	// both a missing and a malformed file say what is wrong with it
+	_, err = LoadProbeStates(filepath.Join(dir, "missing.json"))
+	if err == nil || !strings.Contains(err.Error(), "does not exist") {
+		t.Errorf("expected an error for a missing file, got %v", err)
+	}
+	malformed := filepath.Join(dir, "malformed.json")
+	err = os.WriteFile(malformed, []byte(`{"host": "10.0.0.1"}`), 0o644)
+	if err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+	_, err = LoadProbeStates(malformed)
+	if err == nil || !strings.Contains(err.Error(), "expected a JSON array") {
+		t.Errorf("expected an error for a malformed file, got %v", err)
+	}
+}
+
+func TestLoadHostsFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "hosts.txt")
+	hosts := `# rack 1
+10.0.0.1
+10.0.0.2:8443  # behind a proxy
+
+bmc01.example.com
+fd00::1
+[fd00::2]:8443
+`
+	err := os.WriteFile(path, []byte(hosts), 0o644)
+	if err != nil {
+		t.Fatalf("failed to write hosts file: %v", err)
+	}
+	states, err := LoadHostsFile(path)
+	if err != nil {
+		t.Fatalf("failed to load hosts file: %v", err)
+	}
+	expected := []ScannedResult{
+		{Host: "10.0.0.1", Port: HTTPS_PORT, Protocol: "tcp", State: true},
+		{Host: "10.0.0.2", Port: 8443, Protocol: "tcp", State: true},
+		{Host: "bmc01.example.com", Port: HTTPS_PORT, Protocol: "tcp", State: true},
+		{Host: "fd00::1", Port: HTTPS_PORT, Protocol: "tcp", State: true},
+		{Host: "fd00::2", Port: 8443, Protocol: "tcp", State: true},
+	}
+	if !reflect.DeepEqual(states, expected) {
+		t.Errorf("expected %+v, got %+v", expected, states)
+	}
+
+	// the line number of the first malformed line is reported
+	tests := map[string]string{
+		"port":  "10.0.0.1\n10.0.0.2:http\n",
+		"range": "10.0.0.1\n10.0.0.2:99999\n",
+		"space": "10.0.0.1\n10.0.0.2 10.0.0.3\n",
+	}
+	for name, hosts := range tests {
+		t.Run(name, func(t *testing.T) {
+			err := os.WriteFile(path, []byte(hosts), 0o644)
+			if err != nil {
+				t.Fatalf("failed to write hosts file: %v", err)
+			}
+			_, err = LoadHostsFile(path)
+			if err == nil || !strings.Contains(err.Error(), "line 2") {
+				t.Errorf("expected an error on line 2, got %v", err)
+			}
+		})
+	}
+}
+
+// listenIpmi answers RMCP presence pings on an ephemeral UDP port until the
+// end of the test and returns the port.
+func listenIpmi(t *testing.T) int {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	go func() {
+		buf := make([]byte, 64)
+		for {
+			_, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			pong := []byte{0x06, 0x00, 0xff, 0x06, 0x00, 0x00, 0x11, 0xbe, 0x40, 0x00, 0x00, 0x10}
+			conn.WriteTo(pong, addr)
+		}
+	}()
+	return conn.LocalAddr().(*net.UDPAddr).Port
+}
+
+// listenTcp accepts connections on an ephemeral port until the end of the
+// test, writes banner to each and returns the port.
+func listenTcp(t *testing.T, banner string) int {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			conn.Write([]byte(banner))
+			conn.Close()
+		}
+	}()
+	return listener.Addr().(*net.TCPAddr).Port
+}
+
+func TestProbeProtocols(t *testing.T) {
+	var (
+		ipmi    = listenIpmi(t)
+		ssh     = listenTcp(t, "SSH-2.0-OpenSSH_9.0\r\n")
+		_, port = newRedfishTLSFixture(t).hostPort()
+	)
+	results := probeProtocols("127.0.0.1", ipmi, ssh, port, time.Second)
+	expected := []ScannedResult{
+		{Host: "127.0.0.1", Port: ipmi, Protocol: "ipmi", State: true},
+		{Host: "127.0.0.1", Port: ssh, Protocol: "ssh", State: true},
+		{Host: "127.0.0.1", Port: port, Protocol: "redfish", State: true},
+	}
+	if !reflect.DeepEqual(results, expected) {
+		t.Errorf("expected %+v, got %+v", expected, results)
+	}
+
+	// an open port answering with another protocol does not count
+	other := listenTcp(t, "220 ftp ready\r\n")
+	results = probeProtocols("127.0.0.1", other, other, other, time.Second)
+	for _, result := range results {
+		if result.State {
+			t.Errorf("expected %s to not be found on a port speaking another protocol", result.Protocol)
+		}
+	}
+}
@@ -0,0 +1,153 @@
+package magellan
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/OpenCHAMI/magellan/internal/log"
+	bmclib "github.com/bmc-toolbox/bmclib/v2"
+	"github.com/stmcginnis/gofish"
+)
+
+// Session is the connection to a single BMC shared by every query made to
+// it so the BMC is only logged in to once per host. The Redfish session and
+// the bmclib providers are each opened the first time they are needed and
+// kept until Close is called. A session is not safe to share between
+// goroutines.
+type Session struct {
+	q         *QueryParams
+	l         *log.Logger
+	capture   *certCapture
+	transport http.RoundTripper
+	root      *serviceRoot
+	gofish    *gofish.APIClient
+	bmc       *bmclib.Client
+}
+
+// serviceRoot is the response of the BMC to the Redfish service root.
+type serviceRoot struct {
+	status int
+	body   []byte
+}
+
+// NewSession returns a session for the BMC in q without connecting to it.
+func NewSession(l *log.Logger, q *QueryParams) *Session {
+	return &Session{q: q, l: l, capture: &certCapture{}}
+}
+
+// Transport returns the transport used for every Redfish request made to the
+// BMC, which is made the first time it is called. Sharing it means the time
+// spent waiting on a busy BMC is capped for the host rather than per request.
+func (s *Session) Transport() (http.RoundTripper, error) {
+	if s.transport != nil {
+		return s.transport, nil
+	}
+	transport, err := makeTransport(s.q, s.capture)
+	if err != nil {
+		return nil, err
+	}
+	s.transport = transport
+	return transport, nil
+}
+
+// ServiceRoot returns the status code and body of the Redfish service root,
+// which is requested without credentials the first time it is called. Every
+// check made before logging in (i.e. whether the host is a BMC and which
+// vendor made it) reads the same response.
+func (s *Session) ServiceRoot(ctx context.Context) (int, []byte, error) {
+	if s.root != nil {
+		return s.root.status, s.root.body, nil
+	}
+	transport, err := s.Transport()
+	if err != nil {
+		return 0, nil, err
+	}
+	err = s.q.wait(ctx)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, s.q.queryTimeout())
+	defer cancel()
+	url := fmt.Sprintf("%s://%s:%d/redfish/v1/", s.q.Protocol, urlHost(s.q.Host), s.q.Port)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return 0, nil, fmt.Errorf("failed to create service root request: %v", err)
+	}
+	req.Header.Add("User-Agent", "magellan")
+	res, err := (&http.Client{Transport: transport}).Do(req)
+	if err != nil {
+		return 0, nil, fmt.Errorf("failed to get service root: %v", err)
+	}
+	defer res.Body.Close()
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		return 0, nil, fmt.Errorf("failed to read service root: %v", err)
+	}
+	s.root = &serviceRoot{status: res.StatusCode, body: body}
+	return res.StatusCode, body, nil
+}
+
+// Redfish returns the gofish client of the session, logging in to the BMC
+// the first time it is called.
+func (s *Session) Redfish(ctx context.Context) (*gofish.APIClient, error) {
+	if s.gofish != nil {
+		return s.gofish, nil
+	}
+	transport, err := s.Transport()
+	if err != nil {
+		return nil, err
+	}
+
+	// gofish keeps the context it connected with for every later request so
+	// the attempt is bounded by the timeout of its HTTP client instead
+	var c *gofish.APIClient
+	err = retryConnect(ctx, s.q, func(context.Context) error {
+		var err error
+		c, err = connectGofish(ctx, s.q, transport)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	s.gofish = c
+	return c, nil
+}
+
+// BMC returns the bmclib client of the session, opening the providers the
+// first time it is called. Use PreferProvider on the client to pick the
+// provider used for a single query.
+func (s *Session) BMC(ctx context.Context) (*bmclib.Client, error) {
+	if s.bmc != nil {
+		return s.bmc, nil
+	}
+	client, err := NewClient(s.l, s.q)
+	if err != nil {
+		return nil, err
+	}
+
+	filterCtx, filterCancel := context.WithTimeout(ctx, s.q.queryTimeout())
+	client.Registry.FilterForCompatible(filterCtx)
+	filterCancel()
+	err = retryConnect(ctx, s.q, client.Open)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to bmc: %v", err)
+	}
+	s.bmc = client
+	return client, nil
+}
+
+// Close logs out of the Redfish session and closes the bmclib providers if
+// they were opened.
+func (s *Session) Close(ctx context.Context) {
+	if s.bmc != nil {
+		s.bmc.Close(ctx)
+		s.bmc = nil
+	}
+	if s.gofish != nil {
+		s.gofish.Logout()
+		s.gofish = nil
+	}
+}
@@ -0,0 +1,111 @@
+package magellan
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"testing"
+)
+
+func TestCollectAllOneSession(t *testing.T) {
+	f := newRedfishFixture(t)
+	q := f.params(t)
+	q.Transport = f.transport()
+	q.CollectServiceRoot = true
+	q.CollectPowerState = true
+	q.CollectManagers = true
+	q.CollectEthernet = true
+	q.CollectBoot = true
+	states := fleet(f, 3)
+
+	_, err := CollectAll(context.Background(), &states, testLogger(), q)
+	if err != nil {
+		t.Fatalf("failed to collect: %v", err)
+	}
+
+	// every section of a host shares the same session
+	logins, logouts := f.sessions()
+	if logins != len(states) || logouts != len(states) {
+		t.Errorf("expected %d sessions opened and closed, got %d opened and %d closed", len(states), logins, logouts)
+	}
+}
+
+func TestCollectAllNotBMC(t *testing.T) {
+	f := newRedfishFixture(t)
+	f.handle("/redfish/v1", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte("<html><body>It works!</body></html>"))
+	})
+	q := f.params(t)
+	q.VendorQuirks = true
+	states := []ScannedResult{{Host: q.Host, Port: q.Port, Protocol: "http", State: true}}
+
+	results, err := CollectAll(context.Background(), &states, testLogger(), q)
+	if err != nil {
+		t.Fatalf("failed to collect: %v", err)
+	}
+	if len(results) != 1 || !results[0].NotBMC {
+		t.Fatalf("expected the host to not be a BMC, got %+v", results)
+	}
+
+	// the service root is requested once through the session and never logged in to
+	if count := f.count("/redfish/v1"); count != 1 {
+		t.Errorf("expected the service root to be requested once, got %d", count)
+	}
+	if logins, _ := f.sessions(); logins != 0 {
+		t.Errorf("expected no session to be opened, got %d", logins)
+	}
+}
+
+func TestCollectAllServiceRootOnce(t *testing.T) {
+	f := newRedfishFixture(t)
+	q := f.params(t)
+	q.VendorQuirks = true
+	states := []ScannedResult{{Host: q.Host, Port: q.Port, Protocol: "http", State: true}}
+
+	_, err := CollectAll(context.Background(), &states, testLogger(), q)
+	if err != nil {
+		t.Fatalf("failed to collect: %v", err)
+	}
+
+	// checking the host and detecting the vendor share one request, and
+	// gofish requests it once more while logging in
+	if count := f.count("/redfish/v1"); count != 2 {
+		t.Errorf("expected the service root to be requested twice, got %d", count)
+	}
+}
+
+func TestCollectAllDedupe(t *testing.T) {
+	f := newRedfishFixture(t)
+	q := f.params(t)
+	q.Transport = f.transport()
+	q.Dedupe = true
+	q.Compact = true
+
+	// both hosts answer with the service root of the same BMC
+	states := fleet(f, 2)
+	results, err := CollectAll(context.Background(), &states, testLogger(), q)
+	if err != nil {
+		t.Fatalf("failed to collect: %v", err)
+	}
+	if len(results) != 1 || results[0].Host != states[0].Host {
+		t.Fatalf("expected only %s to be collected, got %+v", states[0].Host, results)
+	}
+	var data struct{ Aliases []string }
+	err = json.Unmarshal(results[0].Payload, &data)
+	if err != nil {
+		t.Fatalf("failed to unmarshal payload: %v", err)
+	}
+	alias := net.JoinHostPort(states[1].Host, fmt.Sprint(states[1].Port))
+	if len(data.Aliases) != 1 || data.Aliases[0] != alias {
+		t.Errorf("expected %s as an alias, got %v", alias, data.Aliases)
+	}
+
+	// once for each host while deduplicating (reused to check the kept host
+	// is a BMC) and once by gofish while logging in
+	if count := f.count("/redfish/v1"); count != 3 {
+		t.Errorf("expected the service root to be requested 3 times, got %d", count)
+	}
+}
@@ -0,0 +1,275 @@
+package magellan
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"sync"
+
+	"github.com/OpenCHAMI/magellan/internal/api/smd"
+	"github.com/OpenCHAMI/magellan/internal/log"
+)
+
+// OutputSink receives the result of each host after it is collected. The
+// collected data is in result.Payload as JSON. CollectAll writes to one sink
+// at a time so implementations do not need to be safe to share between
+// goroutines unless they are also used elsewhere.
+type OutputSink interface {
+	Write(result CollectResult) error
+}
+
+// makeOutput returns the payload as written to disk and logged which is
+// wrapped in an envelope if requested (SMD still gets the bare data).
+func makeOutput(result CollectResult, q *QueryParams) ([]byte, error) {
+	if !q.Envelope {
+		return result.Payload, nil
+	}
+	output, err := q.marshalOutput(makeEnvelope(json.RawMessage(result.Payload), q))
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal envelope to JSON: %v", err)
+	}
+	return output, nil
+}
+
+// logSink logs the output of each host at the debug level.
+type logSink struct {
+	q *QueryParams
+	l *log.Logger
+}
+
+func (s *logSink) Write(result CollectResult) error {
+	output, err := makeOutput(result, s.q)
+	if err != nil {
+		return err
+	}
+	s.l.Log.Debugf("collected data (%v:%v):\n%s", result.Host, result.Port, output)
+	return nil
+}
+
+// fileSink writes the output of each host to its own file in dir or as a
+// line in the ndjson file when set.
+type fileSink struct {
+	q      *QueryParams
+	dir    string
+	ndjson *lineWriter
+}
+
+func (s *fileSink) Write(result CollectResult) error {
+	output, err := makeOutput(result, s.q)
+	if err != nil {
+		return err
+	}
+
+	if s.ndjson != nil {
+		var line bytes.Buffer
+		err = json.Compact(&line, output)
+		if err != nil {
+			return fmt.Errorf("failed to compact JSON: %v", err)
+		}
+		return s.ndjson.WriteLine(line.Bytes())
+	}
+
+	filename := s.dir + "/" + outputFilename(result.Host, result.Port)
+	if s.q.EncryptionKey != nil {
+		filename += ".enc"
+		output, err = EncryptOutput(s.q.EncryptionKey, output)
+		if err != nil {
+			return err
+		}
+	}
+	return os.WriteFile(path.Clean(filename), output, s.q.fileMode())
+}
+
+// outputFilename names the file of a host by its host and port (so BMCs
+// forwarded through the same host do not overwrite each other) with any
+// characters that are not safe in file names (i.e. colons in IPv6) replaced.
+// The port is left out when it is the default HTTPS port so the files of most
+// BMCs keep their original "<host>.json" name.
+func outputFilename(host string, port int) string {
+	name := strings.Map(func(r rune) rune {
+		switch r {
+		case ':', '/', '\\', '%', '*', '?', '"', '<', '>', '|':
+			return '_'
+		}
+		return r
+	}, host)
+	if port == HTTPS_PORT {
+		return name + ".json"
+	}
+	return fmt.Sprintf("%s_%d.json", name, port)
+}
+
+// smdSink adds each host to SMD as a Redfish endpoint or updates it if it
+// was already added. Nothing is sent with q.DryRun. Hosts that fail to be
+// sent are queued in q.OutboxPath if set.
+type smdSink struct {
+	q      *QueryParams
+	l      *log.Logger
+	client *smd.Client
+}
+
+func (s *smdSink) Write(result CollectResult) error {
+	// send the credentials when CollectAll added them
+	body := result.smdPayload
+	if body == nil {
+		body = result.Payload
+	}
+
+	var payload struct {
+		ID string
+	}
+	err := json.Unmarshal(body, &payload)
+	if err != nil {
+		return fmt.Errorf("failed to unmarshal payload: %v", err)
+	}
+	err = smd.ValidateRedfishEndpoint(body)
+	if err != nil {
+		return err
+	}
+	if s.q.DryRun {
+		s.l.Log.Infof("dry run: skipped adding %s (%v) to SMD", payload.ID, result.Host)
+		return nil
+	}
+
+	headers, err := s.q.smdHeaders()
+	if err != nil {
+		return err
+	}
+	err = s.send(payload.ID, body, headers)
+
+	// the cached token may have expired or been revoked early so drop it and
+	// try once more with a new one
+	if errors.Is(err, smd.ErrUnauthorized) && s.q.SmdTokenUrl != "" {
+		source, sourceErr := s.q.smdTokenSource()
+		if sourceErr == nil {
+			source.Expire(strings.TrimPrefix(headers["Authorization"], "Bearer "))
+			headers, err = s.q.smdHeaders()
+			if err == nil {
+				err = s.send(payload.ID, body, headers)
+			}
+		}
+	}
+
+	// keep the data around to send again later when SMD could not be reached
+	if err != nil && s.q.OutboxPath != "" {
+		queueErr := QueueOutboxEntry(s.q.OutboxPath, payload.ID, body, headers)
+		if queueErr != nil {
+			s.l.Log.Errorf("failed to queue %s (%v) in outbox: %v", payload.ID, result.Host, queueErr)
+		} else {
+			s.l.Log.Warnf("queued %s (%v) in outbox to send to SMD later", payload.ID, result.Host)
+		}
+	}
+	return err
+}
+
+// send adds the endpoint to SMD or updates it if it was already added.
+func (s *smdSink) send(id string, body []byte, headers map[string]string) error {
+	err := s.client.AddRedfishEndpoint(body, headers)
+	if err != nil {
+		s.l.Log.Error(err)
+
+		// try updating instead if the endpoint was already added
+		if errors.Is(err, smd.ErrEndpointExists) || s.q.ForceUpdate {
+			err = s.client.UpdateRedfishEndpoint(id, body, headers)
+		}
+	}
+	return err
+}
+
+// smdHeaders returns the headers sent with every request to SMD. The access
+// token (or one from q.SmdTokenUrl) is used in the Authorization header if
+// set and q.SmdHeaders are added last so they can replace either default.
+func (q *QueryParams) smdHeaders() (map[string]string, error) {
+	headers := make(map[string]string)
+	headers["Content-Type"] = "application/json"
+
+	// use access token in authorization header if we have it
+	if q.AccessToken != "" {
+		headers["Authorization"] = "Bearer " + q.AccessToken
+	}
+	if q.SmdTokenUrl != "" {
+		token, err := q.smdToken()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get SMD access token: %v", err)
+		}
+		headers["Authorization"] = "Bearer " + token
+	}
+	for key, value := range q.SmdHeaders {
+		headers[key] = value
+	}
+	return headers, nil
+}
+
+// OutputFlusher is implemented by sinks that buffer results. Flush is called
+// once every host has been written.
+type OutputFlusher interface {
+	Flush() error
+}
+
+// KafkaMessage is a single record published to a Kafka topic.
+type KafkaMessage struct {
+	Key   []byte
+	Value []byte
+}
+
+// KafkaProducer publishes a batch of messages to a topic. NewKafkaProducer
+// returns one using kafka-go, but any client can be used (SASL and TLS are
+// configured on the client).
+type KafkaProducer interface {
+	Produce(topic string, messages []KafkaMessage) error
+}
+
+// KafkaSink publishes the collected data of each host to Topic using the
+// xname as the key (or the host when there is no xname). Messages are sent
+// in batches of BatchSize (100 by default) and the rest when flushed. It is
+// safe to share between goroutines.
+type KafkaSink struct {
+	Producer  KafkaProducer
+	Topic     string
+	BatchSize int
+
+	mu    sync.Mutex
+	batch []KafkaMessage
+}
+
+func (s *KafkaSink) Write(result CollectResult) error {
+	key := result.Xname
+	if key == "" {
+		key = result.Host
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.batch = append(s.batch, KafkaMessage{Key: []byte(key), Value: result.Payload})
+
+	batchSize := s.BatchSize
+	if batchSize <= 0 {
+		batchSize = 100
+	}
+	if len(s.batch) >= batchSize {
+		return s.flush()
+	}
+	return nil
+}
+
+// Flush publishes the messages that have not been sent yet.
+func (s *KafkaSink) Flush() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.flush()
+}
+
+func (s *KafkaSink) flush() error {
+	if len(s.batch) <= 0 {
+		return nil
+	}
+	err := s.Producer.Produce(s.Topic, s.batch)
+	if err != nil {
+		return fmt.Errorf("failed to publish %d message(s) to '%s': %v", len(s.batch), s.Topic, err)
+	}
+	s.batch = nil
+	return nil
+}
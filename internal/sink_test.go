@@ -0,0 +1,465 @@
+package magellan
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/OpenCHAMI/magellan/internal/api/smd"
+)
+
+// fakeProducer records the batches published to it.
+type fakeProducer struct {
+	mu      sync.Mutex
+	batches [][]KafkaMessage
+}
+
+func (p *fakeProducer) Produce(topic string, messages []KafkaMessage) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.batches = append(p.batches, append([]KafkaMessage{}, messages...))
+	return nil
+}
+
+func TestKafkaSinkBatches(t *testing.T) {
+	producer := &fakeProducer{}
+	sink := &KafkaSink{Producer: producer, Topic: "magellan", BatchSize: 2}
+
+	results := []CollectResult{
+		{Host: "10.0.0.1", Xname: "x1000c1s7b0", Payload: []byte(`{"ID":"x1000c1s7b0"}`)},
+		{Host: "10.0.0.2", Xname: "x1000c1s7b1", Payload: []byte(`{"ID":"x1000c1s7b1"}`)},
+		{Host: "10.0.0.3", Payload: []byte(`{}`)},
+	}
+	for _, result := range results {
+		err := sink.Write(result)
+		if err != nil {
+			t.Fatalf("failed to write %s: %v", result.Host, err)
+		}
+	}
+	if len(producer.batches) != 1 {
+		t.Fatalf("expected 1 batch before flushing, got %d", len(producer.batches))
+	}
+	err := sink.Flush()
+	if err != nil {
+		t.Fatalf("failed to flush: %v", err)
+	}
+	if len(producer.batches) != 2 {
+		t.Fatalf("expected 2 batches after flushing, got %d", len(producer.batches))
+	}
+
+	// keyed by xname or by host when there is none
+	keys := []string{}
+	for _, batch := range producer.batches {
+		for _, message := range batch {
+			keys = append(keys, string(message.Key))
+		}
+	}
+	expected := []string{"x1000c1s7b0", "x1000c1s7b1", "10.0.0.3"}
+	for i := range expected {
+		if i >= len(keys) || keys[i] != expected[i] {
+			t.Fatalf("expected keys %v, got %v", expected, keys)
+		}
+	}
+}
+
+func TestKafkaSinkConcurrentWrites(t *testing.T) {
+	producer := &fakeProducer{}
+	sink := &KafkaSink{Producer: producer, Topic: "magellan", BatchSize: 3}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			sink.Write(CollectResult{Host: "10.0.0.1", Payload: []byte(`{}`)})
+		}()
+	}
+	wg.Wait()
+	sink.Flush()
+
+	count := 0
+	for _, batch := range producer.batches {
+		count += len(batch)
+	}
+	if count != 50 {
+		t.Fatalf("expected 50 messages, got %d", count)
+	}
+}
+
+// fakeSMD is an SMD keeping the Redfish endpoints added to it and the states
+// of components in memory. It responds with status to every request instead
+// when set and rejects the requests without token as bearer token when set.
+type fakeSMD struct {
+	*httptest.Server
+
+	mu        sync.Mutex
+	status    int
+	token     string
+	endpoints map[string]json.RawMessage
+	states    map[string]string
+	headers   []http.Header
+	methods   []string
+}
+
+// newFakeSMD starts an SMD which is closed at the end of the test.
+func newFakeSMD(t *testing.T) *fakeSMD {
+	s := &fakeSMD{endpoints: map[string]json.RawMessage{}, states: map[string]string{}}
+	s.Server = httptest.NewServer(s)
+	t.Cleanup(s.Close)
+	return s
+}
+
+// client returns an SMD client for the fake.
+func (s *fakeSMD) client() *smd.Client {
+	return smd.NewClient(smd.WithBaseUrl(s.URL))
+}
+
+// fail makes every request fail with status (or succeed again when 0).
+func (s *fakeSMD) fail(status int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.status = status
+}
+
+// accept makes SMD only accept requests with token (or any when empty).
+func (s *fakeSMD) accept(token string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.token = token
+}
+
+func (s *fakeSMD) endpoint(id string) json.RawMessage {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.endpoints[id]
+}
+
+// state returns the state patched into the component (empty if never).
+func (s *fakeSMD) state(xname string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.states[xname]
+}
+
+// lastHeaders returns the headers of the last request made to SMD.
+func (s *fakeSMD) lastHeaders() http.Header {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.headers) == 0 {
+		return nil
+	}
+	return s.headers[len(s.headers)-1]
+}
+
+func (s *fakeSMD) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.headers = append(s.headers, r.Header.Clone())
+	s.methods = append(s.methods, r.Method)
+	if s.status != 0 {
+		w.WriteHeader(s.status)
+		return
+	}
+	if s.token != "" && r.Header.Get("Authorization") != "Bearer "+s.token {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	body, _ := io.ReadAll(r.Body)
+	var endpoint struct {
+		ID    string
+		State string
+	}
+	json.Unmarshal(body, &endpoint)
+	collection := "/hsm/v2/Inventory/RedfishEndpoints"
+	components := "/hsm/v2/State/Components/"
+	switch {
+	case r.Method == http.MethodGet && r.URL.Path == collection:
+		endpoints := make([]json.RawMessage, 0, len(s.endpoints))
+		for _, endpoint := range s.endpoints {
+			endpoints = append(endpoints, endpoint)
+		}
+		json.NewEncoder(w).Encode(map[string]any{"RedfishEndpoints": endpoints})
+	case r.Method == http.MethodPatch && strings.HasPrefix(r.URL.Path, components) && strings.HasSuffix(r.URL.Path, "/StateData"):
+		xname := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, components), "/StateData")
+		s.states[xname] = endpoint.State
+		w.WriteHeader(http.StatusNoContent)
+	case r.Method == http.MethodPost && r.URL.Path == collection:
+		if _, found := s.endpoints[endpoint.ID]; found {
+			w.WriteHeader(http.StatusConflict)
+			return
+		}
+		s.endpoints[endpoint.ID] = body
+		w.WriteHeader(http.StatusCreated)
+	case r.Method == http.MethodPut && strings.HasPrefix(r.URL.Path, collection+"/"):
+		s.endpoints[strings.TrimPrefix(r.URL.Path, collection+"/")] = body
+		w.WriteHeader(http.StatusOK)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+// endpointResult returns a result with a valid Redfish endpoint as payload.
+func endpointResult(host string, xname string) CollectResult {
+	return CollectResult{
+		Host:    host,
+		Xname:   xname,
+		Success: true,
+		Payload: []byte(`{"ID":"` + xname + `","FQDN":"` + host + `","User":"root"}`),
+	}
+}
+
+func TestSmdSinkOutbox(t *testing.T) {
+	server := newFakeSMD(t)
+	server.fail(http.StatusServiceUnavailable)
+	outbox := filepath.Join(t.TempDir(), "outbox")
+	q := &QueryParams{OutboxPath: outbox, AccessToken: "secret"}
+	sink := &smdSink{q: q, l: testLogger(), client: server.client()}
+
+	// what could not be sent is queued without the access token
+	err := sink.Write(endpointResult("10.0.0.1", "x1000c1s7b0"))
+	if err == nil {
+		t.Fatalf("expected an error while SMD is unavailable")
+	}
+	b, err := os.ReadFile(filepath.Join(outbox, "x1000c1s7b0.json"))
+	if err != nil {
+		t.Fatalf("expected the endpoint to be queued: %v", err)
+	}
+	var entry OutboxEntry
+	err = json.Unmarshal(b, &entry)
+	if err != nil {
+		t.Fatalf("failed to unmarshal outbox entry: %v", err)
+	}
+	if entry.ID != "x1000c1s7b0" || entry.Headers["Authorization"] != "" {
+		t.Errorf("unexpected outbox entry: %+v", entry)
+	}
+
+	// entries failing again are kept with their attempts counted
+	sent, err := FlushOutbox(outbox, server.client(), nil)
+	if err == nil || sent != 0 {
+		t.Fatalf("expected nothing to be sent while SMD is unavailable, sent %d", sent)
+	}
+	b, _ = os.ReadFile(filepath.Join(outbox, "x1000c1s7b0.json"))
+	json.Unmarshal(b, &entry)
+	if entry.Attempts != 1 {
+		t.Errorf("expected 1 failed attempt, got %d", entry.Attempts)
+	}
+
+	// and sent once SMD is back
+	server.fail(0)
+	sent, err = FlushOutbox(outbox, server.client(), map[string]string{"Authorization": "Bearer new"})
+	if err != nil || sent != 1 {
+		t.Fatalf("expected 1 entry sent, sent %d: %v", sent, err)
+	}
+	if server.endpoint("x1000c1s7b0") == nil {
+		t.Errorf("expected the endpoint to be added to SMD")
+	}
+	files, _ := filepath.Glob(filepath.Join(outbox, "*.json"))
+	if len(files) != 0 {
+		t.Errorf("expected the outbox to be empty, got %v", files)
+	}
+}
+
+func TestSmdSinkHeaders(t *testing.T) {
+	server := newFakeSMD(t)
+	q := &QueryParams{
+		AccessToken: "secret",
+		SmdHeaders:  map[string]string{"X-Tenant": "blue", "Content-Type": "application/merge-patch+json"},
+	}
+	sink := &smdSink{q: q, l: testLogger(), client: server.client()}
+
+	err := sink.Write(endpointResult("10.0.0.1", "x1000c1s7b0"))
+	if err != nil {
+		t.Fatalf("failed to write: %v", err)
+	}
+	headers := server.lastHeaders()
+	if headers.Get("X-Tenant") != "blue" {
+		t.Errorf("expected the custom header, got %v", headers)
+	}
+	if headers.Get("Authorization") != "Bearer secret" {
+		t.Errorf("expected the access token, got %v", headers)
+	}
+	if headers.Get("Content-Type") != "application/merge-patch+json" {
+		t.Errorf("expected the custom header to replace the default, got %v", headers)
+	}
+
+	// the same headers are used when updating an endpoint already added
+	err = sink.Write(endpointResult("10.0.0.1", "x1000c1s7b0"))
+	if err != nil {
+		t.Fatalf("failed to update: %v", err)
+	}
+	if headers := server.lastHeaders(); headers.Get("X-Tenant") != "blue" {
+		t.Errorf("expected the custom header when updating, got %v", headers)
+	}
+}
+
+func TestSmdSinkConflict(t *testing.T) {
+	server := newFakeSMD(t)
+	server.endpoints["x1000c1s7b0"] = json.RawMessage(`{"ID":"x1000c1s7b0","FQDN":"10.0.0.9"}`)
+	sink := &smdSink{q: &QueryParams{}, l: testLogger(), client: server.client()}
+
+	// adding an endpoint that exists (409) updates it instead (200)
+	result := endpointResult("10.0.0.1", "x1000c1s7b0")
+	err := sink.Write(result)
+	if err != nil {
+		t.Fatalf("failed to write: %v", err)
+	}
+	if !reflect.DeepEqual(server.methods, []string{http.MethodPost, http.MethodPut}) {
+		t.Errorf("expected an add then an update, got %v", server.methods)
+	}
+	if string(server.endpoint("x1000c1s7b0")) != string(result.Payload) {
+		t.Errorf("expected the endpoint to be updated, got %s", server.endpoint("x1000c1s7b0"))
+	}
+}
+
+func TestSmdSinkDryRun(t *testing.T) {
+	f := newRedfishFixture(t)
+	server := newFakeSMD(t)
+	q := f.params(t)
+	q.DryRun = true
+	q.SmdEndpoint = server.URL
+	q.OutboxPath = filepath.Join(t.TempDir(), "outbox")
+	states := []ScannedResult{{Host: q.Host, Port: q.Port, Protocol: "http", State: true}}
+
+	results, err := CollectAll(context.Background(), &states, testLogger(), q)
+	if err != nil {
+		t.Fatalf("failed to collect: %v", err)
+	}
+	if len(results) != 1 || !results[0].Success {
+		t.Fatalf("expected the host to be collected, got %+v", results)
+	}
+	if len(server.headers) != 0 {
+		t.Errorf("expected no requests to SMD during a dry run, got %d", len(server.headers))
+	}
+}
+
+func TestFileSinkLeavesOutPassword(t *testing.T) {
+	f := newRedfishFixture(t)
+	server := newFakeSMD(t)
+	q := f.params(t)
+	q.DryRun = false
+	q.SmdEndpoint = server.URL
+	states := []ScannedResult{{Host: q.Host, Port: q.Port, Protocol: "http", State: true}}
+
+	_, err := CollectAll(context.Background(), &states, testLogger(), q)
+	if err != nil {
+		t.Fatalf("failed to collect: %v", err)
+	}
+	files := outputFiles(t, q)
+	if len(files) != 1 {
+		t.Fatalf("expected 1 output file, got %v", files)
+	}
+	b, err := os.ReadFile(files[0])
+	if err != nil {
+		t.Fatalf("failed to read output: %v", err)
+	}
+	if strings.Contains(string(b), q.Pass) || strings.Contains(string(b), "Password") {
+		t.Errorf("expected the password to be left out of the output file:\n%s", b)
+	}
+
+	// SMD still gets it to reach the BMC
+	var endpoint struct{ Password string }
+	json.Unmarshal(server.endpoint("x1000c1s7b0"), &endpoint)
+	if endpoint.Password != q.Pass {
+		t.Errorf("expected SMD to get the password, got %q", endpoint.Password)
+	}
+}
+
+func TestFileSinkModes(t *testing.T) {
+	f := newRedfishFixture(t)
+	q := f.params(t)
+	states := []ScannedResult{{Host: q.Host, Port: q.Port, Protocol: "http", State: true}}
+
+	_, err := CollectAll(context.Background(), &states, testLogger(), q)
+	if err != nil {
+		t.Fatalf("failed to collect: %v", err)
+	}
+	files := outputFiles(t, q)
+	if len(files) != 1 {
+		t.Fatalf("expected 1 output file, got %v", files)
+	}
+
+	// only the user running magellan can read what was collected by default
+	info, err := os.Stat(files[0])
+	if err != nil {
+		t.Fatalf("failed to stat output file: %v", err)
+	}
+	if mode := info.Mode().Perm(); mode != 0o600 {
+		t.Errorf("expected the file mode to be 0600, got %#o", mode)
+	}
+	info, err = os.Stat(filepath.Dir(files[0]))
+	if err != nil {
+		t.Fatalf("failed to stat output directory: %v", err)
+	}
+	if mode := info.Mode().Perm(); mode != 0o700 {
+		t.Errorf("expected the directory mode to be 0700, got %#o", mode)
+	}
+}
+
+func TestFileSinkPorts(t *testing.T) {
+	dir := t.TempDir()
+	sink := &fileSink{q: &QueryParams{}, dir: dir}
+
+	// BMCs forwarded through the same host do not overwrite each other
+	for _, port := range []int{HTTPS_PORT, 8443} {
+		result := endpointResult("10.0.0.1", "x1000c1s7b0")
+		result.Port = port
+		err := sink.Write(result)
+		if err != nil {
+			t.Fatalf("failed to write port %d: %v", port, err)
+		}
+	}
+	files, _ := filepath.Glob(filepath.Join(dir, "*.json"))
+	expected := []string{filepath.Join(dir, "10.0.0.1.json"), filepath.Join(dir, "10.0.0.1_8443.json")}
+	if !reflect.DeepEqual(files, expected) {
+		t.Errorf("expected %v, got %v", expected, files)
+	}
+}
+
+func TestLogSink(t *testing.T) {
+	f := newRedfishFixture(t)
+	q := f.params(t)
+	q.Verbose = true
+	states := []ScannedResult{{Host: q.Host, Port: q.Port, Protocol: "http", State: true}}
+
+	// anything printed instead of logged would end up in the pipe
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatalf("failed to make pipe: %v", err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+
+	var buf bytes.Buffer
+	_, err = CollectAll(context.Background(), &states, captureLogger(&buf), q)
+	os.Stdout = stdout
+	w.Close()
+	if err != nil {
+		t.Fatalf("failed to collect: %v", err)
+	}
+	printed, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("failed to read stdout: %v", err)
+	}
+	if len(printed) != 0 {
+		t.Errorf("expected nothing to be printed, got %q", printed)
+	}
+
+	// the payload is dumped at the debug level with the rest of the log
+	logged := buf.String()
+	if !strings.Contains(logged, "level=debug msg=\"collected data ("+q.Host) || !strings.Contains(logged, "38947555-7742-3448-3784-823347823834") {
+		t.Errorf("expected the payload to be logged at the debug level, got %q", logged)
+	}
+	if strings.Contains(logged, q.Pass) {
+		t.Errorf("expected the password to be left out of the log")
+	}
+}
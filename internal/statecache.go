@@ -0,0 +1,130 @@
+package magellan
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"sync"
+
+	"github.com/OpenCHAMI/magellan/internal/util"
+)
+
+// StateCache remembers a hash of the payload last collected from each host
+// so hosts that have not changed since the previous run can be skipped. The
+// cache is persisted as a JSON file and is safe to share between goroutines.
+type StateCache struct {
+	Path   string            `json:"-"`
+	Hashes map[string]string `json:"hashes"`
+
+	mu sync.Mutex
+}
+
+// LoadStateCache reads the cache from path. A missing file is not an error
+// and returns an empty cache.
+func LoadStateCache(path string) (*StateCache, error) {
+	cache := &StateCache{
+		Path:   path,
+		Hashes: map[string]string{},
+	}
+
+	exists, err := util.PathExists(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check for state cache: %v", err)
+	}
+	if !exists {
+		return cache, nil
+	}
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read state cache: %v", err)
+	}
+	err = json.Unmarshal(b, cache)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal state cache: %v", err)
+	}
+	if cache.Hashes == nil {
+		cache.Hashes = map[string]string{}
+	}
+	return cache, nil
+}
+
+// sections of the payload that change on every run
+var volatileSections = []string{"ClockSkewSeconds", "Sensors", "SEL", "Telemetry"}
+
+// fields of each manager that change on every run
+var volatileManagerFields = []string{"DateTime", "LastResetTime"}
+
+// Changed records the hash of the payload of the BMC at key (host:port) and
+// returns true if it is different from the one recorded before (or the BMC
+// is new).
+func (s *StateCache) Changed(key string, payload []byte) bool {
+	sum := sha256.Sum256(stablePayload(payload))
+	hash := hex.EncodeToString(sum[:])
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	changed := s.Hashes[key] != hash
+	s.Hashes[key] = hash
+	return changed
+}
+
+// Forget removes the BMC at key so it is collected in full on the next run
+// (i.e. after failing to submit its data).
+func (s *StateCache) Forget(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.Hashes, key)
+}
+
+// stablePayload returns the payload without the volatile sections and fields
+// compacted so it can be compared between runs. The payload is returned as
+// is when it is not a JSON object.
+func stablePayload(payload []byte) []byte {
+	var data map[string]any
+	err := json.Unmarshal(payload, &data)
+	if err != nil {
+		return payload
+	}
+	for _, key := range volatileSections {
+		delete(data, key)
+	}
+	if managers, ok := data["Managers"].([]any); ok {
+		for _, manager := range managers {
+			if manager, ok := manager.(map[string]any); ok {
+				for _, key := range volatileManagerFields {
+					delete(manager, key)
+				}
+			}
+		}
+	}
+
+	// map keys are sorted so the same data always gives the same bytes
+	b, err := json.Marshal(data)
+	if err != nil {
+		return payload
+	}
+	return b
+}
+
+// Save writes the cache back to its path.
+func (s *StateCache) Save() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b, err := json.MarshalIndent(s, "", "    ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal state cache: %v", err)
+	}
+	err = os.MkdirAll(path.Dir(s.Path), 0700)
+	if err != nil {
+		return fmt.Errorf("failed to make state cache directory: %v", err)
+	}
+	err = os.WriteFile(s.Path, b, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to write state cache: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,61 @@
+package magellan
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+)
+
+func TestCollectAllSkipUnchanged(t *testing.T) {
+	f := newRedfishFixture(t)
+	server := newFakeSMD(t)
+	q := f.params(t)
+	q.DryRun = false
+	q.SmdEndpoint = server.URL
+	q.CollectManagers = true
+	q.SkipUnchanged = true
+	q.StateCachePath = filepath.Join(t.TempDir(), "state.json")
+
+	collect := func(dateTime string) int {
+		t.Helper()
+		f.merge("/redfish/v1/Managers/BMC", map[string]any{"DateTime": dateTime})
+		states := []ScannedResult{{Host: q.Host, Port: q.Port, Protocol: "http", State: true}}
+		before := len(server.headers)
+		_, err := CollectAll(context.Background(), &states, testLogger(), q)
+		if err != nil {
+			t.Fatalf("failed to collect: %v", err)
+		}
+		return len(server.headers) - before
+	}
+
+	if sent := collect("2024-01-01T00:00:00Z"); sent == 0 {
+		t.Fatalf("expected the first run to add the BMC to SMD")
+	}
+
+	// only the clock of the BMC moved on so nothing is sent again
+	if sent := collect("2024-01-01T00:05:00Z"); sent != 0 {
+		t.Errorf("expected an unchanged BMC to be skipped, got %d request(s) to SMD", sent)
+	}
+
+	// a real change is sent
+	f.merge("/redfish/v1/Systems/1", map[string]any{"Name": "Node 1 (renamed)"})
+	if sent := collect("2024-01-01T00:10:00Z"); sent == 0 {
+		t.Errorf("expected a changed BMC to be sent to SMD")
+	}
+}
+
+func TestStateCacheKeys(t *testing.T) {
+	cache := &StateCache{Hashes: map[string]string{}}
+	payload := []byte(`{"ID":"x1000c1s7b0"}`)
+	if !cache.Changed("10.0.0.1:443", payload) {
+		t.Errorf("expected a new BMC to be changed")
+	}
+
+	// the same host on another port is a different BMC
+	if !cache.Changed("10.0.0.1:8443", payload) {
+		t.Errorf("expected the host on another port to be changed")
+	}
+	if cache.Changed("10.0.0.1:443", []byte(`{"ID":"x1000c1s7b0","Sensors":[{"Reading":42}]}`)) {
+		t.Errorf("expected a payload only differing in readings to be unchanged")
+	}
+}
@@ -0,0 +1,169 @@
+package magellan
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/OpenCHAMI/magellan/internal/api/smd"
+	"github.com/OpenCHAMI/magellan/internal/log"
+	"github.com/stmcginnis/gofish/redfish"
+)
+
+// SyncPowerStates is a lightweight alternative to CollectAll that only reads
+// the power state of each system and patches it into the components that
+// SMD already knows about. Hosts that are not registered in SMD are skipped
+// and hosts that cannot be reached are reported with an "Unknown" state.
+//
+// Node xnames are derived from the BMC xname registered in SMD by appending
+// the system's index (i.e. x1000c1s7b0 -> x1000c1s7b0n0). Systems that are
+// powering on or off are left as they are in SMD until they settle.
+//
+// The BMCs are logged in to with the same credentials and rate limit as
+// CollectAll.
+func SyncPowerStates(ctx context.Context, probeStates *[]ScannedResult, l *log.Logger, q *QueryParams) error {
+	if probeStates == nil || len(*probeStates) <= 0 {
+		return fmt.Errorf("no probe states found")
+	}
+
+	err := q.Validate()
+	if err != nil {
+		return err
+	}
+
+	headers, err := q.smdHeaders()
+	if err != nil {
+		return err
+	}
+
+	// only update nodes that SMD already knows about
+	client := smd.NewClient(
+		smd.WithSecureTLS(q.CaCertPath),
+		smd.WithBaseUrl(q.SmdEndpoint),
+	)
+	ids, err := client.GetRedfishEndpointIDs(headers)
+	if err != nil {
+		return fmt.Errorf("failed to get known endpoints from SMD: %v", err)
+	}
+
+	// share the rate limit between workers (and other collections if set)
+	limiter := q.limiter
+	if limiter == nil {
+		limiter = newLimiter(q.RateLimit)
+	}
+
+	concurrency := q.Concurrency
+	if concurrency <= 0 {
+		concurrency = 1
+	}
+	var (
+		wg             sync.WaitGroup
+		chanProbeState = make(chan ScannedResult, concurrency+1)
+	)
+	wg.Add(concurrency)
+	for i := 0; i < concurrency; i++ {
+		go func() {
+			defer wg.Done()
+			for ps := range chanProbeState {
+				id, ok := ids[ps.Host]
+				if !ok {
+					l.Log.Debugf("skipping host '%s' not found in SMD", ps.Host)
+					continue
+				}
+
+				// copy params so each worker has its own host, port, and credentials
+				params := *q
+				params.Host = ps.Host
+				params.Port = ps.Port
+				params.limiter = limiter
+				user, pass, err := q.credentials(ps.Host)
+				if err != nil {
+					l.Log.Errorf("failed to get credentials (%v:%v): %v", ps.Host, ps.Port, err)
+					continue
+				}
+				params.User, params.Pass = user, pass
+
+				states := []string{"Unknown"}
+				if ps.State {
+					powerStates, err := collectSystemPowerStates(ctx, l, &params)
+					if err != nil {
+						l.Log.Errorf("failed to get power state (%v:%v): %v", ps.Host, ps.Port, err)
+					} else {
+						states = powerStates
+					}
+				}
+
+				for i, state := range states {
+					xname := fmt.Sprintf("%sn%d", id, i)
+					if state == "" {
+						l.Log.Debugf("skipping %s while it is powering on or off", xname)
+						continue
+					}
+					body, err := json.Marshal(map[string]any{"State": state})
+					if err != nil {
+						l.Log.Errorf("failed to marshal state: %v", err)
+						continue
+					}
+					err = client.PatchComponentState(xname, body, headers)
+					if err != nil {
+						l.Log.Errorf("failed to update state for %s: %v", xname, err)
+						continue
+					}
+					l.Log.Infof("updated state for %s to %s", xname, state)
+				}
+			}
+		}()
+	}
+
+	for _, ps := range *probeStates {
+		chanProbeState <- ps
+	}
+	close(chanProbeState)
+	wg.Wait()
+
+	return nil
+}
+
+// collectSystemPowerStates returns the normalized SMD state of each system
+// managed by the BMC ordered by their URIs. The state of a system
+// that is powering on or off is empty since it is neither "On" nor "Off" yet.
+func collectSystemPowerStates(ctx context.Context, l *log.Logger, q *QueryParams) ([]string, error) {
+	session := NewSession(l, q)
+	defer session.Close(ctx)
+	c, err := session.Redfish(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	systems, err := c.Service.Systems()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get systems: %v", err)
+	}
+
+	// gofish gets the members concurrently so sort them to keep the index of
+	// each system (and the node xname derived from it) the same between runs
+	sort.Slice(systems, func(i, j int) bool {
+		a, b := systems[i].ODataID, systems[j].ODataID
+		if len(a) != len(b) {
+			return len(a) < len(b)
+		}
+		return a < b
+	})
+
+	states := make([]string, 0, len(systems))
+	for _, system := range systems {
+		switch system.PowerState {
+		case redfish.OnPowerState:
+			states = append(states, "On")
+		case redfish.OffPowerState:
+			states = append(states, "Off")
+		case redfish.PoweringOnPowerState, redfish.PoweringOffPowerState:
+			states = append(states, "")
+		default:
+			states = append(states, "Unknown")
+		}
+	}
+	return states, nil
+}
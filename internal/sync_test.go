@@ -0,0 +1,64 @@
+package magellan
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+func TestSyncPowerStates(t *testing.T) {
+	f := newRedfishFixture(t)
+	f.set("/redfish/v1/Systems", collection("/redfish/v1/Systems",
+		"/redfish/v1/Systems/1", "/redfish/v1/Systems/2", "/redfish/v1/Systems/3"))
+	f.set("/redfish/v1/Systems/2", map[string]any{
+		"@odata.id":  "/redfish/v1/Systems/2",
+		"Id":         "2",
+		"PowerState": "PoweringOff",
+	})
+	f.set("/redfish/v1/Systems/3", map[string]any{
+		"@odata.id":  "/redfish/v1/Systems/3",
+		"Id":         "3",
+		"PowerState": "Off",
+	})
+	var users []string
+	f.handle("/redfish/v1/SessionService/Sessions", func(w http.ResponseWriter, r *http.Request) {
+		var login struct{ UserName string }
+		json.NewDecoder(r.Body).Decode(&login)
+		f.mu.Lock()
+		users = append(users, login.UserName)
+		f.mu.Unlock()
+		f.serve(w, r)
+	})
+
+	// only the first host is known to SMD
+	states := fleet(f, 2)
+	server := newFakeSMD(t)
+	server.endpoints["x1000c1s7b0"] = json.RawMessage(`{"ID":"x1000c1s7b0","FQDN":"` + states[0].Host + `"}`)
+
+	q := f.params(t)
+	q.Transport = f.transport()
+	q.SmdEndpoint = server.URL
+	q.Concurrency = -2
+	q.Credentials = CredentialMap{states[0].Host: {User: "admin", Pass: "admin"}}
+
+	err := SyncPowerStates(context.Background(), &states, testLogger(), q)
+	if err != nil {
+		t.Fatalf("failed to sync power states: %v", err)
+	}
+
+	// systems powering on or off are left alone until they settle
+	expected := map[string]string{
+		"x1000c1s7b0n0": "On",
+		"x1000c1s7b0n1": "",
+		"x1000c1s7b0n2": "Off",
+	}
+	for xname, state := range expected {
+		if got := server.state(xname); got != state {
+			t.Errorf("expected state of %s to be '%s', got '%s'", xname, state, got)
+		}
+	}
+	if len(users) != 1 || users[0] != "admin" {
+		t.Errorf("expected one login to the known host as 'admin', got %v", users)
+	}
+}
@@ -0,0 +1,230 @@
+package magellan
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/OpenCHAMI/magellan/internal/log"
+)
+
+// ScanTarget describes a group of hosts that share the same credentials,
+// ports, and SMD instance. Multiple targets can be defined in the config
+// file under "targets" to collect from all of them in a single run.
+type ScanTarget struct {
+	Name    string   `mapstructure:"name"`
+	Hosts   []string `mapstructure:"hosts"`
+	Subnets []string `mapstructure:"subnets"`
+	Ports   []int    `mapstructure:"ports"`
+	User    string   `mapstructure:"user"`
+	Pass    string   `mapstructure:"pass"`
+	Smd     string   `mapstructure:"smd"`
+	Output  string   `mapstructure:"output"`
+}
+
+// CollectTargets scans and collects from every target at the same time. The
+// targets share a single pool of q.Concurrency slots (for scanning and
+// collecting) so the total number of BMCs queried at once stays the same as
+// a single CollectAll run. Settings not set on a target are taken from q.
+//
+// The targets also share the xname generator so every BMC gets a different
+// xname, the cooldown store and state cache, and the ndjson output file when
+// they use the same one. The reports (i.e. SummaryPath or JUnitPath) are
+// written once for the hosts of every target. Each target with its own SMD
+// queues what could not be sent in a directory of OutboxPath named after it.
+func CollectTargets(ctx context.Context, targets []ScanTarget, l *log.Logger, q *QueryParams) error {
+	if len(targets) <= 0 {
+		return fmt.Errorf("no targets found")
+	}
+	names := make(map[string]bool, len(targets))
+	for _, target := range targets {
+		if target.Name == "" {
+			return fmt.Errorf("every target needs a name")
+		}
+		if names[target.Name] {
+			return fmt.Errorf("target '%s' is defined more than once", target.Name)
+		}
+		names[target.Name] = true
+	}
+	err := q.Validate()
+	if err != nil {
+		return err
+	}
+	start := time.Now()
+
+	concurrency := q.Concurrency
+	if concurrency <= 0 {
+		concurrency = 1
+	}
+
+	// share the rate limit, metrics, and xnames between every target
+	params := *q
+	if params.limiter == nil {
+		params.limiter = newLimiter(q.RateLimit)
+	}
+	if params.XnameGenerator == nil {
+		params.XnameGenerator, _ = NewXnameGenerator("", q.XnameMap, l)
+	}
+	q = &params
+
+	// load the stores once so every target reads and updates the same ones
+	shared := &sharedRun{}
+	defer shared.close()
+	if q.CooldownPath != "" {
+		shared.cooldown, err = LoadCooldownStore(q.CooldownPath)
+		if err != nil {
+			l.Log.Warnf("failed to load cooldown store: %v", err)
+		}
+	}
+	if q.SkipUnchanged {
+		shared.stateCache, err = LoadStateCache(q.StateCachePath)
+		if err != nil {
+			return err
+		}
+	}
+	q.shared = shared
+
+	// serve the metrics of every target on the same listener
+	if q.metrics == nil && q.MetricsAddr != "" {
+		q.metrics = NewMetrics()
+		metricsCtx, metricsCancel := context.WithCancel(ctx)
+		defer metricsCancel()
+		err := ServeMetrics(metricsCtx, q.MetricsAddr, q.metrics)
+		if err != nil {
+			return err
+		}
+	}
+
+	var (
+		wg      sync.WaitGroup
+		mu      sync.Mutex
+		errList []error
+		sem     = make(chan struct{}, concurrency)
+	)
+	for i := range targets {
+		target := targets[i]
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := collectTarget(ctx, target, l, q, sem)
+			if err != nil {
+				mu.Lock()
+				errList = append(errList, fmt.Errorf("target '%s': %v", target.Name, err))
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	// save the stores and write the reports of every target at once
+	err = finishCollection(l, q, shared.cooldown, shared.stateCache, shared.results, shared.records, time.Since(start))
+	if err != nil {
+		errList = append(errList, err)
+	}
+
+	if len(errList) > 0 {
+		return fmt.Errorf("failed to collect %d target(s): \n%v", len(errList), errList)
+	}
+	return nil
+}
+
+func collectTarget(ctx context.Context, target ScanTarget, l *log.Logger, q *QueryParams, sem chan struct{}) error {
+	hosts := append([]string{}, target.Hosts...)
+	for _, subnet := range target.Subnets {
+		// use a default mask for class C networks if not using CIDR notation
+		mask := net.IP{255, 255, 255, 0}
+		hosts = append(hosts, GenerateHosts(subnet, &mask)...)
+	}
+	if len(hosts) <= 0 {
+		return fmt.Errorf("no hosts or subnets found")
+	}
+
+	ports := target.Ports
+	if len(ports) <= 0 {
+		ports = GetDefaultPorts()
+	}
+
+	probeStates := scanForAssets(ctx, hosts, ports, cap(sem), q.Timeout, false, q.Verbose, sem)
+	if ctx.Err() != nil {
+		return fmt.Errorf("scan cancelled: %v", ctx.Err())
+	}
+	if len(probeStates) <= 0 {
+		l.Log.Infof("no BMCs found for target '%s'", target.Name)
+		return nil
+	}
+
+	// copy params so each target can override its own settings
+	params := *q
+	params.sem = sem
+	params.Concurrency = cap(sem)
+	if target.User != "" {
+		params.User = target.User
+		params.Pass = target.Pass
+	}
+	if target.Smd != "" {
+		params.SmdEndpoint = target.Smd
+		if q.OutboxPath != "" {
+			params.OutboxPath = filepath.Join(q.OutboxPath, target.Name)
+		}
+	}
+	if target.Output != "" {
+		params.OutputPath = target.Output
+	}
+	_, err := CollectAll(ctx, &probeStates, l, &params)
+	return err
+}
+
+// sharedRun is what the collections of every target share so the stores are
+// saved and the reports are written once they are all done.
+type sharedRun struct {
+	cooldown   *CooldownStore
+	stateCache *StateCache
+
+	mu      sync.Mutex
+	files   map[string]*os.File
+	writers map[string]*lineWriter // ndjson output by path
+	results []CollectResult
+	records []map[string]any
+}
+
+// lineWriter returns the writer appending to the ndjson output file at path
+// which is opened the first time it is needed.
+func (r *sharedRun) lineWriter(path string, mode os.FileMode) (*lineWriter, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if writer, ok := r.writers[path]; ok {
+		return writer, nil
+	}
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, mode)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open output file: %v", err)
+	}
+	if r.writers == nil {
+		r.files = map[string]*os.File{}
+		r.writers = map[string]*lineWriter{}
+	}
+	r.files[path] = file
+	r.writers[path] = &lineWriter{w: file}
+	return r.writers[path], nil
+}
+
+// add keeps the results and records of a target to be reported at the end.
+func (r *sharedRun) add(results []CollectResult, records []map[string]any) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.results = append(r.results, results...)
+	r.records = append(r.records, records...)
+}
+
+// close closes the output files opened.
+func (r *sharedRun) close() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, file := range r.files {
+		file.Close()
+	}
+}
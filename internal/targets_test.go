@@ -0,0 +1,99 @@
+package magellan
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCollectTargets(t *testing.T) {
+	// listen on every loopback address so each target has its own host
+	f := makeRedfishFixture()
+	f.Server = httptest.NewUnstartedServer(f)
+	listener, err := net.Listen("tcp", "0.0.0.0:0")
+	if err != nil {
+		t.Skipf("failed to listen on every address: %v", err)
+	}
+	f.Listener.Close()
+	f.Listener = listener
+	f.StartTLS()
+	t.Cleanup(f.Close)
+	_, port := f.hostPort()
+
+	dir := t.TempDir()
+	q := f.params(t)
+	q.Host = ""
+	q.Concurrency = 2
+	q.OutputFormat = OUTPUT_NDJSON
+	q.OutputPath = filepath.Join(dir, "inventory.ndjson")
+	q.SummaryPath = filepath.Join(dir, "summary.json")
+	q.CooldownPath = filepath.Join(dir, "cooldown.json")
+	targets := []ScanTarget{
+		{Name: "rack1", Hosts: []string{"127.0.0.1"}, Ports: []int{port}},
+		{Name: "rack2", Hosts: []string{"127.0.0.2"}, Ports: []int{port}},
+	}
+
+	err = CollectTargets(context.Background(), targets, testLogger(), q)
+	if err != nil {
+		t.Fatalf("failed to collect targets: %v", err)
+	}
+
+	// both targets append to the same file with different xnames
+	file, err := os.Open(q.OutputPath)
+	if err != nil {
+		t.Fatalf("failed to open output: %v", err)
+	}
+	defer file.Close()
+	ids := map[string]bool{}
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(nil, 1<<20)
+	for scanner.Scan() {
+		var record struct{ ID string }
+		err = json.Unmarshal(scanner.Bytes(), &record)
+		if err != nil {
+			t.Fatalf("failed to unmarshal line: %v", err)
+		}
+		ids[record.ID] = true
+	}
+	if len(ids) != 2 || !ids["x1000c1s7b0"] || !ids["x1000c1s7b1"] {
+		t.Errorf("expected one line per target with different xnames, got %v", ids)
+	}
+
+	// the summary covers every target
+	b, err := os.ReadFile(q.SummaryPath)
+	if err != nil {
+		t.Fatalf("failed to read summary: %v", err)
+	}
+	var summary Summary
+	err = json.Unmarshal(b, &summary)
+	if err != nil {
+		t.Fatalf("failed to unmarshal summary: %v", err)
+	}
+	if summary.Attempted != 2 || summary.Succeeded != 2 {
+		t.Errorf("expected 2 hosts attempted and succeeded, got %+v", summary)
+	}
+	if _, err := os.Stat(q.CooldownPath); err != nil {
+		t.Errorf("expected the cooldown store to be saved: %v", err)
+	}
+}
+
+func TestCollectTargetsNames(t *testing.T) {
+	q := &QueryParams{Timeout: 5, Drivers: []string{"redfish"}}
+	tests := map[string][]ScanTarget{
+		"empty":     {{Hosts: []string{"10.0.0.1"}}},
+		"duplicate": {{Name: "rack1", Hosts: []string{"10.0.0.1"}}, {Name: "rack1", Hosts: []string{"10.0.0.2"}}},
+	}
+	for name, targets := range tests {
+		t.Run(name, func(t *testing.T) {
+			err := CollectTargets(context.Background(), targets, testLogger(), q)
+			if err == nil {
+				t.Errorf("expected an error")
+			}
+		})
+	}
+}
@@ -0,0 +1,85 @@
+package magellan
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"sync"
+)
+
+const (
+	TRUST_SELF_SIGNED  = "self-signed"
+	TRUST_PROVIDED_CA  = "provided-ca"
+	TRUST_SYSTEM_ROOTS = "system-roots"
+	TRUST_UNTRUSTED    = "untrusted"
+	TRUST_UNKNOWN      = "unknown"
+)
+
+// certCapture records the certificate chain presented by a BMC during the
+// TLS handshake so it can be inspected without making another request.
+type certCapture struct {
+	mu    sync.Mutex
+	chain []*x509.Certificate
+}
+
+func (c *certCapture) verifyConnection(state tls.ConnectionState) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.chain) == 0 {
+		c.chain = state.PeerCertificates
+	}
+	return nil
+}
+
+func (c *certCapture) Chain() []*x509.Certificate {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.chain
+}
+
+// ClassifyCertTrust determines whether the leaf of the chain is self-signed,
+// chains to a CA in the provided pool, chains to the system roots, or is not
+// trusted at all. The provided pool may be nil.
+func ClassifyCertTrust(chain []*x509.Certificate, caPool *x509.CertPool) string {
+	if len(chain) == 0 {
+		return TRUST_UNKNOWN
+	}
+
+	leaf := chain[0]
+	if len(chain) == 1 && leaf.CheckSignatureFrom(leaf) == nil {
+		return TRUST_SELF_SIGNED
+	}
+
+	intermediates := x509.NewCertPool()
+	for _, cert := range chain[1:] {
+		intermediates.AddCert(cert)
+	}
+
+	if caPool != nil {
+		_, err := leaf.Verify(x509.VerifyOptions{Roots: caPool, Intermediates: intermediates})
+		if err == nil {
+			return TRUST_PROVIDED_CA
+		}
+	}
+
+	// verifying with nil roots uses the system cert pool
+	_, err := leaf.Verify(x509.VerifyOptions{Intermediates: intermediates})
+	if err == nil {
+		return TRUST_SYSTEM_ROOTS
+	}
+	return TRUST_UNTRUSTED
+}
+
+// loadCertPool reads PEM encoded certificates from path into a new pool.
+func loadCertPool(path string) (*x509.CertPool, error) {
+	cacert, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CA cert: %v", err)
+	}
+	certPool := x509.NewCertPool()
+	if !certPool.AppendCertsFromPEM(cacert) {
+		return nil, fmt.Errorf("no certificates found in '%s'", path)
+	}
+	return certPool, nil
+}
@@ -0,0 +1,132 @@
+package magellan
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ClientCredentials gets access tokens using the OAuth2 client credentials
+// grant and caches them until shortly before they expire. It is safe to
+// share between goroutines.
+type ClientCredentials struct {
+	TokenUrl     string
+	ClientID     string
+	ClientSecret string
+	Scopes       []string
+	Client       *http.Client // uses a client with a timeout of 30s when nil
+
+	mu     sync.Mutex
+	token  string
+	expiry time.Time
+}
+
+// refresh tokens this long before they expire so they do not run out mid-request
+const tokenExpiryMargin = 30 * time.Second
+
+// Token returns the cached access token or requests a new one if there is
+// none or it is about to expire.
+func (c *ClientCredentials) Token() (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.token != "" && (c.expiry.IsZero() || time.Until(c.expiry) > tokenExpiryMargin) {
+		return c.token, nil
+	}
+
+	form := url.Values{}
+	form.Set("grant_type", "client_credentials")
+	if len(c.Scopes) > 0 {
+		form.Set("scope", strings.Join(c.Scopes, " "))
+	}
+	req, err := http.NewRequest(http.MethodPost, c.TokenUrl, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", fmt.Errorf("failed to make token request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(url.QueryEscape(c.ClientID), url.QueryEscape(c.ClientSecret))
+
+	client := c.Client
+	if client == nil {
+		client = &http.Client{Timeout: 30 * time.Second}
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to request token: %v", err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("token endpoint returned status code %d", res.StatusCode)
+	}
+
+	var body struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int64  `json:"expires_in"`
+	}
+	err = json.NewDecoder(res.Body).Decode(&body)
+	if err != nil {
+		return "", fmt.Errorf("failed to decode token response: %v", err)
+	}
+	if body.AccessToken == "" {
+		return "", fmt.Errorf("token endpoint did not return an access token")
+	}
+
+	c.token = body.AccessToken
+	c.expiry = time.Time{}
+	if body.ExpiresIn > 0 {
+		c.expiry = time.Now().Add(time.Duration(body.ExpiresIn) * time.Second)
+	}
+	return c.token, nil
+}
+
+// Expire drops token from the cache so the next call to Token requests a new
+// one (i.e. after it was rejected). Nothing is done if it was already replaced.
+func (c *ClientCredentials) Expire(token string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.token == token {
+		c.token = ""
+	}
+}
+
+// token sources for SMD by token URL and client ID so tokens are reused by
+// every request made during (and between) collections
+var smdTokenSources sync.Map
+
+// smdTokenSource returns the token source for SMD from q.SmdTokenUrl. The
+// token endpoint is trusted with the same CA as SMD.
+func (q *QueryParams) smdTokenSource() (*ClientCredentials, error) {
+	key := q.SmdTokenUrl + "\x00" + q.SmdClientID
+	if source, ok := smdTokenSources.Load(key); ok {
+		return source.(*ClientCredentials), nil
+	}
+
+	transport := &http.Transport{Proxy: http.ProxyFromEnvironment}
+	if q.CaCertPath != "" {
+		pool, err := loadCertPool(q.CaCertPath)
+		if err != nil {
+			return nil, err
+		}
+		transport.TLSClientConfig = &tls.Config{RootCAs: pool}
+	}
+	source, _ := smdTokenSources.LoadOrStore(key, &ClientCredentials{
+		TokenUrl:     q.SmdTokenUrl,
+		ClientID:     q.SmdClientID,
+		ClientSecret: q.SmdClientSecret,
+		Client:       &http.Client{Transport: transport, Timeout: q.queryTimeout()},
+	})
+	return source.(*ClientCredentials), nil
+}
+
+// smdToken returns the access token for SMD from q.SmdTokenUrl.
+func (q *QueryParams) smdToken() (string, error) {
+	source, err := q.smdTokenSource()
+	if err != nil {
+		return "", err
+	}
+	return source.Token()
+}
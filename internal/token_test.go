@@ -0,0 +1,133 @@
+package magellan
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+// fakeTokenEndpoint issues numbered tokens to a single client which expire
+// after expiresIn seconds.
+type fakeTokenEndpoint struct {
+	*httptest.Server
+
+	mu        sync.Mutex
+	issued    int
+	expiresIn int
+}
+
+func newFakeTokenEndpoint(t *testing.T, expiresIn int) *fakeTokenEndpoint {
+	e := &fakeTokenEndpoint{expiresIn: expiresIn}
+	e.Server = httptest.NewServer(e)
+	t.Cleanup(e.Close)
+	return e
+}
+
+// tokens returns the number of tokens issued.
+func (e *fakeTokenEndpoint) tokens() int {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.issued
+}
+
+func (e *fakeTokenEndpoint) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	id, secret, ok := r.BasicAuth()
+	r.ParseForm()
+	if !ok || id != "magellan" || secret != "secret" || r.PostForm.Get("grant_type") != "client_credentials" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	e.mu.Lock()
+	e.issued++
+	body := map[string]any{
+		"access_token": fmt.Sprintf("token-%d", e.issued),
+		"token_type":   "Bearer",
+		"expires_in":   e.expiresIn,
+	}
+	e.mu.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(body)
+}
+
+func TestClientCredentials(t *testing.T) {
+	tests := []struct {
+		name      string
+		expiresIn int
+		tokens    int
+	}{
+		{name: "cached", expiresIn: 3600, tokens: 1},
+		{name: "about to expire", expiresIn: 1, tokens: 3},
+		{name: "without expiry", expiresIn: 0, tokens: 1},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			endpoint := newFakeTokenEndpoint(t, test.expiresIn)
+			source := &ClientCredentials{TokenUrl: endpoint.URL, ClientID: "magellan", ClientSecret: "secret"}
+			for i := 0; i < 3; i++ {
+				token, err := source.Token()
+				if err != nil {
+					t.Fatalf("failed to get token: %v", err)
+				}
+				if token == "" {
+					t.Fatalf("expected a token")
+				}
+			}
+			if endpoint.tokens() != test.tokens {
+				t.Errorf("expected %d tokens to be issued, got %d", test.tokens, endpoint.tokens())
+			}
+		})
+	}
+
+	// rejected client credentials are an error
+	endpoint := newFakeTokenEndpoint(t, 3600)
+	source := &ClientCredentials{TokenUrl: endpoint.URL, ClientID: "magellan", ClientSecret: "wrong"}
+	_, err := source.Token()
+	if err == nil {
+		t.Errorf("expected an error with the wrong client secret")
+	}
+}
+
+func TestSmdSinkToken(t *testing.T) {
+	endpoint := newFakeTokenEndpoint(t, 3600)
+	server := newFakeSMD(t)
+	server.accept("token-1")
+	q := &QueryParams{
+		SmdTokenUrl:     endpoint.URL,
+		SmdClientID:     "magellan",
+		SmdClientSecret: "secret",
+	}
+	sink := &smdSink{q: q, l: testLogger(), client: server.client()}
+
+	// the token is requested once and used for every request
+	for _, xname := range []string{"x1000c1s7b0", "x1000c1s7b1"} {
+		err := sink.Write(endpointResult("10.0.0.1", xname))
+		if err != nil {
+			t.Fatalf("failed to write %s: %v", xname, err)
+		}
+	}
+	if headers := server.lastHeaders(); headers.Get("Authorization") != "Bearer token-1" {
+		t.Errorf("expected the token in the Authorization header, got %v", headers)
+	}
+	if endpoint.tokens() != 1 {
+		t.Errorf("expected 1 token to be issued, got %d", endpoint.tokens())
+	}
+
+	// a token rejected by SMD is refreshed and the request sent again
+	server.accept("token-2")
+	err := sink.Write(endpointResult("10.0.0.1", "x1000c1s7b2"))
+	if err != nil {
+		t.Fatalf("failed to write with a refreshed token: %v", err)
+	}
+	if headers := server.lastHeaders(); headers.Get("Authorization") != "Bearer token-2" {
+		t.Errorf("expected the refreshed token in the Authorization header, got %v", headers)
+	}
+	if endpoint.tokens() != 2 {
+		t.Errorf("expected 2 tokens to be issued, got %d", endpoint.tokens())
+	}
+	if server.endpoint("x1000c1s7b2") == nil {
+		t.Errorf("expected the endpoint to be added to SMD")
+	}
+}
@@ -28,13 +28,13 @@ type UpdateParams struct {
 
 // NOTE: Does not work since OpenBMC, whic bmclib uses underneath, does not
 // support multipart updates. See issue: https://github.com/bmc-toolbox/bmclib/issues/341
-func UpdateFirmware(client *bmclib.Client, l *log.Logger, q *UpdateParams) error {
+func UpdateFirmware(ctx context.Context, client *bmclib.Client, l *log.Logger, q *UpdateParams) error {
 	if q.Component == "" {
 		return fmt.Errorf("component is required")
 	}
 
 	// open BMC session and update driver registry
-	ctx, ctxCancel := context.WithTimeout(context.Background(), time.Second*time.Duration(q.Timeout))
+	ctx, ctxCancel := context.WithTimeout(ctx, q.queryTimeout())
 	client.Registry.FilterForCompatible(ctx)
 	err := client.Open(ctx)
 	if err != nil {
@@ -120,8 +120,6 @@ func UpdateFirmware(client *bmclib.Client, l *log.Logger, q *UpdateParams) error
 
 		time.Sleep(2 * time.Second)
 	}
-
-	return nil
 }
 
 func UpdateFirmwareRemote(q *UpdateParams) error {
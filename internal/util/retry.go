@@ -0,0 +1,200 @@
+package util
+
+import (
+	"context"
+	"io"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Retry calls fn until it succeeds, fails with an error that is not
+// retryable, or has been called attempts times. The delay doubles after each
+// attempt with up to the same amount of random jitter added so that workers
+// hitting the same BMC do not retry in lockstep. Waiting is cut short with
+// the error of ctx once it is done.
+func Retry(ctx context.Context, attempts int, delay time.Duration, retryable func(error) bool, fn func() error) error {
+	var err error
+	if attempts < 1 {
+		attempts = 1
+	}
+	for attempt := 0; attempt < attempts; attempt++ {
+		if attempt > 0 {
+			wait := delay << (attempt - 1)
+			if wait > 0 {
+				wait += time.Duration(rand.Int63n(int64(wait)))
+			}
+			timer := time.NewTimer(wait)
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return ctx.Err()
+			case <-timer.C:
+			}
+		}
+		err = fn()
+		if err == nil || !retryable(err) {
+			return err
+		}
+	}
+	return err
+}
+
+// BusyRetryTransport wraps an http.RoundTripper and retries requests that the
+// BMC rejected with 429 (Too Many Requests) or 503 (Service Unavailable). The
+// "Retry-After" header is honored when present, otherwise the delay doubles
+// with each attempt. Connection-level errors are returned as-is and are not
+// retried here. MaxWait caps the time spent waiting across every request
+// made with the transport so share one per host to bound the host's total.
+type BusyRetryTransport struct {
+	Base       http.RoundTripper
+	MaxRetries int
+	MaxWait    time.Duration // total time allowed for retrying (0 means no cap)
+
+	mu     sync.Mutex
+	waited time.Duration
+}
+
+// reserve adds delay to the time waited and returns whether it is still
+// within MaxWait (nothing is added when it is not).
+func (t *BusyRetryTransport) reserve(delay time.Duration) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.MaxWait > 0 && t.waited+delay > t.MaxWait {
+		return false
+	}
+	t.waited += delay
+	return true
+}
+
+func (t *BusyRetryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+
+	for attempt := 0; ; attempt++ {
+		res, err := base.RoundTrip(req)
+		if err != nil || !isBusyStatus(res.StatusCode) || attempt >= t.MaxRetries {
+			return res, err
+		}
+
+		// requests with a body can only be retried if it can be rewound
+		if req.Body != nil && req.GetBody == nil {
+			return res, err
+		}
+
+		delay := retryAfter(res, time.Second<<attempt)
+		if !t.reserve(delay) {
+			return res, err
+		}
+
+		// drain the response so the connection can be reused
+		io.Copy(io.Discard, res.Body)
+		res.Body.Close()
+
+		select {
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		case <-time.After(delay):
+		}
+
+		if req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			req = req.Clone(req.Context())
+			req.Body = body
+		}
+	}
+}
+
+// ExpandFallbackTransport wraps an http.RoundTripper and retries requests
+// using $expand without it when the BMC rejects them with 400 (Bad Request),
+// which older firmware does even when the query is advertised as supported.
+type ExpandFallbackTransport struct {
+	Base       http.RoundTripper
+	OnFallback func(req *http.Request) // called before retrying without $expand
+}
+
+func (t *ExpandFallbackTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+
+	res, err := base.RoundTrip(req)
+	if err != nil || res.StatusCode != http.StatusBadRequest {
+		return res, err
+	}
+	query, found := withoutExpand(req.URL.RawQuery)
+	if !found || (req.Body != nil && req.GetBody == nil) {
+		return res, err
+	}
+
+	// drain the response so the connection can be reused
+	io.Copy(io.Discard, res.Body)
+	res.Body.Close()
+
+	if t.OnFallback != nil {
+		t.OnFallback(req)
+	}
+	retry := req.Clone(req.Context())
+	retry.URL.RawQuery = query
+	if req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			return nil, err
+		}
+		retry.Body = body
+	}
+	return base.RoundTrip(retry)
+}
+
+// withoutExpand removes the $expand parameter from a raw query string. The
+// other parameters are kept as-is instead of being re-encoded since some BMCs
+// do not accept an escaped "$".
+func withoutExpand(rawQuery string) (string, bool) {
+	var (
+		params = strings.Split(rawQuery, "&")
+		kept   = make([]string, 0, len(params))
+		found  = false
+	)
+	for _, param := range params {
+		name, _, _ := strings.Cut(param, "=")
+		if name == "$expand" || strings.EqualFold(name, "%24expand") {
+			found = true
+			continue
+		}
+		kept = append(kept, param)
+	}
+	return strings.Join(kept, "&"), found
+}
+
+func isBusyStatus(code int) bool {
+	return code == http.StatusTooManyRequests || code == http.StatusServiceUnavailable
+}
+
+// retryAfter returns the delay requested by the "Retry-After" header which
+// can be either a number of seconds or an HTTP date. The fallback is used
+// when the header is missing or malformed.
+func retryAfter(res *http.Response, fallback time.Duration) time.Duration {
+	value := res.Header.Get("Retry-After")
+	if value == "" {
+		return fallback
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if t, err := http.ParseTime(value); err == nil {
+		if d := time.Until(t); d > 0 {
+			return d
+		}
+		return 0
+	}
+	return fallback
+}
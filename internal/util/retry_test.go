@@ -0,0 +1,195 @@
+package util
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// busyServer responds with status and the "Retry-After" header to the first
+// busy requests and with 200 to the rest.
+type busyServer struct {
+	*httptest.Server
+
+	mu         sync.Mutex
+	status     int
+	retryAfter string
+	busy       int
+	requests   int
+}
+
+func newBusyServer(t *testing.T, status int, retryAfter string, busy int) *busyServer {
+	s := &busyServer{status: status, retryAfter: retryAfter, busy: busy}
+	s.Server = httptest.NewServer(s)
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *busyServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.requests++
+	if s.requests <= s.busy {
+		w.Header().Set("Retry-After", s.retryAfter)
+		w.WriteHeader(s.status)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+func (s *busyServer) count() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.requests
+}
+
+func TestBusyRetryTransport(t *testing.T) {
+	tests := []struct {
+		name     string
+		status   int
+		busy     int
+		retries  int
+		expected int
+		requests int
+	}{
+		{"too many requests", http.StatusTooManyRequests, 2, 3, http.StatusOK, 3},
+		{"service unavailable", http.StatusServiceUnavailable, 2, 3, http.StatusOK, 3},
+		{"out of retries", http.StatusServiceUnavailable, 3, 2, http.StatusServiceUnavailable, 3},
+		{"not busy", http.StatusInternalServerError, 1, 3, http.StatusInternalServerError, 1},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := newBusyServer(t, test.status, "0", test.busy)
+			client := &http.Client{Transport: &BusyRetryTransport{MaxRetries: test.retries}}
+			res, err := client.Get(server.URL)
+			if err != nil {
+				t.Fatalf("failed to make request: %v", err)
+			}
+			res.Body.Close()
+			if res.StatusCode != test.expected {
+				t.Errorf("expected status %d, got %d", test.expected, res.StatusCode)
+			}
+			if server.count() != test.requests {
+				t.Errorf("expected %d requests, got %d", test.requests, server.count())
+			}
+		})
+	}
+}
+
+func TestBusyRetryTransportMaxWait(t *testing.T) {
+	// every request is told to come back in a second but the transport may
+	// only wait for 1.5s in total
+	server := newBusyServer(t, http.StatusServiceUnavailable, "1", 3)
+	client := &http.Client{Transport: &BusyRetryTransport{MaxRetries: 5, MaxWait: 1500 * time.Millisecond}}
+
+	start := time.Now()
+	res, err := client.Get(server.URL)
+	if err != nil {
+		t.Fatalf("failed to make request: %v", err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("expected to give up while the server is busy, got %d", res.StatusCode)
+	}
+
+	// the wait is counted across requests so the next one is not retried
+	res, err = client.Get(server.URL)
+	if err != nil {
+		t.Fatalf("failed to make request: %v", err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("expected the next request to give up right away, got %d", res.StatusCode)
+	}
+	if elapsed := time.Since(start); elapsed < time.Second || elapsed > 1500*time.Millisecond {
+		t.Errorf("expected to wait for the retry once, took %v", elapsed)
+	}
+	if server.count() != 3 {
+		t.Errorf("expected 3 requests, got %d", server.count())
+	}
+}
+
+func TestExpandFallbackTransport(t *testing.T) {
+	var (
+		mu      sync.Mutex
+		queries []string
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		queries = append(queries, r.URL.RawQuery)
+		mu.Unlock()
+		if strings.Contains(r.URL.RawQuery, "$expand") {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	t.Cleanup(server.Close)
+
+	var fallbacks []string
+	client := &http.Client{Transport: &ExpandFallbackTransport{
+		OnFallback: func(req *http.Request) { fallbacks = append(fallbacks, req.URL.Path) },
+	}}
+	tests := []struct {
+		name     string
+		query    string
+		queries  []string
+		fallback bool
+	}{
+		{"expanded", "$expand=.($levels=1)&$top=5", []string{"$expand=.($levels=1)&$top=5", "$top=5"}, true},
+		{"not expanded", "$top=5", []string{"$top=5"}, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			queries, fallbacks = nil, nil
+			res, err := client.Get(server.URL + "/redfish/v1/Systems?" + test.query)
+			if err != nil {
+				t.Fatalf("failed to make request: %v", err)
+			}
+			res.Body.Close()
+
+			// the unexpanded form is served in place of the rejected request
+			if res.StatusCode != http.StatusOK {
+				t.Errorf("expected status %d, got %d", http.StatusOK, res.StatusCode)
+			}
+			if !reflect.DeepEqual(queries, test.queries) {
+				t.Errorf("expected queries %q, got %q", test.queries, queries)
+			}
+			if test.fallback && (len(fallbacks) != 1 || fallbacks[0] != "/redfish/v1/Systems") {
+				t.Errorf("expected the fallback to be reported once, got %v", fallbacks)
+			}
+			if !test.fallback && len(fallbacks) != 0 {
+				t.Errorf("expected no fallback, got %v", fallbacks)
+			}
+		})
+	}
+}
+
+func TestRetryCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	failure := errors.New("refused")
+	calls := 0
+	time.AfterFunc(100*time.Millisecond, cancel)
+
+	// the second attempt would only start after a minute
+	start := time.Now()
+	err := Retry(ctx, 3, time.Minute, func(error) bool { return true }, func() error {
+		calls++
+		return failure
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("expected the retry to be cancelled, got %v", err)
+	}
+	if calls != 1 {
+		t.Errorf("expected 1 attempt, got %d", calls)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("expected to stop waiting once cancelled, took %v", elapsed)
+	}
+}
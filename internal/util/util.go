@@ -9,6 +9,7 @@ import (
 	"net/http"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
 	"time"
 )
@@ -42,11 +43,13 @@ func GetNextIP(ip *net.IP, inc uint) *net.IP {
 
 // Generic convenience function used to make HTTP requests.
 func MakeRequest(client *http.Client, url string, httpMethod string, body []byte, headers map[string]string) (*http.Response, []byte, error) {
-	// use defaults if no client provided
+	// use defaults if no client provided (without changing http.DefaultClient
+	// which is shared by concurrent scans)
 	if client == nil {
-		client = http.DefaultClient
-		client.Transport = &http.Transport{
-			TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+		client = &http.Client{
+			Transport: &http.Transport{
+				TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+			},
 		}
 	}
 	req, err := http.NewRequest(httpMethod, url, bytes.NewBuffer(body))
@@ -69,27 +72,35 @@ func MakeRequest(client *http.Client, url string, httpMethod string, body []byte
 	return res, b, err
 }
 
-func MakeOutputDirectory(path string) (string, error) {
+func MakeOutputDirectory(path string, perm os.FileMode) (string, error) {
 	// get the current data + time using Go's stupid formatting
 	t := time.Now()
 	dirname := t.Format("2006-01-01 15:04:05")
 	final := path + "/" + dirname
 
-	// check if path is valid and directory
-	pathExists, err := PathExists(final)
-	if err != nil {
-		return final, fmt.Errorf("failed to check for existing path: %v", err)
+	// check if path is valid and directory (an existing directory is reused
+	// when collecting more than once in the same second)
+	info, err := os.Stat(final)
+	if err == nil && !info.IsDir() {
+		return final, fmt.Errorf("found existing path that is not a directory: %v", final)
 	}
-	if pathExists {
-		// make sure it is directory with 0o644 permissions
-		return final, fmt.Errorf("found existing path: %v", final)
+	if err != nil && !os.IsNotExist(err) {
+		return final, fmt.Errorf("failed to check for existing path: %v", err)
 	}
 
 	// create directory with data + time
-	err = os.MkdirAll(final, 0766)
+	err = os.MkdirAll(final, perm)
 	if err != nil {
 		return final, fmt.Errorf("failed to make directory: %v", err)
 	}
+
+	// make sure files can be written before collecting anything
+	file, err := os.CreateTemp(final, ".write-check-*")
+	if err != nil {
+		return final, fmt.Errorf("directory is not writable: %v", err)
+	}
+	file.Close()
+	os.Remove(file.Name())
 	return final, nil
 }
 
@@ -111,3 +122,13 @@ func FormatErrorList(errList []error) error {
 func HasErrors(errList []error) bool {
 	return len(errList) > 0
 }
+
+// SortedKeys returns the keys of a map in ascending order.
+func SortedKeys[T any](m map[string]T) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,51 @@
+package util
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMakeOutputDirectory(t *testing.T) {
+	dir := t.TempDir()
+	final, err := MakeOutputDirectory(dir, 0o700)
+	if err != nil {
+		t.Fatalf("failed to make output directory: %v", err)
+	}
+	if info, err := os.Stat(final); err != nil || !info.IsDir() {
+		t.Errorf("expected %s to be a directory: %v", final, err)
+	}
+
+	// the write check does not leave anything behind
+	entries, _ := os.ReadDir(final)
+	if len(entries) != 0 {
+		t.Errorf("expected the directory to be empty, got %d entries", len(entries))
+	}
+}
+
+func TestMakeOutputDirectoryUnwritable(t *testing.T) {
+	// a directory cannot be made under a file
+	file := filepath.Join(t.TempDir(), "file")
+	err := os.WriteFile(file, nil, 0o600)
+	if err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+	_, err = MakeOutputDirectory(file, 0o700)
+	if err == nil {
+		t.Errorf("expected an error for a path under a file")
+	}
+
+	// permissions do not apply to root
+	if os.Geteuid() == 0 {
+		t.Skip("skipping read-only directory as root")
+	}
+	readOnly := filepath.Join(t.TempDir(), "read-only")
+	err = os.Mkdir(readOnly, 0o500)
+	if err != nil {
+		t.Fatalf("failed to make directory: %v", err)
+	}
+	_, err = MakeOutputDirectory(readOnly, 0o700)
+	if err == nil {
+		t.Errorf("expected an error for a read-only directory")
+	}
+}
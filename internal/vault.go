@@ -0,0 +1,169 @@
+package magellan
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/OpenCHAMI/magellan/internal/util"
+)
+
+// CredentialProvider looks up the credentials of a BMC from an external
+// store. It is used instead of QueryParams.User and Pass when set.
+type CredentialProvider interface {
+	Get(host string) (user string, pass string, err error)
+}
+
+// VaultCredentialProvider reads BMC credentials from a HashiCorp Vault KV v2
+// secrets engine. The secret for each host is expected at
+// "<Mount>/data/<Path>/<host>" with "username" and "password" keys.
+//
+// Either Token or RoleID and SecretID (AppRole) must be set to log in. Tokens
+// from an AppRole login are renewed by logging in again before they expire or
+// once Vault rejects them.
+type VaultCredentialProvider struct {
+	Address  string
+	Mount    string
+	Path     string
+	Token    string
+	RoleID   string
+	SecretID string
+	Client   *http.Client
+
+	mu      sync.Mutex
+	expires time.Time // when the AppRole token has to be renewed (zero if never)
+}
+
+// NewVaultCredentialProvider makes a provider for the secrets under path.
+// The address and login are read from the standard VAULT_ADDR, VAULT_TOKEN,
+// VAULT_ROLE_ID, and VAULT_SECRET_ID environment variables when empty.
+func NewVaultCredentialProvider(address string, mount string, path string) (*VaultCredentialProvider, error) {
+	if address == "" {
+		address = os.Getenv("VAULT_ADDR")
+	}
+	if address == "" {
+		return nil, fmt.Errorf("no Vault address set")
+	}
+	if mount == "" {
+		mount = "secret"
+	}
+	p := &VaultCredentialProvider{
+		Address:  strings.TrimSuffix(address, "/"),
+		Mount:    strings.Trim(mount, "/"),
+		Path:     strings.Trim(path, "/"),
+		Token:    os.Getenv("VAULT_TOKEN"),
+		RoleID:   os.Getenv("VAULT_ROLE_ID"),
+		SecretID: os.Getenv("VAULT_SECRET_ID"),
+		Client:   &http.Client{Timeout: 30 * time.Second},
+	}
+	if p.Token == "" && (p.RoleID == "" || p.SecretID == "") {
+		return nil, fmt.Errorf("no Vault token or AppRole credentials set")
+	}
+	return p, nil
+}
+
+func (p *VaultCredentialProvider) Get(host string) (string, string, error) {
+	token, err := p.login()
+	if err != nil {
+		return "", "", err
+	}
+
+	url := fmt.Sprintf("%s/v1/%s/data/%s", p.Address, p.Mount, strings.TrimPrefix(p.Path+"/"+host, "/"))
+	res, b, err := util.MakeRequest(p.Client, url, http.MethodGet, nil, map[string]string{"X-Vault-Token": token})
+	if err != nil {
+		return "", "", fmt.Errorf("failed to read credentials from Vault: %v", err)
+	}
+
+	// the token may have expired or been revoked early so log in again once
+	if res.StatusCode == http.StatusForbidden && p.canLogin() {
+		p.expire(token)
+		token, err = p.login()
+		if err != nil {
+			return "", "", err
+		}
+		res, b, err = util.MakeRequest(p.Client, url, http.MethodGet, nil, map[string]string{"X-Vault-Token": token})
+		if err != nil {
+			return "", "", fmt.Errorf("failed to read credentials from Vault: %v", err)
+		}
+	}
+	if res.StatusCode != http.StatusOK {
+		return "", "", fmt.Errorf("failed to read credentials from Vault for '%s' (returned %s)", host, res.Status)
+	}
+
+	var secret struct {
+		Data struct {
+			Data struct {
+				Username string `json:"username"`
+				Password string `json:"password"`
+			} `json:"data"`
+		} `json:"data"`
+	}
+	err = json.Unmarshal(b, &secret)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to unmarshal Vault secret: %v", err)
+	}
+	return secret.Data.Data.Username, secret.Data.Data.Password, nil
+}
+
+// canLogin returns whether a new token can be requested with AppRole.
+func (p *VaultCredentialProvider) canLogin() bool {
+	return p.RoleID != "" && p.SecretID != ""
+}
+
+// expire drops token so the next call to login gets a new one. Nothing is
+// done if another request already replaced it.
+func (p *VaultCredentialProvider) expire(token string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.Token == token {
+		p.Token = ""
+	}
+}
+
+// login returns the token to use for requests, logging in with AppRole the
+// first time if no token was given or when the last one is about to expire.
+func (p *VaultCredentialProvider) login() (string, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.Token != "" && (p.expires.IsZero() || time.Now().Before(p.expires)) {
+		return p.Token, nil
+	}
+	if !p.canLogin() {
+		return "", fmt.Errorf("no Vault token or AppRole credentials to log in with")
+	}
+
+	body, err := json.Marshal(map[string]string{"role_id": p.RoleID, "secret_id": p.SecretID})
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal Vault login: %v", err)
+	}
+	res, b, err := util.MakeRequest(p.Client, p.Address+"/v1/auth/approle/login", http.MethodPost, body, nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to log in to Vault: %v", err)
+	}
+	if res.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("failed to log in to Vault (returned %s)", res.Status)
+	}
+
+	var login struct {
+		Auth struct {
+			ClientToken   string `json:"client_token"`
+			LeaseDuration int    `json:"lease_duration"` // in seconds (0 never expires)
+		} `json:"auth"`
+	}
+	err = json.Unmarshal(b, &login)
+	if err != nil {
+		return "", fmt.Errorf("failed to unmarshal Vault login: %v", err)
+	}
+	p.Token = login.Auth.ClientToken
+
+	// renew a little early so the token does not expire mid-request
+	p.expires = time.Time{}
+	if lease := time.Duration(login.Auth.LeaseDuration) * time.Second; lease > 0 {
+		p.expires = time.Now().Add(lease * 9 / 10)
+	}
+	return p.Token, nil
+}
@@ -0,0 +1,118 @@
+package magellan
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+// fakeVault is a Vault server with a KV v2 secret for each host under
+// "secret/bmc" that hands out a new token for every AppRole login. Tokens
+// revoked with revoke are rejected with 403.
+type fakeVault struct {
+	*httptest.Server
+
+	mu      sync.Mutex
+	logins  int
+	tokens  map[string]bool
+	secrets map[string]map[string]string
+}
+
+func newFakeVault(t *testing.T) *fakeVault {
+	v := &fakeVault{
+		tokens:  map[string]bool{},
+		secrets: map[string]map[string]string{},
+	}
+	v.Server = httptest.NewServer(v)
+	t.Cleanup(v.Close)
+	return v
+}
+
+// revoke rejects every token handed out so far.
+func (v *fakeVault) revoke() {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.tokens = map[string]bool{}
+}
+
+func (v *fakeVault) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if r.Method == http.MethodPost && r.URL.Path == "/v1/auth/approle/login" {
+		var login struct {
+			RoleID   string `json:"role_id"`
+			SecretID string `json:"secret_id"`
+		}
+		json.NewDecoder(r.Body).Decode(&login)
+		if login.RoleID != "magellan" || login.SecretID != "s3cr3t" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		v.logins++
+		token := fmt.Sprintf("token-%d", v.logins)
+		v.tokens[token] = true
+		json.NewEncoder(w).Encode(map[string]any{
+			"auth": map[string]any{"client_token": token, "lease_duration": 3600},
+		})
+		return
+	}
+	if !v.tokens[r.Header.Get("X-Vault-Token")] {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+	secret, ok := v.secrets[r.URL.Path]
+	if r.Method != http.MethodGet || !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]any{"data": map[string]any{"data": secret}})
+}
+
+func TestVaultCredentialProvider(t *testing.T) {
+	vault := newFakeVault(t)
+	vault.secrets["/v1/secret/data/bmc/10.0.0.1"] = map[string]string{"username": "admin", "password": "hunter2"}
+	t.Setenv("VAULT_TOKEN", "")
+	t.Setenv("VAULT_ROLE_ID", "magellan")
+	t.Setenv("VAULT_SECRET_ID", "s3cr3t")
+
+	p, err := NewVaultCredentialProvider(vault.URL, "", "/bmc/")
+	if err != nil {
+		t.Fatalf("failed to make provider: %v", err)
+	}
+	user, pass, err := p.Get("10.0.0.1")
+	if err != nil {
+		t.Fatalf("failed to get credentials: %v", err)
+	}
+	if user != "admin" || pass != "hunter2" {
+		t.Errorf("expected admin/hunter2, got %s/%s", user, pass)
+	}
+
+	// the token is reused until Vault rejects it, then renewed once
+	vault.revoke()
+	user, _, err = p.Get("10.0.0.1")
+	if err != nil {
+		t.Fatalf("failed to get credentials after the token was revoked: %v", err)
+	}
+	if user != "admin" || vault.logins != 2 {
+		t.Errorf("expected to log in again once, got %d logins", vault.logins)
+	}
+
+	// hosts without a secret are an error
+	_, _, err = p.Get("10.0.0.2")
+	if err == nil {
+		t.Errorf("expected an error for a host without credentials")
+	}
+}
+
+func TestNewVaultCredentialProviderLogin(t *testing.T) {
+	t.Setenv("VAULT_TOKEN", "")
+	t.Setenv("VAULT_ROLE_ID", "")
+	t.Setenv("VAULT_SECRET_ID", "")
+	_, err := NewVaultCredentialProvider("http://vault:8200", "", "bmc")
+	if err == nil {
+		t.Errorf("expected an error without a token or AppRole credentials")
+	}
+}
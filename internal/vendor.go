@@ -0,0 +1,88 @@
+package magellan
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+const (
+	VENDOR_DELL       = "Dell"
+	VENDOR_HPE        = "HPE"
+	VENDOR_LENOVO     = "Lenovo"
+	VENDOR_SUPERMICRO = "Supermicro"
+)
+
+// Quirks changes how the BMCs of a vendor are queried.
+type Quirks struct {
+	BasicAuth bool // use basic auth instead of a session
+	NoExpand  bool // never use $expand even when the BMC advertises it
+}
+
+// VendorQuirks lists the quirks of each vendor. Vendors that are not listed
+// are queried the same way as any other BMC.
+var VendorQuirks = map[string]Quirks{
+	// sessions run out quickly on iDRACs when collecting many sections
+	VENDOR_DELL: {BasicAuth: true},
+	// iLOs advertise $expand but time out on large expanded collections
+	VENDOR_HPE: {NoExpand: true},
+}
+
+// quirks returns the quirks of the vendor detected for the host in q.
+func (q *QueryParams) quirks() Quirks {
+	return VendorQuirks[q.vendor]
+}
+
+// DetectVendor reads the vendor of the BMC from the Redfish service root of
+// the session, which is requested without credentials. The Vendor property
+// is used when set (added in Redfish 1.5.0), otherwise the vendor is guessed
+// from the Oem and Product properties. An empty string is returned when the
+// vendor is not known.
+func DetectVendor(ctx context.Context, s *Session) (string, error) {
+	status, body, err := s.ServiceRoot(ctx)
+	if err != nil {
+		return "", err
+	}
+	if status != http.StatusOK {
+		return "", fmt.Errorf("service root returned status code %d", status)
+	}
+
+	var root struct {
+		Vendor  string
+		Product string
+		Oem     map[string]json.RawMessage
+	}
+	err = json.Unmarshal(body, &root)
+	if err != nil {
+		return "", fmt.Errorf("failed to unmarshal service root: %v", err)
+	}
+
+	if vendor := normalizeVendor(root.Vendor); vendor != "" {
+		return vendor, nil
+	}
+	for key := range root.Oem {
+		if vendor := normalizeVendor(key); vendor != "" {
+			return vendor, nil
+		}
+	}
+	return normalizeVendor(root.Product), nil
+}
+
+// normalizeVendor returns the vendor constant matching the name reported by
+// a BMC (i.e. "Dell Inc." or "Hpe") or an empty string when unknown.
+func normalizeVendor(name string) string {
+	name = strings.ToLower(name)
+	switch {
+	case strings.Contains(name, "dell") || strings.Contains(name, "idrac"):
+		return VENDOR_DELL
+	case strings.HasPrefix(name, "hp") || strings.Contains(name, "hewlett"):
+		return VENDOR_HPE
+	case strings.Contains(name, "lenovo") || strings.Contains(name, "xclarity"):
+		return VENDOR_LENOVO
+	case strings.Contains(name, "supermicro"):
+		return VENDOR_SUPERMICRO
+	}
+	return ""
+}
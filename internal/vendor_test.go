@@ -0,0 +1,88 @@
+package magellan
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+)
+
+func TestVendorQuirks(t *testing.T) {
+	// every BMC advertises $expand so the quirks decide whether it is used
+	expand := map[string]any{
+		"ExpandQuery": map[string]any{"ExpandAll": true, "Levels": true, "MaxLevels": 1},
+	}
+	tests := []struct {
+		name      string
+		root      map[string]any
+		vendor    string
+		basicAuth bool
+		expand    bool
+	}{
+		{"Dell", map[string]any{"Vendor": "Dell", "Product": "Integrated Dell Remote Access Controller"}, VENDOR_DELL, true, true},
+		{"HPE", map[string]any{"Oem": map[string]any{"Hpe": map[string]any{}}}, VENDOR_HPE, false, false},
+		{"unknown", map[string]any{"Vendor": "Acme"}, "", false, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			f := newRedfishFixture(t)
+			f.merge("/redfish/v1", test.root)
+			f.merge("/redfish/v1", map[string]any{"ProtocolFeaturesSupported": expand})
+			q := f.params(t)
+
+			// forget earlier fixtures that listened on the same port
+			expandSupport.Delete(expandKey(q))
+
+			vendor, err := DetectVendor(context.Background(), NewSession(testLogger(), q))
+			if err != nil {
+				t.Fatalf("failed to detect vendor: %v", err)
+			}
+			if vendor != test.vendor {
+				t.Fatalf("expected vendor %q, got %q", test.vendor, vendor)
+			}
+			q.vendor = vendor
+
+			config, err := makeGofishConfig(q, nil)
+			if err != nil {
+				t.Fatalf("failed to make gofish config: %v", err)
+			}
+			if config.BasicAuth != test.basicAuth {
+				t.Errorf("expected basic auth to be %v", test.basicAuth)
+			}
+			c := f.connect(t, q)
+			if c.Service.ProtocolFeaturesSupported.ExpandQuery.ExpandAll != test.expand {
+				t.Errorf("expected $expand to be %v", test.expand)
+			}
+
+			// basic auth never opens a session
+			logins, _ := f.sessions()
+			if test.basicAuth && logins != 0 || !test.basicAuth && logins != 1 {
+				t.Errorf("unexpected number of sessions with basic auth %v: %d", test.basicAuth, logins)
+			}
+		})
+	}
+}
+
+func TestCollectAllVendor(t *testing.T) {
+	f := newRedfishFixture(t)
+	f.merge("/redfish/v1", map[string]any{"Vendor": "Dell"})
+	q := f.params(t)
+	q.VendorQuirks = true
+	host, port := f.hostPort()
+	states := []ScannedResult{{Host: host, Port: port, Protocol: "http", State: true}}
+
+	results, err := CollectAll(context.Background(), &states, testLogger(), q)
+	if err != nil {
+		t.Fatalf("failed to collect: %v", err)
+	}
+	if len(results) != 1 || !results[0].Success {
+		t.Fatalf("expected the host to be collected, got %+v", results)
+	}
+	var data map[string]any
+	err = json.Unmarshal(results[0].Payload, &data)
+	if err != nil {
+		t.Fatalf("failed to unmarshal payload: %v", err)
+	}
+	if data["Vendor"] != VENDOR_DELL {
+		t.Errorf("expected vendor %q in the output, got %v", VENDOR_DELL, data["Vendor"])
+	}
+}
@@ -0,0 +1,197 @@
+package magellan
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/Cray-HPE/hms-xname/xnames"
+	"github.com/OpenCHAMI/magellan/internal/log"
+)
+
+// XnameGenerator creates the xname used as the ID of a BMC in SMD. The data
+// that will be sent to SMD is passed in so generators can use what was
+// collected (i.e. the FQDN or system info) to derive the name.
+type XnameGenerator interface {
+	Generate(result ScannedResult, data map[string]any) (string, error)
+}
+
+// XnameReserver is implemented by generators that number hosts. CollectAll
+// reserves each host in the order they are dispatched so the numbers do not
+// depend on which worker finishes first.
+type XnameReserver interface {
+	Reserve(host string)
+}
+
+// NewXnameGenerator returns one of the built-in generators by name. The
+// "map" generator uses the static mapping and falls back to "sequential"
+// for hosts not found in it (which are logged with l if not nil). An empty
+// name picks "map" if a mapping is given and "sequential" otherwise.
+func NewXnameGenerator(name string, mapping map[string]string, l *log.Logger) (XnameGenerator, error) {
+	switch strings.ToLower(name) {
+	case "":
+		if len(mapping) > 0 {
+			return &MappedXnameGenerator{Mapping: mapping, Fallback: NewSequentialXnameGenerator(), Logger: l}, nil
+		}
+		return NewSequentialXnameGenerator(), nil
+	case "sequential":
+		return NewSequentialXnameGenerator(), nil
+	case "ip":
+		return &IPXnameGenerator{Cabinet: 1000, Chassis: 1}, nil
+	case "map":
+		if len(mapping) <= 0 {
+			return nil, fmt.Errorf("xname generator 'map' requires an xname map")
+		}
+		return &MappedXnameGenerator{Mapping: mapping, Fallback: NewSequentialXnameGenerator(), Logger: l}, nil
+	default:
+		return nil, fmt.Errorf("unknown xname generator '%s' (must be 'sequential', 'ip', or 'map')", name)
+	}
+}
+
+// SequentialXnameGenerator numbers BMCs in the order they are reserved (or
+// generated if not reserved) within a single cabinet, chassis, and slot. It
+// is safe to share between goroutines.
+type SequentialXnameGenerator struct {
+	Cabinet       int
+	Chassis       int
+	ComputeModule int
+
+	mu      sync.Mutex
+	next    int
+	indexes map[string]int
+}
+
+// NewSequentialXnameGenerator returns a generator starting at x1000c1s7b0.
+func NewSequentialXnameGenerator() *SequentialXnameGenerator {
+	return &SequentialXnameGenerator{
+		Cabinet:       1000,
+		Chassis:       1,
+		ComputeModule: 7,
+	}
+}
+
+// Reserve assigns the next number to the host if it does not have one yet.
+func (g *SequentialXnameGenerator) Reserve(host string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.reserve(host)
+}
+
+func (g *SequentialXnameGenerator) reserve(host string) int {
+	if g.indexes == nil {
+		g.indexes = map[string]int{}
+	}
+	index, ok := g.indexes[host]
+	if !ok {
+		index = g.next
+		g.indexes[host] = index
+		g.next += 1
+	}
+	return index
+}
+
+func (g *SequentialXnameGenerator) Generate(result ScannedResult, data map[string]any) (string, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	bmc := xnames.NodeBMC{
+		Cabinet:       g.Cabinet,
+		Chassis:       g.Chassis,
+		ComputeModule: g.ComputeModule,
+		NodeBMC:       g.reserve(result.Host),
+	}
+	return bmc.String(), nil
+}
+
+// IPXnameGenerator derives the slot and BMC from the last two octets of the
+// host's IPv4 address (i.e. 10.1.7.2 -> x1000c1s7b2).
+type IPXnameGenerator struct {
+	Cabinet int
+	Chassis int
+}
+
+func (g *IPXnameGenerator) Generate(result ScannedResult, data map[string]any) (string, error) {
+	ip := net.ParseIP(result.Host).To4()
+	if ip == nil {
+		return "", fmt.Errorf("host '%s' is not an IPv4 address", result.Host)
+	}
+	bmc := xnames.NodeBMC{
+		Cabinet:       g.Cabinet,
+		Chassis:       g.Chassis,
+		ComputeModule: int(ip[2]),
+		NodeBMC:       int(ip[3]),
+	}
+	return bmc.String(), nil
+}
+
+// MappedXnameGenerator looks up the xname of the host in a static mapping
+// (see LoadXnameMap). Hosts not in the mapping are passed to the fallback
+// (with a warning logged to Logger if set) or are an error if there is none.
+type MappedXnameGenerator struct {
+	Mapping  map[string]string
+	Fallback XnameGenerator
+	Logger   *log.Logger
+}
+
+// Reserve passes hosts that are not in the mapping to the fallback.
+func (g *MappedXnameGenerator) Reserve(host string) {
+	if _, ok := g.Mapping[host]; ok {
+		return
+	}
+	if reserver, ok := g.Fallback.(XnameReserver); ok {
+		reserver.Reserve(host)
+	}
+}
+
+func (g *MappedXnameGenerator) Generate(result ScannedResult, data map[string]any) (string, error) {
+	if xname, ok := g.Mapping[result.Host]; ok {
+		return xname, nil
+	}
+	if g.Fallback == nil {
+		return "", fmt.Errorf("host '%s' not found in xname map", result.Host)
+	}
+	xname, err := g.Fallback.Generate(result, data)
+	if err == nil && g.Logger != nil {
+		g.Logger.Log.Warnf("host '%s' not found in xname map (using '%s')", result.Host, xname)
+	}
+	return xname, err
+}
+
+// LoadXnameMap reads a static host to xname mapping from either a JSON file
+// containing a single object or a CSV file with "host,xname" rows. The format
+// is picked using the file extension.
+func LoadXnameMap(path string) (map[string]string, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read xname map: %v", err)
+	}
+
+	mapping := map[string]string{}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		err = json.Unmarshal(b, &mapping)
+		if err != nil {
+			return nil, fmt.Errorf("failed to unmarshal xname map: %v", err)
+		}
+	case ".csv":
+		reader := csv.NewReader(strings.NewReader(string(b)))
+		reader.Comment = '#'
+		reader.FieldsPerRecord = 2
+		reader.TrimLeadingSpace = true
+		rows, err := reader.ReadAll()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read xname map CSV: %v", err)
+		}
+		for _, row := range rows {
+			mapping[strings.TrimSpace(row[0])] = strings.TrimSpace(row[1])
+		}
+	default:
+		return nil, fmt.Errorf("unsupported xname map format (must be .json or .csv)")
+	}
+
+	return mapping, nil
+}
@@ -0,0 +1,109 @@
+package magellan
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/OpenCHAMI/magellan/internal/log"
+	"github.com/sirupsen/logrus"
+)
+
+func TestSequentialXnameGenerator(t *testing.T) {
+	g, err := NewXnameGenerator("sequential", nil, nil)
+	if err != nil {
+		t.Fatalf("failed to make generator: %v", err)
+	}
+
+	// hosts are numbered in the order they are reserved, not generated
+	g.(XnameReserver).Reserve("10.0.0.2")
+	g.(XnameReserver).Reserve("10.0.0.1")
+	tests := []struct {
+		host  string
+		xname string
+	}{
+		{"10.0.0.1", "x1000c1s7b1"},
+		{"10.0.0.2", "x1000c1s7b0"},
+		{"10.0.0.3", "x1000c1s7b2"},
+		{"10.0.0.1", "x1000c1s7b1"},
+	}
+	for _, test := range tests {
+		xname, err := g.Generate(ScannedResult{Host: test.host}, nil)
+		if err != nil {
+			t.Fatalf("failed to generate xname: %v", err)
+		}
+		if xname != test.xname {
+			t.Errorf("expected %s for %s, got %s", test.xname, test.host, xname)
+		}
+	}
+}
+
+func TestIPXnameGenerator(t *testing.T) {
+	g, err := NewXnameGenerator("ip", nil, nil)
+	if err != nil {
+		t.Fatalf("failed to make generator: %v", err)
+	}
+	xname, err := g.Generate(ScannedResult{Host: "10.1.7.2"}, nil)
+	if err != nil {
+		t.Fatalf("failed to generate xname: %v", err)
+	}
+	if xname != "x1000c1s7b2" {
+		t.Errorf("expected x1000c1s7b2, got %s", xname)
+	}
+	_, err = g.Generate(ScannedResult{Host: "bmc01"}, nil)
+	if err == nil {
+		t.Errorf("expected an error for a host that is not an IPv4 address")
+	}
+}
+
+func TestMappedXnameGenerator(t *testing.T) {
+	var buf bytes.Buffer
+	logger := logrus.New()
+	logger.SetOutput(&buf)
+	mapping := map[string]string{"10.0.0.1": "x3000c0s1b0"}
+	g, err := NewXnameGenerator("map", mapping, &log.Logger{Log: logger})
+	if err != nil {
+		t.Fatalf("failed to make generator: %v", err)
+	}
+
+	xname, err := g.Generate(ScannedResult{Host: "10.0.0.1"}, nil)
+	if err != nil || xname != "x3000c0s1b0" {
+		t.Errorf("expected the mapped xname, got %s (%v)", xname, err)
+	}
+	if buf.Len() != 0 {
+		t.Errorf("expected no warning for a mapped host, got %q", buf.String())
+	}
+
+	// hosts not in the map fall back to numbering with a warning
+	xname, err = g.Generate(ScannedResult{Host: "10.0.0.2"}, nil)
+	if err != nil || xname != "x1000c1s7b0" {
+		t.Errorf("expected the fallback xname, got %s (%v)", xname, err)
+	}
+	if !strings.Contains(buf.String(), "host '10.0.0.2' not found in xname map") {
+		t.Errorf("expected a warning for the unmapped host, got %q", buf.String())
+	}
+
+	// without a fallback unmapped hosts are an error
+	g = &MappedXnameGenerator{Mapping: mapping}
+	_, err = g.Generate(ScannedResult{Host: "10.0.0.2"}, nil)
+	if err == nil {
+		t.Errorf("expected an error for an unmapped host without a fallback")
+	}
+}
+
+func TestNewXnameGenerator(t *testing.T) {
+	if _, err := NewXnameGenerator("map", nil, nil); err == nil {
+		t.Errorf("expected an error for 'map' without a mapping")
+	}
+	if _, err := NewXnameGenerator("random", nil, nil); err == nil {
+		t.Errorf("expected an error for an unknown generator")
+	}
+	g, _ := NewXnameGenerator("", map[string]string{"10.0.0.1": "x3000c0s1b0"}, nil)
+	if _, ok := g.(*MappedXnameGenerator); !ok {
+		t.Errorf("expected 'map' to be picked when given a mapping, got %T", g)
+	}
+	g, _ = NewXnameGenerator("", nil, nil)
+	if _, ok := g.(*SequentialXnameGenerator); !ok {
+		t.Errorf("expected 'sequential' to be picked without a mapping, got %T", g)
+	}
+}